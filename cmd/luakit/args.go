@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/kasuboski/luakit/pkg/luavm"
+)
+
+// listScriptArgs writes the build arguments the script named in os.Args
+// declares with bk.arg. Without --target, the default export and every
+// target are evaluated so their arguments are listed too.
+func listScriptArgs(w io.Writer) error {
+	flags := parseValidateFlags()
+
+	args := getScriptArg()
+	if args.script == "" {
+		return fmt.Errorf("missing script file\nUsage: luakit validate --list-args [--target <name>] <script>")
+	}
+
+	var specs []luavm.ArgSpec
+	seen := make(map[string]bool)
+
+	evaluate := func(target string) (*luavm.EvalResult, error) {
+		config := createVMConfig(args.script)
+		config.Target = target
+
+		result, err := luavm.EvaluateFile(args.script, config)
+		if err != nil {
+			return nil, err
+		}
+		for _, spec := range result.Args {
+			if !seen[spec.Name] {
+				seen[spec.Name] = true
+				specs = append(specs, spec)
+			}
+		}
+		return result, nil
+	}
+
+	result, err := evaluate(flags.target)
+	if err != nil {
+		return err
+	}
+	if flags.target == "" {
+		for _, target := range result.Targets {
+			if target == result.Target {
+				continue
+			}
+			if _, err := evaluate(target); err != nil {
+				return fmt.Errorf("target %q: %w", target, err)
+			}
+		}
+	}
+
+	if len(specs) == 0 {
+		_, err := fmt.Fprintln(w, "No build arguments declared")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAME\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, spec := range specs {
+		def := "-"
+		if spec.HasDefault {
+			def = fmt.Sprintf("%q", spec.Default)
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", spec.Name, spec.Type, def, spec.Description)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListScriptArgs(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "build.lua")
+
+	script := `local version = bk.arg("VERSION", { default = "1.0", description = "Version to build" })
+
+bk.target("dev", function()
+    bk.export(bk.image("alpine:" .. version))
+end)
+
+bk.target("release", function()
+    local push = bk.arg("PUSH", { type = "bool" })
+    bk.export(bk.image("alpine:" .. version))
+end)
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "validate", "--list-args", scriptPath}
+	var out bytes.Buffer
+	if err := listScriptArgs(&out); err != nil {
+		t.Fatalf("listScriptArgs failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 args, got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[1], "VERSION") || !strings.Contains(lines[1], `"1.0"`) || !strings.Contains(lines[1], "Version to build") {
+		t.Errorf("unexpected VERSION line %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "PUSH") || !strings.Contains(lines[2], "bool") {
+		t.Errorf("expected PUSH from the release target, got %q", lines[2])
+	}
+
+	os.Args = []string{"luakit", "validate", "--list-args", "--target", "dev", scriptPath}
+	out.Reset()
+	if err := listScriptArgs(&out); err != nil {
+		t.Fatalf("listScriptArgs failed: %v", err)
+	}
+	if strings.Contains(out.String(), "PUSH") {
+		t.Errorf("expected only the dev target's args, got:\n%s", out.String())
+	}
+}
+
+func TestParseValidateFlagsListArgs(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "validate", "--list-args", "build.lua"}
+
+	if !parseValidateFlags().listArgs {
+		t.Error("expected listArgs to be set")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kasuboski/luakit/pkg/convert"
+)
+
+func handleConvert() {
+	if err := convertDockerfile(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+type convertFlags struct {
+	outputPath string
+}
+
+func parseConvertFlags() (*convertFlags, error) {
+	flags := &convertFlags{}
+
+	args := os.Args[2:]
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "--output" || arg == "-o":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			flags.outputPath = args[i+1]
+			i += 2
+		case len(arg) > 9 && arg[:9] == "--output=":
+			flags.outputPath = arg[9:]
+			i++
+		case arg == "--help" || arg == "-h":
+			fmt.Fprintf(os.Stderr, `luakit convert - Convert a Dockerfile into a Lua build script
+
+USAGE:
+    luakit convert [flags] [Dockerfile]
+
+Reads the Dockerfile (default: ./Dockerfile) and prints an equivalent
+build.lua. Instructions without a luakit equivalent are emitted as TODO
+comments that reference the Dockerfile line.
+
+FLAGS:
+    --output, -o <path>         Write the script to file (default: stdout)
+    --help, -h                  Show this help message
+
+EXAMPLES:
+    luakit convert > build.lua
+    luakit convert -o build.lua docker/Dockerfile
+`)
+			os.Exit(0)
+		default:
+			i++
+		}
+	}
+
+	return flags, nil
+}
+
+// convertDockerfile converts the Dockerfile named in os.Args and writes the
+// script to the --output file, or to w when none is given.
+func convertDockerfile(w io.Writer) error {
+	flags, err := parseConvertFlags()
+	if err != nil {
+		return err
+	}
+
+	dockerfile := getScriptArg().script
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+
+	data, err := os.ReadFile(dockerfile) // #nosec G304 -- Path is the user-provided Dockerfile
+	if err != nil {
+		return fmt.Errorf("failed to read Dockerfile: %w", err)
+	}
+
+	script, err := convert.Dockerfile(data, dockerfile)
+	if err != nil {
+		return err
+	}
+
+	if flags.outputPath == "" {
+		_, err = w.Write(script)
+		return err
+	}
+	if err := os.WriteFile(flags.outputPath, script, 0644); err != nil { // #nosec G306 -- Build scripts are not secret
+		return fmt.Errorf("failed to write %s: %w", flags.outputPath, err)
+	}
+	fmt.Fprintf(os.Stderr, "✓ Wrote %s\n", flags.outputPath)
+	return nil
+}
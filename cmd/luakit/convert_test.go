@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertDockerfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	dockerfile := filepath.Join(tmpDir, "Dockerfile")
+	if err := os.WriteFile(dockerfile, []byte("FROM alpine:3.19\nRUN apk add curl\n"), 0644); err != nil {
+		t.Fatalf("failed to write Dockerfile: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "convert", dockerfile}
+	var out bytes.Buffer
+	if err := convertDockerfile(&out); err != nil {
+		t.Fatalf("convertDockerfile failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `final = final:run("apk add curl")`) {
+		t.Errorf("unexpected script:\n%s", out.String())
+	}
+
+	outputPath := filepath.Join(tmpDir, "build.lua")
+	os.Args = []string{"luakit", "convert", "-o", outputPath, dockerfile}
+	out.Reset()
+	if err := convertDockerfile(&out); err != nil {
+		t.Fatalf("convertDockerfile failed: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected nothing on stdout with --output, got:\n%s", out.String())
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if !strings.Contains(string(data), `bk.image("alpine:3.19")`) {
+		t.Errorf("unexpected script:\n%s", data)
+	}
+}
+
+func TestConvertDockerfileMissing(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "convert", filepath.Join(t.TempDir(), "Dockerfile")}
+
+	if err := convertDockerfile(&bytes.Buffer{}); err == nil {
+		t.Error("expected error for missing Dockerfile")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/kasuboski/luakit/pkg/luafmt"
+)
+
+func handleFmt() {
+	unformatted, err := formatScripts(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if unformatted > 0 {
+		os.Exit(1)
+	}
+}
+
+type fmtFlags struct {
+	check bool
+	diff  bool
+	paths []string
+}
+
+func parseFmtFlags() (*fmtFlags, error) {
+	flags := &fmtFlags{}
+
+	for _, arg := range os.Args[2:] {
+		switch {
+		case arg == "--check":
+			flags.check = true
+		case arg == "--diff" || arg == "-d":
+			flags.diff = true
+		case arg == "--help" || arg == "-h":
+			fmt.Fprintf(os.Stderr, `luakit fmt - Format Lua build scripts
+
+USAGE:
+    luakit fmt [flags] [path ...]
+
+Rewrites each script in the canonical luakit style. Directories are searched
+for .lua files; the default path is the current directory.
+
+FLAGS:
+    --check                     List files that are not formatted and exit 1
+    --diff, -d                  Print a diff instead of rewriting files
+    --help, -h                  Show this help message
+
+EXAMPLES:
+    luakit fmt build.lua
+    luakit fmt --check .
+    luakit fmt --diff build.lua
+`)
+			os.Exit(0)
+		case strings.HasPrefix(arg, "-"):
+			return nil, fmt.Errorf("unknown flag: %s", arg)
+		default:
+			flags.paths = append(flags.paths, arg)
+		}
+	}
+
+	if len(flags.paths) == 0 {
+		flags.paths = []string{"."}
+	}
+	return flags, nil
+}
+
+// formatScripts formats the scripts named in os.Args. With --check or
+// --diff nothing is rewritten; the names or diffs of unformatted files are
+// written to w. It returns how many files --check found unformatted.
+func formatScripts(w io.Writer) (int, error) {
+	flags, err := parseFmtFlags()
+	if err != nil {
+		return 0, err
+	}
+
+	files, err := luaFiles(flags.paths)
+	if err != nil {
+		return 0, err
+	}
+
+	unformatted := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file) // #nosec G304 -- Path is a user-provided script
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		formatted, err := luafmt.Source(src, file)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+
+		if flags.check {
+			unformatted++
+			if !flags.diff {
+				fmt.Fprintln(w, file)
+			}
+		}
+		if flags.diff {
+			diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(string(src)),
+				B:        difflib.SplitLines(string(formatted)),
+				FromFile: file,
+				ToFile:   file,
+				Context:  3,
+			})
+			if err != nil {
+				return 0, err
+			}
+			fmt.Fprint(w, diff)
+		}
+		if flags.check || flags.diff {
+			continue
+		}
+
+		if err := os.WriteFile(file, formatted, 0644); err != nil { // #nosec G306 -- Build scripts are not secret
+			return 0, fmt.Errorf("failed to write %s: %w", file, err)
+		}
+		fmt.Fprintf(os.Stderr, "✓ Formatted %s\n", file)
+	}
+	return unformatted, nil
+}
+
+// luaFiles expands directories in paths to the .lua files below them,
+// skipping hidden directories.
+func luaFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if p != path && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if filepath.Ext(p) == ".lua" {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatScripts(t *testing.T) {
+	tmpDir := t.TempDir()
+	script := filepath.Join(tmpDir, "build.lua")
+	unformatted := "local base=bk.image 'alpine'\nbk.export(base)\n"
+	want := "local base = bk.image(\"alpine\")\nbk.export(base)\n"
+	if err := os.WriteFile(script, []byte(unformatted), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "fmt", "--check", tmpDir}
+	var out bytes.Buffer
+	n, err := formatScripts(&out)
+	if err != nil {
+		t.Fatalf("formatScripts failed: %v", err)
+	}
+	if n != 1 || strings.TrimSpace(out.String()) != script {
+		t.Errorf("--check: got %d files, output %q", n, out.String())
+	}
+
+	os.Args = []string{"luakit", "fmt", "--diff", script}
+	out.Reset()
+	if _, err := formatScripts(&out); err != nil {
+		t.Fatalf("formatScripts failed: %v", err)
+	}
+	if !strings.Contains(out.String(), `+local base = bk.image("alpine")`) {
+		t.Errorf("--diff: unexpected output:\n%s", out.String())
+	}
+
+	data, _ := os.ReadFile(script)
+	if string(data) != unformatted {
+		t.Fatalf("--check and --diff must not rewrite the file, got:\n%s", data)
+	}
+
+	os.Args = []string{"luakit", "fmt", script}
+	if _, err := formatScripts(&bytes.Buffer{}); err != nil {
+		t.Fatalf("formatScripts failed: %v", err)
+	}
+	data, _ = os.ReadFile(script)
+	if string(data) != want {
+		t.Errorf("unexpected formatted script:\n%s", data)
+	}
+
+	os.Args = []string{"luakit", "fmt", "--check", script}
+	if n, err := formatScripts(&bytes.Buffer{}); err != nil || n != 0 {
+		t.Errorf("--check on formatted file: got %d, %v", n, err)
+	}
+}
+
+func TestFormatScriptsSyntaxError(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "build.lua")
+	if err := os.WriteFile(script, []byte("local = 1\n"), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "fmt", script}
+
+	if _, err := formatScripts(&bytes.Buffer{}); err == nil {
+		t.Error("expected error for invalid script")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/kasuboski/luakit/pkg/lint"
+	"github.com/kasuboski/luakit/pkg/luavm"
+)
+
+func handleLint() {
+	findings, err := lintScript(os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if findings > 0 {
+		os.Exit(1)
+	}
+}
+
+type lintFlags struct {
+	configPath string
+	format     string
+	target     string
+}
+
+func parseLintFlags() (*lintFlags, error) {
+	flags := &lintFlags{
+		format: "text",
+	}
+
+	args := os.Args[2:]
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "--config" || arg == "--format" || arg == "--target":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", arg)
+			}
+			flags.set(arg, args[i+1])
+			i += 2
+		case len(arg) > 9 && arg[:9] == "--config=":
+			flags.set("--config", arg[9:])
+			i++
+		case len(arg) > 9 && arg[:9] == "--format=":
+			flags.set("--format", arg[9:])
+			i++
+		case len(arg) > 9 && arg[:9] == "--target=":
+			flags.set("--target", arg[9:])
+			i++
+		case arg == "--help" || arg == "-h":
+			fmt.Fprintf(os.Stderr, `luakit lint - Check a script for common build problems
+
+USAGE:
+    luakit lint [flags] <script>
+
+FLAGS:
+    --config <path>             Lint config file (default: %s next to the script)
+    --format <text|json>        Output format (default: text)
+    --target <name>             Lint the named bk.target
+    --help, -h                  Show this help message
+
+RULES:
+`, lint.DefaultConfigFile)
+			for _, rule := range lint.Rules() {
+				fmt.Fprintf(os.Stderr, "    %-27s %s\n", rule.ID, rule.Description)
+			}
+			os.Exit(0)
+		default:
+			i++
+		}
+	}
+
+	if flags.format != "text" && flags.format != "json" {
+		return nil, fmt.Errorf("--format must be 'text' or 'json'")
+	}
+
+	return flags, nil
+}
+
+func (f *lintFlags) set(flag, value string) {
+	switch flag {
+	case "--config":
+		f.configPath = value
+	case "--format":
+		f.format = value
+	case "--target":
+		f.target = value
+	}
+}
+
+// lintScript evaluates the script named in os.Args, writes the findings to w
+// and returns how many were found.
+func lintScript(w io.Writer) (int, error) {
+	flags, err := parseLintFlags()
+	if err != nil {
+		return 0, err
+	}
+
+	args := getScriptArg()
+	if args.script == "" {
+		return 0, fmt.Errorf("missing script file\nUsage: luakit lint [flags] <script>")
+	}
+
+	configPath := flags.configPath
+	if configPath == "" {
+		candidate := filepath.Join(filepath.Dir(args.script), lint.DefaultConfigFile)
+		if _, err := os.Stat(candidate); err == nil {
+			configPath = candidate
+		}
+	}
+
+	var config *lint.Config
+	if configPath != "" {
+		config, err = lint.LoadConfig(configPath)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	vmConfig := createVMConfig(args.script)
+	vmConfig.Target = flags.target
+
+	result, err := luavm.EvaluateFile(args.script, vmConfig)
+	if err != nil {
+		return 0, err
+	}
+
+	findings := lint.New(config, result.SourceFiles).Run(result.States()...)
+
+	switch flags.format {
+	case "json":
+		if findings == nil {
+			findings = []lint.Finding{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(findings); err != nil {
+			return 0, fmt.Errorf("failed to write findings: %w", err)
+		}
+	default:
+		for _, f := range findings {
+			fmt.Fprintln(w, f.String())
+		}
+	}
+
+	return len(findings), nil
+}
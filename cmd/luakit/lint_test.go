@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kasuboski/luakit/pkg/lint"
+)
+
+func TestLintScript(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "build.lua")
+
+	script := `local base = bk.image("alpine:3.19")
+bk.export(base:run("apk add curl", { network = "host" }))
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "lint", scriptPath}
+
+	var out bytes.Buffer
+	n, err := lintScript(&out)
+	if err != nil {
+		t.Fatalf("lintScript failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 findings, got %d:\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "build.lua:1: [image-digest]") {
+		t.Errorf("expected image-digest finding on line 1, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "build.lua:2: [network-host]") {
+		t.Errorf("expected network-host finding on line 2, got:\n%s", out.String())
+	}
+}
+
+func TestLintScriptJSONAndConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "build.lua")
+
+	script := `bk.export(bk.image("alpine:3.19"):run("echo hi", { network = "host" }))
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+	config := `{"rules": {"image-digest": false}}`
+	if err := os.WriteFile(filepath.Join(tmpDir, lint.DefaultConfigFile), []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write lint config: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "lint", "--format=json", scriptPath}
+
+	var out bytes.Buffer
+	if _, err := lintScript(&out); err != nil {
+		t.Fatalf("lintScript failed: %v", err)
+	}
+
+	var findings []lint.Finding
+	if err := json.Unmarshal(out.Bytes(), &findings); err != nil {
+		t.Fatalf("failed to parse JSON output: %v\n%s", err, out.String())
+	}
+	if len(findings) != 1 || findings[0].Rule != "network-host" {
+		t.Errorf("expected only network-host finding, got %v", findings)
+	}
+}
+
+func TestLintScriptClean(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "build.lua")
+
+	script := `bk.export(bk.scratch())
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "lint", "--format", "json", scriptPath}
+
+	var out bytes.Buffer
+	n, err := lintScript(&out)
+	if err != nil {
+		t.Fatalf("lintScript failed: %v", err)
+	}
+	if n != 0 {
+		t.Errorf("expected no findings, got %d", n)
+	}
+	if strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("expected empty JSON array, got %q", out.String())
+	}
+}
+
+func TestParseLintFlagsInvalidFormat(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "lint", "--format=xml", "build.lua"}
+
+	if _, err := parseLintFlags(); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
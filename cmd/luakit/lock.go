@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kasuboski/luakit/pkg/lockfile"
+	"github.com/kasuboski/luakit/pkg/luavm"
+	"github.com/kasuboski/luakit/pkg/resolver"
+)
+
+func handleLock() {
+	flags := parseLockFlags()
+
+	args := getScriptArg()
+	if args.script == "" {
+		fmt.Fprintln(os.Stderr, "error: missing script file")
+		fmt.Fprintln(os.Stderr, "Usage: luakit lock [flags] <script>")
+		os.Exit(1)
+	}
+
+	gen := lockfile.NewGenerator(resolver.NewResolver())
+	lock, err := lockScript(context.Background(), args.script, flags.platform, gen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := lockfilePath(args.script)
+	if err := lock.Write(path); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✓ Wrote %s (%d images, %d git, %d http)\n", path, len(lock.Images), len(lock.Git), len(lock.HTTP))
+}
+
+type lockFlags struct {
+	platform string
+}
+
+func parseLockFlags() *lockFlags {
+	flags := &lockFlags{}
+
+	args := os.Args[2:]
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "--platform":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --platform requires a value\n")
+				os.Exit(1)
+			}
+			flags.platform = args[i+1]
+			i += 2
+		case len(arg) > 11 && arg[:11] == "--platform=":
+			flags.platform = arg[11:]
+			i++
+		case arg == "--help" || arg == "-h":
+			fmt.Fprintf(os.Stderr, `luakit lock - Pin image digests, git commits and checksums
+
+USAGE:
+    luakit lock [flags] <script>
+
+Evaluates the script and every bk.target it defines, resolves each image,
+git and HTTP source and writes %s next to the script.
+
+FLAGS:
+    --platform <os/arch,...>    Platforms to lock for multi-platform exports
+    --help, -h                  Show this help message
+
+EXAMPLES:
+    luakit lock build.lua
+    luakit lock --platform linux/amd64,linux/arm64 build.lua
+`, lockfile.FileName)
+			os.Exit(0)
+		default:
+			if arg[0] == '-' {
+				fmt.Fprintf(os.Stderr, "error: unknown flag: %s\n", arg) // #nosec G705 -- CLI tool output to stderr
+				os.Exit(1)
+			}
+			i++
+		}
+	}
+
+	return flags
+}
+
+// lockScript evaluates the default export and every target of the script and
+// resolves their sources into a new lockfile.
+func lockScript(ctx context.Context, script, platform string, gen *lockfile.Generator) (*lockfile.Lockfile, error) {
+	platforms, err := luavm.ParsePlatforms(platform)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := lockfile.New()
+
+	evaluate := func(target string) (*luavm.EvalResult, error) {
+		config := createVMConfig(script)
+		config.Target = target
+		config.Platforms = platforms
+
+		result, err := luavm.EvaluateFile(script, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := gen.Lock(ctx, lock, result.States()...); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	result, err := evaluate("")
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range result.Targets {
+		if target == result.Target {
+			continue
+		}
+		if _, err := evaluate(target); err != nil {
+			return nil, fmt.Errorf("target %q: %w", target, err)
+		}
+	}
+
+	return lock, nil
+}
+
+func lockfilePath(script string) string {
+	return filepath.Join(filepath.Dir(script), lockfile.FileName)
+}
+
+// applyLockfile pins the sources of result using the lockfile next to the
+// script. Without a lockfile the build is left floating unless frozen.
+func applyLockfile(script string, result *luavm.EvalResult, frozen bool) error {
+	path := lockfilePath(script)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if frozen {
+			return fmt.Errorf("--frozen: %s not found; run luakit lock", path)
+		}
+		return nil
+	}
+
+	lock, err := lockfile.Load(path)
+	if err != nil {
+		return err
+	}
+	return lock.Apply(frozen, result.States()...)
+}
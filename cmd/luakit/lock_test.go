@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kasuboski/luakit/pkg/lockfile"
+	"github.com/kasuboski/luakit/pkg/luavm"
+	"github.com/kasuboski/luakit/pkg/resolver"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type fakeImageResolver struct{}
+
+func (fakeImageResolver) Resolve(ctx context.Context, ref string, platform ocispec.Platform) (*resolver.ImageConfig, error) {
+	return &resolver.ImageConfig{
+		Ref:      ref,
+		Digest:   "sha256:" + strings.Repeat("a", 64),
+		Platform: platform,
+	}, nil
+}
+
+func TestLockScriptCoversTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "build.lua")
+
+	script := `bk.target("dev", function()
+	bk.export(bk.image("alpine:3.19"))
+end)
+
+bk.target("prod", function()
+	bk.export(bk.image("debian:bookworm"))
+end)
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	gen := &lockfile.Generator{Images: fakeImageResolver{}}
+	lock, err := lockScript(context.Background(), scriptPath, "", gen)
+	if err != nil {
+		t.Fatalf("lockScript failed: %v", err)
+	}
+
+	refs := map[string]bool{}
+	for _, e := range lock.Images {
+		refs[e.Ref] = true
+	}
+	if !refs["docker.io/library/alpine:3.19"] || !refs["docker.io/library/debian:bookworm"] {
+		t.Errorf("expected images of both targets to be locked, got %v", lock.Images)
+	}
+}
+
+func TestApplyLockfile(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "build.lua")
+
+	script := `bk.export(bk.image("alpine:3.19"))
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	evaluate := func() *luavm.EvalResult {
+		result, err := luavm.EvaluateFile(scriptPath, createVMConfig(scriptPath))
+		if err != nil {
+			t.Fatalf("EvaluateFile failed: %v", err)
+		}
+		return result
+	}
+
+	if err := applyLockfile(scriptPath, evaluate(), false); err != nil {
+		t.Errorf("expected missing lockfile to be ignored, got %v", err)
+	}
+	if err := applyLockfile(scriptPath, evaluate(), true); err == nil || !strings.Contains(err.Error(), "run luakit lock") {
+		t.Errorf("expected frozen error without lockfile, got %v", err)
+	}
+
+	gen := &lockfile.Generator{Images: fakeImageResolver{}}
+	lock, err := lockScript(context.Background(), scriptPath, "", gen)
+	if err != nil {
+		t.Fatalf("lockScript failed: %v", err)
+	}
+	if err := lock.Write(lockfilePath(scriptPath)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	result := evaluate()
+	if err := applyLockfile(scriptPath, result, true); err != nil {
+		t.Fatalf("applyLockfile failed: %v", err)
+	}
+	id := result.State.Op().Op().GetSource().Identifier
+	if !strings.HasSuffix(id, "@sha256:"+strings.Repeat("a", 64)) {
+		t.Errorf("expected image pinned to digest, got %s", id)
+	}
+}
+
+func TestParseBuildFlagsFrozen(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	os.Args = []string{"luakit", "build", "--frozen", "build.lua"}
+
+	flags := parseBuildFlags()
+	if !flags.frozen {
+		t.Error("expected frozen to be set")
+	}
+}
@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/kasuboski/luakit/lua/stdlib"
 	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/lint"
 	"github.com/kasuboski/luakit/pkg/luavm"
 	"github.com/kasuboski/luakit/pkg/output"
 	"github.com/kasuboski/luakit/pkg/resolver"
-	pb "github.com/moby/buildkit/solver/pb"
 )
 
 const version = "0.1.0-dev"
@@ -38,6 +41,7 @@ func createVMConfig(scriptPath string) *luavm.VMConfig {
 
 	if _, err := os.Stat(stdlibDir); os.IsNotExist(err) {
 		config.StdlibDir = ""
+		config.StdlibFS = stdlib.FS
 	}
 
 	return config
@@ -58,6 +62,14 @@ func main() {
 		handleDag()
 	case "validate":
 		handleValidate()
+	case "lint":
+		handleLint()
+	case "lock":
+		handleLock()
+	case "convert":
+		handleConvert()
+	case "fmt":
+		handleFmt()
 	case "version", "--version", "-v":
 		fmt.Printf("luakit %s\n", version)
 	default:
@@ -74,35 +86,83 @@ USAGE:
     luakit build <script>     Build from a Lua script
     luakit dag <script>       Print the LLB DAG without building
     luakit validate <script>  Validate a script without building
+    luakit lock <script>      Pin image digests, git commits and checksums
+    luakit lint <script>      Check a script for common build problems
+    luakit convert [file]     Convert a Dockerfile into a Lua build script
+    luakit fmt [path ...]     Format Lua build scripts
     luakit version            Print version information
 
 BUILD FLAGS:
     --output, -o <path>         Write pb.Definition to file (default: stdout)
-    --frontend-arg KEY=VALUE    Set a frontend argument (repeatable)
+    --frontend-arg KEY=VALUE    Set a build argument read by bk.arg (repeatable)
+    --target <name>             Build the named bk.target
+    --platform <os/arch>        Platform to build from a multi-platform export
+    --frozen                    Fail if a source is missing from luakit.lock
+    --strict, --env NAME[=VAL]  Evaluate deterministically, allowing os.getenv(NAME)
+    --build-context NAME=VALUE  Source for bk.context(NAME) (repeatable)
+    --addr <address>            Submit the build to a BuildKit daemon
+    --local NAME=DIR            Directory for bk.local_(NAME) with --addr (repeatable)
+    --secret, --ssh, --progress Session and progress options with --addr
 
  DAG FLAGS:
      --format <dot|json>         Output format (default: dot)
      --output, -o <path>         Write to file (default: stdout)
      --filter <type>              Filter by operation type (Exec, Source, File, Merge, Diff)
+     --target <name>             Print the DAG of the named bk.target
 
 EXAMPLES:
     luakit build build.lua
     luakit build -o output.pb build.lua
-    luakit build --frontend-arg=target=linux/arm64 build.lua
+    luakit build --frontend-arg=VERSION=1.2.3 build.lua
+    luakit build --target prod build.lua
+    luakit build --addr unix:///run/buildkit/buildkitd.sock --output type=image,name=app build.lua
     luakit dag build.lua | dot -Tsvg > dag.svg
     luakit dag --format=json build.lua
     luakit validate build.lua
+    luakit validate --list-args build.lua
+    luakit validate --strict --env CI build.lua
+    luakit lint --format=json build.lua
+    luakit lock --platform linux/amd64,linux/arm64 build.lua
+    luakit build --frozen build.lua
+    luakit convert -o build.lua Dockerfile
+    luakit fmt --check .
 `)
 }
 
 type buildFlags struct {
 	outputPath   string
 	frontendArgs map[string]string
+	target       string
+	platform     string
+	frozen       bool
+	// strict evaluates deterministically; env is what os.getenv sees then.
+	strict bool
+	env    map[string]string
+	// sourceDateEpoch defaults to $SOURCE_DATE_EPOCH.
+	sourceDateEpoch string
+
+	// contexts are the named contexts for bk.context, as sources.
+	// ociLayouts maps the store IDs they use to OCI layout directories.
+	contexts   map[string]string
+	ociLayouts map[string]string
+
+	// Direct submission to a BuildKit daemon.
+	addr     string
+	outputs  []string
+	locals   map[string]string
+	secrets  []string
+	ssh      []string
+	progress string
 }
 
 func parseBuildFlags() *buildFlags {
 	flags := &buildFlags{
-		frontendArgs: make(map[string]string),
+		frontendArgs:    make(map[string]string),
+		locals:          make(map[string]string),
+		env:             make(map[string]string),
+		contexts:        make(map[string]string),
+		ociLayouts:      make(map[string]string),
+		sourceDateEpoch: os.Getenv("SOURCE_DATE_EPOCH"),
 	}
 
 	args := os.Args[2:]
@@ -116,19 +176,87 @@ func parseBuildFlags() *buildFlags {
 				fmt.Fprintf(os.Stderr, "error: %s requires a value\n", arg) // #nosec G705 -- CLI tool output to stderr
 				os.Exit(1)
 			}
-			flags.outputPath = args[i+1]
+			flags.outputs = append(flags.outputs, args[i+1])
 			i += 2
 		case "--frontend-arg":
 			if i+1 >= len(args) {
 				fmt.Fprintf(os.Stderr, "error: --frontend-arg requires a value\n")
 				os.Exit(1)
 			}
+			flags.addFrontendArg(args[i+1])
+			i += 2
+		case "--target":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --target requires a value\n")
+				os.Exit(1)
+			}
+			flags.target = args[i+1]
+			i += 2
+		case "--platform":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --platform requires a value\n")
+				os.Exit(1)
+			}
+			flags.platform = args[i+1]
+			i += 2
+		case "--frozen":
+			flags.frozen = true
+			i++
+		case "--strict":
+			flags.strict = true
+			i++
+		case "--env":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --env requires a value\n")
+				os.Exit(1)
+			}
+			addEnvFlag(flags.env, args[i+1])
+			i += 2
+		case "--source-date-epoch":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --source-date-epoch requires a value\n")
+				os.Exit(1)
+			}
+			flags.sourceDateEpoch = args[i+1]
+			i += 2
+		case "--build-context":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --build-context requires a value\n")
+				os.Exit(1)
+			}
 			parts := splitKeyValue(args[i+1])
-			if parts == nil {
-				fmt.Fprintf(os.Stderr, "error: --frontend-arg value must be in KEY=VALUE format\n")
+			if parts == nil || parts[0] == "" {
+				fmt.Fprintf(os.Stderr, "error: --build-context value must be in NAME=VALUE format\n")
+				os.Exit(1)
+			}
+			if err := flags.addBuildContext(parts[0], parts[1]); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			i += 2
+		case "--addr", "--local", "--secret", "--ssh", "--progress":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: %s requires a value\n", arg) // #nosec G705 -- CLI tool output to stderr
 				os.Exit(1)
 			}
-			flags.frontendArgs[parts[0]] = parts[1]
+			value := args[i+1]
+			switch arg {
+			case "--addr":
+				flags.addr = value
+			case "--local":
+				parts := splitKeyValue(value)
+				if parts == nil || parts[0] == "" {
+					fmt.Fprintf(os.Stderr, "error: --local value must be in NAME=DIR format\n")
+					os.Exit(1)
+				}
+				flags.locals[parts[0]] = parts[1]
+			case "--secret":
+				flags.secrets = append(flags.secrets, value)
+			case "--ssh":
+				flags.ssh = append(flags.ssh, value)
+			case "--progress":
+				flags.progress = value
+			}
 			i += 2
 		case "--help", "-h":
 			fmt.Fprintf(os.Stderr, `luakit build - Build from a Lua script
@@ -136,18 +264,54 @@ func parseBuildFlags() *buildFlags {
 USAGE:
     luakit build [flags] <script>
 
+Without --addr the pb.Definition is written out for buildctl. With --addr
+the build is submitted to the BuildKit daemon directly; --output then names
+an exporter and may be repeated.
+
 FLAGS:
     --output, -o <path>         Write pb.Definition to file (default: stdout)
-    --frontend-arg KEY=VALUE    Set a frontend argument (repeatable)
+    --frontend-arg KEY=VALUE    Set a build argument read by bk.arg (repeatable)
+    --target <name>             Build the named bk.target
+    --platform <os/arch>        Platform to build from a multi-platform export
+    --frozen                    Fail if a source is missing from luakit.lock
+    --strict                    Evaluate deterministically: fixed time and random seed,
+                                os.getenv limited to --env
+    --env NAME[=VALUE]          Allow os.getenv(NAME) with --strict; without VALUE the
+                                current value is passed (repeatable)
+    --source-date-epoch <secs>  Timestamp for reproducible builds (default: $SOURCE_DATE_EPOCH)
+    --build-context NAME=VALUE  Source for bk.context(NAME): docker-image://REF, a git or
+                                HTTP URL, oci-layout://DIR@DIGEST or a directory (repeatable)
     --help, -h                  Show this help message
 
+DAEMON FLAGS:
+    --addr <address>            BuildKit daemon address, e.g. unix:///run/buildkit/buildkitd.sock
+    --output, -o <spec>         Exporter: type=image|oci|docker|tar|local,... (repeatable)
+    --local NAME=DIR            Directory for bk.local_(NAME) (default: context=script dir)
+    --secret id=ID,src=PATH     Expose a file or env=VAR as a secret (repeatable)
+    --ssh default|ID=PATH,...   Forward an SSH agent socket or keys (repeatable)
+    --progress <mode>           Progress output: auto, plain, tty, quiet or rawjson
+
 EXAMPLES:
     luakit build build.lua
     luakit build -o output.pb build.lua
-    luakit build --frontend-arg=target=linux/arm64 build.lua
+    luakit build --frontend-arg=VERSION=1.2.3 build.lua
+    luakit build --target prod build.lua
+    luakit build --addr unix:///run/buildkit/buildkitd.sock --output type=image,name=app:dev build.lua
+    luakit build --addr tcp://buildkitd:1234 --output type=local,dest=out --local src=../src build.lua
+    luakit build --addr tcp://buildkitd:1234 --build-context base=docker-image://golang:1.23 build.lua
 `)
 			os.Exit(0)
 		default:
+			if target, ok := strings.CutPrefix(arg, "--target="); ok {
+				flags.target = target
+				i++
+				continue
+			}
+			if value, ok := strings.CutPrefix(arg, "--frontend-arg="); ok {
+				flags.addFrontendArg(value)
+				i++
+				continue
+			}
 			if arg[0] == '-' {
 				fmt.Fprintf(os.Stderr, "error: unknown flag: %s\n", arg) // #nosec G705 -- CLI tool output to stderr
 				os.Exit(1)
@@ -156,9 +320,40 @@ EXAMPLES:
 		}
 	}
 
+	if flags.addr == "" {
+		if len(flags.outputs) > 1 {
+			fmt.Fprintf(os.Stderr, "error: --output may only be given once without --addr\n")
+			os.Exit(1)
+		}
+		if len(flags.outputs) == 1 {
+			flags.outputPath = flags.outputs[0]
+		}
+		flags.outputs = nil
+	}
+
 	return flags
 }
 
+// addFrontendArg adds a --frontend-arg KEY=VALUE to the build arguments.
+func (f *buildFlags) addFrontendArg(value string) {
+	parts := splitKeyValue(value)
+	if parts == nil {
+		fmt.Fprintf(os.Stderr, "error: --frontend-arg value must be in KEY=VALUE format\n")
+		os.Exit(1)
+	}
+	f.frontendArgs[parts[0]] = parts[1]
+}
+
+// addEnvFlag adds an --env value to env. A bare NAME passes on the current
+// value of NAME; an unset variable is still allowed and reads as nil.
+func addEnvFlag(env map[string]string, value string) {
+	if parts := splitKeyValue(value); parts != nil {
+		env[parts[0]] = parts[1]
+		return
+	}
+	env[value] = os.Getenv(value)
+}
+
 func splitKeyValue(s string) []string {
 	for i := 0; i < len(s); i++ {
 		if s[i] == '=' {
@@ -185,9 +380,23 @@ func handleBuild() {
 	}
 
 	config := createVMConfig(args.script)
+	config.Target = flags.target
+
+	platforms, err := luavm.ParsePlatforms(flags.platform)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	config.Platforms = platforms
+	config.Args = flags.frontendArgs
+	config.Contexts = flags.contexts
+	config.Strict = flags.strict
+	config.Env = flags.env
 
-	for k, v := range flags.frontendArgs {
-		_ = os.Setenv(k, v)
+	config.SourceDateEpoch, err = luavm.ParseSourceDateEpoch(flags.sourceDateEpoch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
 	}
 
 	result, err := luavm.Evaluate(strings.NewReader(string(scriptData)), args.script, config)
@@ -201,18 +410,38 @@ func handleBuild() {
 		os.Exit(1)
 	}
 
-	var def *pb.Definition
+	if len(result.Platforms) > 1 {
+		fmt.Fprintln(os.Stderr, "error: script exports multiple platforms; select one with --platform or build through the gateway frontend")
+		os.Exit(1)
+	}
+
+	if err := applyLockfile(args.script, result, flags.frozen); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
 	reslv := resolver.NewResolver()
-	def, err = dag.Serialize(result.State, &dag.SerializeOptions{
-		ImageConfig: result.ImageConfig,
-		SourceFiles: result.SourceFiles,
-		Resolver:    reslv,
-	})
+	serializeOpts := &dag.SerializeOptions{
+		ImageConfig:        result.ImageConfig,
+		SourceFiles:        result.SourceFiles,
+		Resolver:           reslv,
+		InheritImageConfig: result.InheritImageConfig,
+		SourceDateEpoch:    result.SourceDateEpoch,
+	}
+	def, imageConfig, err := dag.SerializeImage(result.State, serializeOpts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: failed to serialize definition: %v\n", err)
 		os.Exit(1)
 	}
 
+	if flags.addr != "" {
+		if err := submitBuild(context.Background(), flags, filepath.Dir(args.script), def, imageConfig, result.ExportMetadata()); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	writer := output.NewProtobufWriter(flags.outputPath)
 	if err = writer.Write(def); err != nil {
 		fmt.Fprintf(os.Stderr, "error: failed to write output: %v\n", err)
@@ -224,6 +453,7 @@ type dagFlags struct {
 	format     string
 	outputPath string
 	filterOp   string
+	target     string
 }
 
 func parseDagFlags() *dagFlags {
@@ -282,6 +512,16 @@ func parseDagFlags() *dagFlags {
 				os.Exit(1)
 			}
 			i += 1
+		case arg == "--target":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --target requires a value\n")
+				os.Exit(1)
+			}
+			flags.target = args[i+1]
+			i += 2
+		case len(arg) > 9 && arg[:9] == "--target=":
+			flags.target = arg[9:]
+			i += 1
 		case arg == "--help" || arg == "-h":
 			fmt.Fprintf(os.Stderr, `luakit dag - Print the LLB DAG without building
 
@@ -292,6 +532,7 @@ USAGE:
      --format <dot|json>         Output format (default: dot)
      --output, -o <path>         Write to file (default: stdout)
      --filter <type>              Filter by operation type (Exec, Source, File, Merge, Diff)
+     --target <name>             Print the DAG of the named bk.target
      --help, -h                  Show this help message
 
  EXAMPLES:
@@ -324,6 +565,7 @@ func handleDag() {
 	}
 
 	config := createVMConfig(args.script)
+	config.Target = flags.target
 
 	result, err := luavm.EvaluateFile(args.script, config)
 	if err != nil {
@@ -359,6 +601,14 @@ func handleDag() {
 }
 
 func handleValidate() {
+	if parseValidateFlags().listArgs {
+		if err := listScriptArgs(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := validateScript(); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
@@ -366,10 +616,59 @@ func handleValidate() {
 	fmt.Println("✓ Script is valid")
 }
 
+type validateFlags struct {
+	target   string
+	listArgs bool
+	strict   bool
+	env      map[string]string
+}
+
+func parseValidateFlags() *validateFlags {
+	flags := &validateFlags{env: make(map[string]string)}
+
+	args := os.Args[2:]
+	i := 0
+	for i < len(args) {
+		arg := args[i]
+
+		switch {
+		case arg == "--target":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --target requires a value\n")
+				os.Exit(1)
+			}
+			flags.target = args[i+1]
+			i += 2
+		case len(arg) > 9 && arg[:9] == "--target=":
+			flags.target = arg[9:]
+			i += 1
+		case arg == "--list-args":
+			flags.listArgs = true
+			i++
+		case arg == "--strict":
+			flags.strict = true
+			i++
+		case arg == "--env":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "error: --env requires a value\n")
+				os.Exit(1)
+			}
+			addEnvFlag(flags.env, args[i+1])
+			i += 2
+		default:
+			i++
+		}
+	}
+
+	return flags
+}
+
 func validateScript() error {
+	flags := parseValidateFlags()
+
 	args := getScriptArg()
 	if args.script == "" {
-		return fmt.Errorf("missing script file\nUsage: luakit validate <script>")
+		return fmt.Errorf("missing script file\nUsage: luakit validate [--target <name>] <script>")
 	}
 
 	scriptData, err := os.ReadFile(args.script)
@@ -378,6 +677,9 @@ func validateScript() error {
 	}
 
 	config := createVMConfig(args.script)
+	config.Target = flags.target
+	config.Strict = flags.strict
+	config.Env = flags.env
 
 	result, err := luavm.Evaluate(strings.NewReader(string(scriptData)), args.script, config)
 	if err != nil {
@@ -388,9 +690,46 @@ func validateScript() error {
 		return fmt.Errorf("no bk.export() call found in script")
 	}
 
+	warnCredentials(os.Stderr, result)
+	reportEnvReads(os.Stderr, result, flags.strict, flags.env)
+	reportContextReads(os.Stderr, result)
 	return nil
 }
 
+// reportEnvReads lists the environment variables result read with os.getenv.
+// In strict mode, those not allowed with --env read as nil and are flagged.
+func reportEnvReads(w io.Writer, result *luavm.EvalResult, strict bool, env map[string]string) {
+	for _, name := range result.EnvReads {
+		if _, ok := env[name]; strict && !ok {
+			fmt.Fprintf(w, "warning: os.getenv(%q) is not allowed with --strict and reads nil; pass --env %s\n", name, name)
+			continue
+		}
+		fmt.Fprintf(w, "note: script reads environment variable %s\n", name)
+	}
+}
+
+// reportContextReads lists the build context files result read with
+// bk.read_file and the paths and patterns it looked up with bk.exists and
+// bk.glob, since changing them can change the build.
+func reportContextReads(w io.Writer, result *luavm.EvalResult) {
+	for _, name := range result.FileReads {
+		fmt.Fprintf(w, "note: script reads build context file %s\n", name)
+	}
+	for _, name := range result.FileLookups {
+		fmt.Fprintf(w, "note: script looks up build context path %s\n", name)
+	}
+}
+
+// warnCredentials writes a warning to w for each literal credential passed
+// to a source. They end up in the definition and BuildKit's cache keys, so
+// validate reports them even though the script is otherwise valid.
+func warnCredentials(w io.Writer, result *luavm.EvalResult) {
+	config := lint.OnlyRules("http-credentials")
+	for _, f := range lint.New(config, result.SourceFiles).Run(result.States()...) {
+		fmt.Fprintf(w, "warning: %s\n", f)
+	}
+}
+
 type scriptArgs struct {
 	script string
 }
@@ -403,7 +742,9 @@ func getScriptArg() *scriptArgs {
 		if arg[0] != '-' {
 			return &scriptArgs{script: arg}
 		}
-		if arg == "--output" || arg == "-o" || arg == "--frontend-arg" || arg == "--format" {
+		if arg == "--output" || arg == "-o" || arg == "--frontend-arg" || arg == "--format" || arg == "--filter" || arg == "--target" || arg == "--platform" || arg == "--config" ||
+			arg == "--addr" || arg == "--local" || arg == "--secret" || arg == "--ssh" || arg == "--progress" || arg == "--source-date-epoch" ||
+			arg == "--build-context" || arg == "--env" {
 			i += 2
 		} else {
 			i++
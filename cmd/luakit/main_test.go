@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"maps"
 	"os"
 	"path/filepath"
 	"strings"
@@ -137,6 +138,77 @@ func TestParseBuildFlags(t *testing.T) {
 	}
 }
 
+func TestParseFrontendArgEquals(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "build", "--frontend-arg=VERSION=1.2.3", "--frontend-arg", "MODE=prod", "script.lua"}
+	if flags := parseBuildFlags(); flags.frontendArgs["VERSION"] != "1.2.3" || flags.frontendArgs["MODE"] != "prod" {
+		t.Errorf("expected VERSION and MODE build arguments, got %v", flags.frontendArgs)
+	}
+	if args := getScriptArg(); args.script != "script.lua" {
+		t.Errorf("expected script.lua, got %q", args.script)
+	}
+}
+
+func TestParseTargetFlag(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "build", "--target", "prod", "script.lua"}
+	if flags := parseBuildFlags(); flags.target != "prod" {
+		t.Errorf("expected build target prod, got %q", flags.target)
+	}
+	if args := getScriptArg(); args.script != "script.lua" {
+		t.Errorf("expected script.lua, got %q", args.script)
+	}
+
+	os.Args = []string{"luakit", "build", "--target=prod", "script.lua"}
+	if flags := parseBuildFlags(); flags.target != "prod" {
+		t.Errorf("expected build target prod from --target=, got %q", flags.target)
+	}
+	if args := getScriptArg(); args.script != "script.lua" {
+		t.Errorf("expected script.lua, got %q", args.script)
+	}
+
+	os.Args = []string{"luakit", "dag", "--target=test", "script.lua"}
+	if flags := parseDagFlags(); flags.target != "test" {
+		t.Errorf("expected dag target test, got %q", flags.target)
+	}
+
+	os.Args = []string{"luakit", "validate", "--target", "dev", "script.lua"}
+	if flags := parseValidateFlags(); flags.target != "dev" {
+		t.Errorf("expected validate target dev, got %q", flags.target)
+	}
+	if args := getScriptArg(); args.script != "script.lua" {
+		t.Errorf("expected script.lua, got %q", args.script)
+	}
+}
+
+func TestParseStrictFlags(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+	t.Setenv("LUAKIT_TEST_TOKEN", "secret")
+
+	os.Args = []string{"luakit", "build", "--strict", "--env", "CI=1", "--env", "LUAKIT_TEST_TOKEN", "--env", "LUAKIT_TEST_UNSET", "script.lua"}
+	flags := parseBuildFlags()
+	if !flags.strict {
+		t.Error("expected strict mode")
+	}
+	want := map[string]string{"CI": "1", "LUAKIT_TEST_TOKEN": "secret", "LUAKIT_TEST_UNSET": ""}
+	if !maps.Equal(flags.env, want) {
+		t.Errorf("expected env %v, got %v", want, flags.env)
+	}
+	if args := getScriptArg(); args.script != "script.lua" {
+		t.Errorf("expected script.lua, got %q", args.script)
+	}
+
+	os.Args = []string{"luakit", "validate", "--strict", "--env", "CI", "script.lua"}
+	if flags := parseValidateFlags(); !flags.strict || len(flags.env) != 1 {
+		t.Errorf("expected strict validation allowing CI, got %v %v", flags.strict, flags.env)
+	}
+}
+
 func TestParseDagFlags(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -408,3 +480,69 @@ func TestParseDagFlagsWithFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestWarnCredentials(t *testing.T) {
+	result, err := luavm.Evaluate(strings.NewReader(`
+local tool = bk.https("https://example.com/tool", { username = "ci", password = "hunter2" })
+bk.export(bk.image("alpine:3.19"):run("true", { network = "host", mounts = { bk.bind(tool, "/tool") } }))
+`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	warnCredentials(&buf, result)
+	out := buf.String()
+	if !strings.Contains(out, "warning: build.lua:2: [http-credentials]") {
+		t.Errorf("expected a credentials warning, got:\n%s", out)
+	}
+	if strings.Contains(out, "network-host") || strings.Contains(out, "image-digest") {
+		t.Errorf("expected only credential warnings, got:\n%s", out)
+	}
+}
+
+func TestReportEnvReads(t *testing.T) {
+	script := `bk.export(bk.scratch():env({ A = os.getenv("CI") or "", B = os.getenv("HOME") or "" }))`
+	config := &luavm.VMConfig{Strict: true, Env: map[string]string{"CI": "1"}}
+	result, err := luavm.Evaluate(strings.NewReader(script), "build.lua", config)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	reportEnvReads(&buf, result, true, config.Env)
+	out := buf.String()
+	if !strings.Contains(out, "note: script reads environment variable CI") {
+		t.Errorf("expected a note for CI, got:\n%s", out)
+	}
+	if !strings.Contains(out, `warning: os.getenv("HOME") is not allowed with --strict`) {
+		t.Errorf("expected a warning for HOME, got:\n%s", out)
+	}
+
+	buf.Reset()
+	reportEnvReads(&buf, result, false, nil)
+	if strings.Contains(buf.String(), "warning") {
+		t.Errorf("expected only notes outside strict mode, got:\n%s", buf.String())
+	}
+}
+
+func TestReportContextReads(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("go 1.22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := `bk.read_file("go.mod"); bk.exists("go.sum"); bk.glob("*.json"); bk.export(bk.scratch())`
+	result, err := luavm.Evaluate(strings.NewReader(script), "build.lua", &luavm.VMConfig{BuildContextDir: dir})
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	reportContextReads(&buf, result)
+	want := "note: script reads build context file go.mod\n" +
+		"note: script looks up build context path *.json\n" +
+		"note: script looks up build context path go.sum\n"
+	if buf.String() != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, buf.String())
+	}
+}
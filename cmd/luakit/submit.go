@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/content"
+	contentlocal "github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	commonexptypes "github.com/moby/buildkit/exporter/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"github.com/moby/buildkit/session/sshforward/sshprovider"
+	pb "github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/util/progress/progressui"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/tonistiigi/fsutil"
+	"golang.org/x/sync/errgroup"
+)
+
+// buildSolver is the part of *client.Client used to submit a build, so tests
+// can stand in for a daemon.
+type buildSolver interface {
+	Build(ctx context.Context, opt client.SolveOpt, product string, buildFunc gwclient.BuildFunc, statusChan chan *client.SolveStatus) (*client.SolveResponse, error)
+}
+
+// submitBuild connects to the daemon at flags.addr and solves def.
+func submitBuild(ctx context.Context, flags *buildFlags, contextDir string, def *pb.Definition, config *dockerspec.DockerOCIImage, meta map[string][]byte) error {
+	c, err := client.New(ctx, flags.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", flags.addr, err)
+	}
+	defer c.Close()
+
+	return solveDefinition(ctx, c, flags, contextDir, def, config, meta, os.Stderr)
+}
+
+// solveDefinition builds def with c, exporting the result with config as its
+// image config and meta, such as annotations, as further exporter metadata.
+// Progress is streamed to w.
+func solveDefinition(ctx context.Context, c buildSolver, flags *buildFlags, contextDir string, def *pb.Definition, config *dockerspec.DockerOCIImage, meta map[string][]byte, w io.Writer) error {
+	_, reproducible := meta[commonexptypes.ExporterEpochKey]
+	opt, err := newSolveOpt(flags, contextDir, def, reproducible)
+	if err != nil {
+		return err
+	}
+
+	mode := progressui.AutoMode
+	if flags.progress != "" {
+		mode = progressui.DisplayMode(flags.progress)
+	}
+	display, err := progressui.NewDisplay(w, mode)
+	if err != nil {
+		return err
+	}
+
+	buildFunc := func(ctx context.Context, gw gwclient.Client) (*gwclient.Result, error) {
+		res, err := gw.Solve(ctx, gwclient.SolveRequest{
+			Definition: def,
+			Evaluate:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if config != nil {
+			dt, err := json.Marshal(config)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal image config: %w", err)
+			}
+			res.AddMeta(exptypes.ExporterImageConfigKey, dt)
+		}
+		for k, v := range meta {
+			res.AddMeta(k, v)
+		}
+		return res, nil
+	}
+
+	ch := make(chan *client.SolveStatus)
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		_, err := c.Build(ctx, opt, "luakit", buildFunc, ch)
+		return err
+	})
+	eg.Go(func() error {
+		_, err := display.UpdateFrom(context.WithoutCancel(ctx), ch)
+		return err
+	})
+	return eg.Wait()
+}
+
+// newSolveOpt wires up the local directories referenced by def, the exports
+// and the secret and SSH session attachables from flags. The "context" local
+// defaults to contextDir. For a reproducible build, image exporters rewrite
+// layer timestamps to the source date epoch unless told otherwise.
+func newSolveOpt(flags *buildFlags, contextDir string, def *pb.Definition, reproducible bool) (client.SolveOpt, error) {
+	opt := client.SolveOpt{
+		LocalMounts: make(map[string]fsutil.FS),
+	}
+
+	names, err := localNames(def)
+	if err != nil {
+		return opt, err
+	}
+	for _, name := range names {
+		dir, ok := flags.locals[name]
+		if !ok {
+			if name != "context" {
+				return opt, fmt.Errorf("bk.local_(%q) needs --local %s=<dir>", name, name)
+			}
+			dir = contextDir
+		}
+		mount, err := fsutil.NewFS(dir)
+		if err != nil {
+			return opt, fmt.Errorf("--local %s: %w", name, err)
+		}
+		opt.LocalMounts[name] = mount
+	}
+
+	for _, spec := range flags.outputs {
+		export, err := parseExport(spec)
+		if err != nil {
+			return opt, err
+		}
+		switch export.Type {
+		case client.ExporterImage, client.ExporterOCI, client.ExporterDocker:
+			key := string(exptypes.OptKeyRewriteTimestamp)
+			if _, ok := export.Attrs[key]; reproducible && !ok {
+				export.Attrs[key] = "true"
+			}
+		}
+		opt.Exports = append(opt.Exports, export)
+	}
+
+	for id, dir := range flags.ociLayouts {
+		store, err := contentlocal.NewStore(dir)
+		if err != nil {
+			return opt, fmt.Errorf("--build-context oci-layout://%s: %w", dir, err)
+		}
+		if opt.OCIStores == nil {
+			opt.OCIStores = make(map[string]content.Store)
+		}
+		opt.OCIStores[id] = store
+	}
+
+	if len(flags.secrets) > 0 {
+		var sources []secretsprovider.Source
+		for _, spec := range flags.secrets {
+			src, err := parseSecret(spec)
+			if err != nil {
+				return opt, err
+			}
+			sources = append(sources, src)
+		}
+		store, err := secretsprovider.NewStore(sources)
+		if err != nil {
+			return opt, err
+		}
+		opt.Session = append(opt.Session, secretsprovider.NewSecretProvider(store))
+	}
+
+	if len(flags.ssh) > 0 {
+		var configs []sshprovider.AgentConfig
+		for _, spec := range flags.ssh {
+			configs = append(configs, parseSSH(spec))
+		}
+		agent, err := sshprovider.NewSSHAgentProvider(configs)
+		if err != nil {
+			return opt, err
+		}
+		opt.Session = append(opt.Session, agent)
+	}
+
+	return opt, nil
+}
+
+// localNames returns the sorted names of the local sources in def.
+func localNames(def *pb.Definition) ([]string, error) {
+	var names []string
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			return nil, fmt.Errorf("failed to parse definition: %w", err)
+		}
+		src := op.GetSource()
+		if src == nil {
+			continue
+		}
+		if name, ok := strings.CutPrefix(src.Identifier, "local://"); ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// parseCSV splits a flag value such as "type=local,dest=out" into its fields.
+func parseCSV(flag, spec string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for field := range strings.SplitSeq(spec, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s: invalid field %q, expected KEY=VALUE", flag, field)
+		}
+		attrs[strings.TrimSpace(key)] = value
+	}
+	return attrs, nil
+}
+
+// parseExport parses an --output value such as "type=image,name=foo" or
+// "type=local,dest=out".
+func parseExport(spec string) (client.ExportEntry, error) {
+	attrs, err := parseCSV("--output", spec)
+	if err != nil {
+		return client.ExportEntry{}, err
+	}
+
+	export := client.ExportEntry{Type: attrs["type"], Attrs: attrs}
+	delete(attrs, "type")
+	dest := attrs["dest"]
+	delete(attrs, "dest")
+
+	switch export.Type {
+	case client.ExporterImage:
+		if dest != "" {
+			return export, fmt.Errorf("--output: type=image does not support dest")
+		}
+	case client.ExporterLocal:
+		if dest == "" {
+			return export, fmt.Errorf("--output: type=local requires dest")
+		}
+		export.OutputDir = dest
+	case client.ExporterOCI, client.ExporterDocker, client.ExporterTar:
+		export.Output = func(map[string]string) (io.WriteCloser, error) {
+			if dest == "" || dest == "-" {
+				return nopCloser{os.Stdout}, nil
+			}
+			return os.Create(filepath.Clean(dest))
+		}
+	case "":
+		return export, fmt.Errorf("--output: type is required")
+	default:
+		return export, fmt.Errorf("--output: unsupported type %q (expected image, oci, docker, tar or local)", export.Type)
+	}
+	return export, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// parseSecret parses a --secret value: "id=NAME,src=PATH" or "id=NAME,env=VAR".
+func parseSecret(spec string) (secretsprovider.Source, error) {
+	attrs, err := parseCSV("--secret", spec)
+	if err != nil {
+		return secretsprovider.Source{}, err
+	}
+
+	src := secretsprovider.Source{ID: attrs["id"], Env: attrs["env"]}
+	src.FilePath = attrs["src"]
+	if src.FilePath == "" {
+		src.FilePath = attrs["source"]
+	}
+	if src.ID == "" {
+		return src, fmt.Errorf("--secret: id is required")
+	}
+	if src.FilePath == "" && src.Env == "" {
+		return src, fmt.Errorf("--secret %s: src or env is required", src.ID)
+	}
+	return src, nil
+}
+
+// addBuildContext records a --build-context value for bk.context. Sources
+// BuildKit fetches itself are passed on as they are. An OCI layout directory
+// is attached to the session as a content store, and any other value is a
+// directory sent as a local named after the context.
+func (f *buildFlags) addBuildContext(name, value string) error {
+	switch {
+	case strings.HasPrefix(value, "oci-layout://"):
+		ref := strings.TrimPrefix(value, "oci-layout://")
+		i := strings.LastIndex(ref, "@")
+		if i < 0 {
+			return fmt.Errorf("--build-context %s: oci-layout needs a digest, as in oci-layout://DIR@sha256:...", name)
+		}
+		dgst, err := digest.Parse(ref[i+1:])
+		if err != nil {
+			return fmt.Errorf("--build-context %s: %w", name, err)
+		}
+		dir, err := filepath.Abs(ref[:i])
+		if err != nil {
+			return fmt.Errorf("--build-context %s: %w", name, err)
+		}
+		storeID := digest.FromString(dir).Encoded()
+		f.ociLayouts[storeID] = dir
+		f.contexts[name] = "oci-layout://" + storeID + "@" + dgst.String()
+	case slices.ContainsFunc(contextSourcePrefixes, func(prefix string) bool { return strings.HasPrefix(value, prefix) }):
+		f.contexts[name] = value
+	default:
+		f.locals[name] = value
+		f.contexts[name] = "local:" + name
+	}
+	return nil
+}
+
+// contextSourcePrefixes start --build-context values that are not local
+// directories.
+var contextSourcePrefixes = []string{"docker-image://", "git@", "git://", "ssh://", "http://", "https://", "local:"}
+
+// parseSSH parses an --ssh value: "default" forwards $SSH_AUTH_SOCK, and
+// "ID=PATH[,PATH]" forwards an agent socket or key files.
+func parseSSH(spec string) sshprovider.AgentConfig {
+	id, paths, ok := strings.Cut(spec, "=")
+	config := sshprovider.AgentConfig{ID: id}
+	if ok && paths != "" {
+		config.Paths = strings.Split(paths, ",")
+	}
+	return config
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	pb "github.com/moby/buildkit/solver/pb"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	digest "github.com/opencontainers/go-digest"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/luavm"
+)
+
+// fakeSolver stands in for a BuildKit daemon: it runs the build function
+// against fakeGateway and reports one vertex on the status channel.
+type fakeSolver struct {
+	opt     client.SolveOpt
+	request gwclient.SolveRequest
+	result  *gwclient.Result
+}
+
+func (s *fakeSolver) Build(ctx context.Context, opt client.SolveOpt, product string, buildFunc gwclient.BuildFunc, statusChan chan *client.SolveStatus) (*client.SolveResponse, error) {
+	defer close(statusChan)
+	s.opt = opt
+
+	now := time.Now()
+	statusChan <- &client.SolveStatus{
+		Vertexes: []*client.Vertex{{
+			Digest:    digest.FromString("vertex"),
+			Name:      "[1/1] RUN make",
+			Started:   &now,
+			Completed: &now,
+		}},
+	}
+
+	res, err := buildFunc(ctx, &fakeGateway{solver: s})
+	if err != nil {
+		return nil, err
+	}
+	s.result = res
+	return &client.SolveResponse{}, nil
+}
+
+type fakeGateway struct {
+	gwclient.Client
+	solver *fakeSolver
+}
+
+func (g *fakeGateway) Solve(ctx context.Context, req gwclient.SolveRequest) (*gwclient.Result, error) {
+	g.solver.request = req
+	return gwclient.NewResult(), nil
+}
+
+func evaluateDefinition(t *testing.T, script string) (*pb.Definition, *dockerspec.DockerOCIImage) {
+	t.Helper()
+	result, err := luavm.Evaluate(strings.NewReader(script), "build.lua", &luavm.VMConfig{})
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+	def, err := dag.Serialize(result.State, &dag.SerializeOptions{ImageConfig: result.ImageConfig})
+	if err != nil {
+		t.Fatalf("serialization failed: %v", err)
+	}
+	return def, result.ImageConfig
+}
+
+func TestSolveDefinition(t *testing.T) {
+	def, config := evaluateDefinition(t, `
+local src = bk.local_("context")
+local deps = bk.local_("deps")
+local s = bk.image("alpine"):run("make", { mounts = { bk.bind(src, "/src"), bk.bind(deps, "/deps") } })
+bk.export(s, { workdir = "/src" })
+`)
+
+	contextDir := t.TempDir()
+	flags := &buildFlags{
+		addr:     "unix:///run/buildkit/buildkitd.sock",
+		outputs:  []string{"type=image,name=app:dev,push=true", "type=local,dest=out"},
+		locals:   map[string]string{"deps": t.TempDir()},
+		secrets:  []string{"id=token,env=TOKEN"},
+		progress: "plain",
+	}
+
+	solver := &fakeSolver{}
+	var progress bytes.Buffer
+	meta := map[string][]byte{"annotation-manifest.org.opencontainers.image.title": []byte("app")}
+	if err := solveDefinition(context.Background(), solver, flags, contextDir, def, config, meta, &progress); err != nil {
+		t.Fatalf("solveDefinition failed: %v", err)
+	}
+
+	if len(solver.opt.LocalMounts) != 2 || solver.opt.LocalMounts["context"] == nil || solver.opt.LocalMounts["deps"] == nil {
+		t.Errorf("expected context and deps local mounts, got %v", solver.opt.LocalMounts)
+	}
+	if len(solver.opt.Exports) != 2 {
+		t.Fatalf("expected 2 exports, got %d", len(solver.opt.Exports))
+	}
+	if image := solver.opt.Exports[0]; image.Type != client.ExporterImage || image.Attrs["name"] != "app:dev" || image.Attrs["push"] != "true" {
+		t.Errorf("unexpected image export: %+v", image)
+	}
+	if local := solver.opt.Exports[1]; local.Type != client.ExporterLocal || local.OutputDir != "out" {
+		t.Errorf("unexpected local export: %+v", local)
+	}
+	if len(solver.opt.Session) != 1 {
+		t.Errorf("expected a secrets attachable, got %d attachables", len(solver.opt.Session))
+	}
+
+	if solver.request.Definition != def {
+		t.Error("expected the definition to be solved")
+	}
+	var got dockerspec.DockerOCIImage
+	if err := json.Unmarshal(solver.result.Metadata[exptypes.ExporterImageConfigKey], &got); err != nil {
+		t.Fatalf("missing image config: %v", err)
+	}
+	if got.Config.WorkingDir != "/src" {
+		t.Errorf("expected workdir /src, got %q", got.Config.WorkingDir)
+	}
+	if title := string(solver.result.Metadata["annotation-manifest.org.opencontainers.image.title"]); title != "app" {
+		t.Errorf("expected the title annotation, got %q", title)
+	}
+
+	if !strings.Contains(progress.String(), "RUN make") {
+		t.Errorf("expected progress output, got:\n%s", progress.String())
+	}
+}
+
+func TestSolveDefinitionMissingLocal(t *testing.T) {
+	def, config := evaluateDefinition(t, `bk.export(bk.local_("src"))`)
+
+	err := solveDefinition(context.Background(), &fakeSolver{}, &buildFlags{}, t.TempDir(), def, config, nil, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "--local src=<dir>") {
+		t.Errorf("expected missing --local error, got %v", err)
+	}
+}
+
+func TestParseExport(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr string
+	}{
+		{spec: "type=image,name=app"},
+		{spec: "type=oci,dest=app.tar"},
+		{spec: "type=local,dest=out"},
+		{spec: "type=local", wantErr: "requires dest"},
+		{spec: "type=image,dest=out", wantErr: "does not support dest"},
+		{spec: "name=app", wantErr: "type is required"},
+		{spec: "type=registry", wantErr: "unsupported type"},
+		{spec: "type", wantErr: "expected KEY=VALUE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			export, err := parseExport(tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseExport failed: %v", err)
+			}
+			if _, ok := export.Attrs["dest"]; ok {
+				t.Error("dest must not be passed to the exporter")
+			}
+		})
+	}
+}
+
+func TestParseSecretAndSSH(t *testing.T) {
+	src, err := parseSecret("id=npm,src=/home/me/.npmrc")
+	if err != nil || src.ID != "npm" || src.FilePath != "/home/me/.npmrc" {
+		t.Errorf("unexpected secret %+v, %v", src, err)
+	}
+	if _, err := parseSecret("src=/tmp/x"); err == nil {
+		t.Error("expected error for secret without id")
+	}
+	if _, err := parseSecret("id=npm"); err == nil {
+		t.Error("expected error for secret without src or env")
+	}
+
+	if ssh := parseSSH("default"); ssh.ID != "default" || len(ssh.Paths) != 0 {
+		t.Errorf("unexpected ssh config %+v", ssh)
+	}
+	if ssh := parseSSH("github=/a/key,/b/key"); ssh.ID != "github" || len(ssh.Paths) != 2 {
+		t.Errorf("unexpected ssh config %+v", ssh)
+	}
+}
+
+func TestParseBuildFlagsAddr(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "build", "--addr", "tcp://buildkitd:1234",
+		"--output", "type=image,name=app", "-o", "type=local,dest=out",
+		"--local", "src=../src", "--secret", "id=a,env=A", "--ssh", "default",
+		"--progress", "plain", "build.lua"}
+
+	flags := parseBuildFlags()
+	if flags.addr != "tcp://buildkitd:1234" || flags.progress != "plain" {
+		t.Errorf("unexpected flags %+v", flags)
+	}
+	if flags.outputPath != "" || len(flags.outputs) != 2 {
+		t.Errorf("expected two exporter outputs, got path %q and %v", flags.outputPath, flags.outputs)
+	}
+	if flags.locals["src"] != "../src" || len(flags.secrets) != 1 || len(flags.ssh) != 1 {
+		t.Errorf("unexpected session flags %+v", flags)
+	}
+	if args := getScriptArg(); args.script != "build.lua" {
+		t.Errorf("expected build.lua, got %q", args.script)
+	}
+}
+
+func TestNewSolveOptRewritesTimestamps(t *testing.T) {
+	def, _ := evaluateDefinition(t, `bk.export(bk.scratch())`)
+	flags := &buildFlags{outputs: []string{
+		"type=image,name=app",
+		"type=oci,dest=app.tar,rewrite-timestamp=false",
+		"type=local,dest=out",
+	}}
+
+	opt, err := newSolveOpt(flags, t.TempDir(), def, true)
+	if err != nil {
+		t.Fatalf("newSolveOpt failed: %v", err)
+	}
+	if got := opt.Exports[0].Attrs["rewrite-timestamp"]; got != "true" {
+		t.Errorf("expected the image export to rewrite timestamps, got %q", got)
+	}
+	if got := opt.Exports[1].Attrs["rewrite-timestamp"]; got != "false" {
+		t.Errorf("expected the explicit rewrite-timestamp to be kept, got %q", got)
+	}
+	if _, ok := opt.Exports[2].Attrs["rewrite-timestamp"]; ok {
+		t.Error("local exports do not take rewrite-timestamp")
+	}
+
+	opt, err = newSolveOpt(flags, t.TempDir(), def, false)
+	if err != nil {
+		t.Fatalf("newSolveOpt failed: %v", err)
+	}
+	if _, ok := opt.Exports[0].Attrs["rewrite-timestamp"]; ok {
+		t.Error("expected no rewrite-timestamp without a source date epoch")
+	}
+}
+
+func TestParseBuildFlagsSourceDateEpoch(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	os.Args = []string{"luakit", "build", "build.lua"}
+	if flags := parseBuildFlags(); flags.sourceDateEpoch != "1600000000" {
+		t.Errorf("expected the epoch from the environment, got %q", flags.sourceDateEpoch)
+	}
+
+	os.Args = []string{"luakit", "build", "--source-date-epoch", "1700000000", "build.lua"}
+	if flags := parseBuildFlags(); flags.sourceDateEpoch != "1700000000" {
+		t.Errorf("expected the flag to override the environment, got %q", flags.sourceDateEpoch)
+	}
+	if args := getScriptArg(); args.script != "build.lua" {
+		t.Errorf("expected build.lua, got %q", args.script)
+	}
+}
+
+func TestParseBuildFlagsBuildContext(t *testing.T) {
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	layout := t.TempDir()
+	dgst := "sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+	os.Args = []string{"luakit", "build", "--addr", "tcp://buildkitd:1234",
+		"--build-context", "base=docker-image://golang:1.23",
+		"--build-context", "src=https://github.com/moby/buildkit.git#v0.27.1",
+		"--build-context", "deps=../deps",
+		"--build-context", "app=oci-layout://" + layout + "@" + dgst,
+		"build.lua"}
+
+	flags := parseBuildFlags()
+	if flags.contexts["base"] != "docker-image://golang:1.23" || flags.contexts["src"] != "https://github.com/moby/buildkit.git#v0.27.1" {
+		t.Errorf("expected sources to be passed on, got %v", flags.contexts)
+	}
+	if flags.contexts["deps"] != "local:deps" || flags.locals["deps"] != "../deps" {
+		t.Errorf("expected a directory to become a local, got %v and %v", flags.contexts, flags.locals)
+	}
+	if len(flags.ociLayouts) != 1 {
+		t.Fatalf("expected one OCI layout, got %v", flags.ociLayouts)
+	}
+	for id, dir := range flags.ociLayouts {
+		if dir != layout || flags.contexts["app"] != "oci-layout://"+id+"@"+dgst {
+			t.Errorf("unexpected OCI layout context %q for store %s=%s", flags.contexts["app"], id, dir)
+		}
+	}
+	if args := getScriptArg(); args.script != "build.lua" {
+		t.Errorf("expected build.lua, got %q", args.script)
+	}
+
+	def, _ := evaluateDefinition(t, `bk.export(bk.scratch())`)
+	opt, err := newSolveOpt(flags, t.TempDir(), def, false)
+	if err != nil {
+		t.Fatalf("newSolveOpt failed: %v", err)
+	}
+	if len(opt.OCIStores) != 1 {
+		t.Errorf("expected the OCI layout to be attached, got %v", opt.OCIStores)
+	}
+}
+
+func TestAddBuildContextRequiresOCIDigest(t *testing.T) {
+	flags := &buildFlags{contexts: map[string]string{}, ociLayouts: map[string]string{}}
+	err := flags.addBuildContext("app", "oci-layout://"+t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "needs a digest") {
+		t.Errorf("expected missing digest error, got %v", err)
+	}
+}
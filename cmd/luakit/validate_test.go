@@ -404,3 +404,36 @@ bk.export(result)
 		t.Errorf("expected no error (should serialize successfully), got: %v", err)
 	}
 }
+
+func TestValidateTarget(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := tmpDir + "/targets.lua"
+
+	script := `local base = bk.image("alpine:3.19")
+bk.target("dev", function()
+    bk.export(base:run("echo dev"))
+end)
+bk.target("empty", function() end)
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	oldArgs := os.Args
+	defer func() { os.Args = oldArgs }()
+
+	os.Args = []string{"luakit", "validate", "--target", "dev", scriptPath}
+	if err := validateScript(); err != nil {
+		t.Errorf("expected no error, got: %v", err)
+	}
+
+	os.Args = []string{"luakit", "validate", "--target", "empty", scriptPath}
+	if err := validateScript(); err == nil || !strings.Contains(err.Error(), "no bk.export() call") {
+		t.Errorf("expected missing export error, got: %v", err)
+	}
+
+	os.Args = []string{"luakit", "validate", "--target", "nope", scriptPath}
+	if err := validateScript(); err == nil || !strings.Contains(err.Error(), `target "nope" not found`) {
+		t.Errorf("expected unknown target error, got: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+// Package stdlib embeds the luakit Lua standard library, such as the
+// prelude module, so it can be required without a stdlib directory on disk.
+package stdlib
+
+import "embed"
+
+// FS holds the stdlib modules, e.g. prelude.lua.
+//
+//go:embed *.lua
+var FS embed.FS
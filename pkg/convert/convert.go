@@ -0,0 +1,761 @@
+// Package convert translates Dockerfiles into luakit build scripts.
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/linter"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+const contextVar = "context"
+
+// Dockerfile converts the Dockerfile source in data into a Lua build script.
+// filename is used in comments that point back at Dockerfile lines.
+// Instructions without a luakit equivalent are emitted as TODO comments.
+func Dockerfile(data []byte, filename string) ([]byte, error) {
+	res, err := parser.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	stages, metaArgs, err := instructions.Parse(res.AST, linter.New(&linter.Config{}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	if len(stages) == 0 {
+		return nil, fmt.Errorf("%s has no FROM instruction", filename)
+	}
+
+	c := &converter{
+		filename: filename,
+		names:    map[string]bool{contextVar: true, "bk": true},
+		metaArgs: make(map[string]string),
+		stages:   make(map[string]*stage),
+	}
+
+	for _, arg := range metaArgs {
+		c.metaArg(&arg)
+	}
+	for i := range stages {
+		c.stage(i, &stages[i], i == len(stages)-1)
+	}
+	c.export(c.order[len(c.order)-1])
+
+	return c.output(), nil
+}
+
+type converter struct {
+	filename string
+
+	header      bytes.Buffer
+	body        bytes.Buffer
+	usesContext bool
+
+	names    map[string]bool
+	metaArgs map[string]string
+	stages   map[string]*stage
+	order    []*stage
+}
+
+// stage tracks the Lua variable and image config of a Dockerfile stage.
+type stage struct {
+	varName string
+
+	args    []kv
+	env     []kv
+	workdir string
+	user    string
+	shell   []string
+
+	entrypoint *table
+	cmd        *table
+	expose     []string
+	labels     []kv
+}
+
+// kv is an ordered key with a rendered Lua value.
+type kv struct {
+	key   string
+	value string
+}
+
+func setKV(list []kv, key, value string) []kv {
+	for i := range list {
+		if list[i].key == key {
+			list[i].value = value
+			return list
+		}
+	}
+	return append(list, kv{key: key, value: value})
+}
+
+func lookupKV(list []kv, key string) (string, bool) {
+	for _, e := range list {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	return "", false
+}
+
+func (s *stage) inherit(base *stage) {
+	s.env = append([]kv(nil), base.env...)
+	s.workdir = base.workdir
+	s.user = base.user
+	s.shell = base.shell
+	s.entrypoint = base.entrypoint
+	s.cmd = base.cmd
+	s.expose = append([]string(nil), base.expose...)
+	s.labels = append([]kv(nil), base.labels...)
+}
+
+// declare reserves a unique Lua identifier derived from name.
+func (c *converter) declare(name string) string {
+	base := identifier(name)
+	id := base
+	for i := 2; c.names[id]; i++ {
+		id = fmt.Sprintf("%s_%d", base, i)
+	}
+	c.names[id] = true
+	return id
+}
+
+func (c *converter) line(format string, args ...any) {
+	fmt.Fprintf(&c.body, format+"\n", args...)
+}
+
+func (c *converter) todo(cmd instructions.Command, reason string) {
+	c.line("-- TODO(%s:%d): %s: %s", c.filename, startLine(cmd.Location()), reason, fmt.Sprint(cmd))
+}
+
+func startLine(location []parser.Range) int {
+	if len(location) == 0 {
+		return 0
+	}
+	return location[0].Start.Line
+}
+
+// metaArg declares an ARG from before the first FROM as a Lua local that
+// reads the build argument with bk.arg.
+func (c *converter) metaArg(cmd *instructions.ArgCommand) {
+	for _, arg := range cmd.Args {
+		id := c.declare(arg.Key)
+		c.metaArgs[arg.Key] = id
+		fmt.Fprintf(&c.header, "local %s = %s\n", id, c.argDefault(arg, nil))
+	}
+}
+
+func (c *converter) argDefault(arg instructions.KeyValuePairOptional, st *stage) string {
+	def := `""`
+	if arg.Value != nil {
+		def = c.expand(*arg.Value, st)
+	}
+	return fmt.Sprintf("bk.arg(%s, %s)", luaString(arg.Key), def)
+}
+
+func (c *converter) stage(index int, s *instructions.Stage, last bool) {
+	name := s.Name
+	if name == "" {
+		name = fmt.Sprintf("stage%d", index)
+		if last {
+			name = "final"
+		}
+	}
+
+	st := &stage{varName: c.declare(name)}
+	c.order = append(c.order, st)
+	c.stages[strconv.Itoa(index)] = st
+	if s.Name != "" {
+		c.stages[strings.ToLower(s.Name)] = st
+	}
+
+	if c.body.Len() > 0 {
+		c.line("")
+	}
+	c.line("-- %s:%d: %s", c.filename, startLine(s.Location), strings.TrimSpace(s.SourceCode))
+
+	if base, ok := c.stages[strings.ToLower(s.BaseName)]; ok && base != st {
+		st.inherit(base)
+		c.line("local %s = %s", st.varName, base.varName)
+	} else if s.BaseName == "scratch" {
+		c.line("local %s = bk.scratch()", st.varName)
+	} else {
+		opts := &table{}
+		if s.Platform != "" {
+			if strings.Contains(s.Platform, "$") {
+				c.line("-- TODO(%s:%d): FROM --platform=%s is not supported", c.filename, startLine(s.Location), s.Platform)
+			} else {
+				opts.add("platform", luaString(s.Platform))
+			}
+		}
+		c.line("local %s = bk.image(%s)", st.varName, c.args(c.expand(s.BaseName, nil), opts))
+	}
+
+	for _, cmd := range s.Commands {
+		c.command(st, cmd)
+	}
+}
+
+func (c *converter) command(st *stage, cmd instructions.Command) {
+	switch cmd := cmd.(type) {
+	case *instructions.RunCommand:
+		c.run(st, cmd)
+	case *instructions.CopyCommand:
+		c.copy(st, cmd)
+	case *instructions.AddCommand:
+		c.add(st, cmd)
+	case *instructions.EnvCommand:
+		// Values in one ENV instruction see the environment from before it.
+		values := make([]string, len(cmd.Env))
+		for i, e := range cmd.Env {
+			values[i] = c.expand(e.Value, st)
+		}
+		for i, e := range cmd.Env {
+			st.env = setKV(st.env, e.Key, values[i])
+		}
+	case *instructions.WorkdirCommand:
+		dir := cmd.Path
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", st.workdir, dir)
+		}
+		st.workdir = dir
+		c.line("%s = %s:mkdir(%s, { make_parents = true })", st.varName, st.varName, c.expand(dir, st))
+	case *instructions.UserCommand:
+		st.user = cmd.User
+	case *instructions.ShellCommand:
+		st.shell = cmd.Shell
+	case *instructions.ArgCommand:
+		c.stageArg(st, cmd)
+	case *instructions.EntrypointCommand:
+		st.entrypoint = c.cmdLine(st, cmd.ShellDependantCmdLine)
+		st.cmd = nil
+	case *instructions.CmdCommand:
+		st.cmd = c.cmdLine(st, cmd.ShellDependantCmdLine)
+	case *instructions.ExposeCommand:
+		for _, port := range cmd.Ports {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			st.expose = append(st.expose, port)
+		}
+	case *instructions.LabelCommand:
+		for _, l := range cmd.Labels {
+			st.labels = setKV(st.labels, l.Key, c.expand(l.Value, st))
+		}
+	default:
+		c.todo(cmd, strings.ToUpper(cmd.Name())+" is not supported")
+	}
+}
+
+func (c *converter) stageArg(st *stage, cmd *instructions.ArgCommand) {
+	for _, arg := range cmd.Args {
+		if id, ok := c.metaArgs[arg.Key]; ok && arg.Value == nil {
+			st.args = setKV(st.args, arg.Key, id)
+			continue
+		}
+		id := c.declare(arg.Key)
+		c.line("local %s = %s", id, c.argDefault(arg, st))
+		st.args = setKV(st.args, arg.Key, id)
+	}
+}
+
+// cmdLine renders RUN/CMD/ENTRYPOINT arguments. Shell form is wrapped in
+// the stage's shell.
+func (c *converter) cmdLine(st *stage, cmd instructions.ShellDependantCmdLine) *table {
+	if !cmd.PrependShell {
+		return stringList(cmd.CmdLine)
+	}
+	shell := st.shell
+	if len(shell) == 0 {
+		shell = []string{"/bin/sh", "-c"}
+	}
+	return stringList(append(append([]string(nil), shell...), strings.Join(cmd.CmdLine, " ")))
+}
+
+func (c *converter) run(st *stage, cmd *instructions.RunCommand) {
+	if len(cmd.Files) > 0 {
+		c.todo(cmd, "RUN with heredocs is not supported")
+		return
+	}
+
+	var args string
+	if cmd.PrependShell && len(st.shell) == 0 {
+		args = luaString(strings.Join(cmd.CmdLine, " "))
+	} else {
+		args = c.cmdLine(st, cmd.ShellDependantCmdLine).render("")
+	}
+
+	opts := &table{}
+	if env := c.runEnv(st); !env.empty() {
+		opts.add("env", env)
+	}
+	if st.workdir != "" {
+		opts.add("cwd", c.expand(st.workdir, st))
+	}
+	if st.user != "" {
+		opts.add("user", c.expand(st.user, st))
+	}
+
+	if err := cmd.Expand(func(word string) (string, error) { return word, nil }); err != nil {
+		c.todo(cmd, fmt.Sprintf("invalid RUN flags (%v)", err))
+		return
+	}
+	if network := instructions.GetNetwork(cmd); network != instructions.NetworkDefault {
+		opts.add("network", luaString(network))
+	}
+	if security := instructions.GetSecurity(cmd); security != instructions.SecuritySandbox {
+		opts.add("security", luaString(security))
+	}
+
+	mounts := &table{}
+	secretEnv := &table{}
+	for _, m := range instructions.GetMounts(cmd) {
+		if m.Type == instructions.MountTypeSecret && m.Env != nil {
+			secretEnv.add(*m.Env, secretEnvValue(m))
+			// As in a Dockerfile, the secret is only also mounted as a
+			// file when a target is given.
+			if m.Target == "" {
+				continue
+			}
+		}
+		mounts.push(c.mount(st, m))
+	}
+	if !mounts.empty() {
+		opts.add("mounts", mounts)
+	}
+	if !secretEnv.empty() {
+		opts.add("secret_env", secretEnv)
+	}
+
+	c.line("%s = %s:run(%s)", st.varName, st.varName, c.args(args, opts))
+}
+
+// runEnv returns the stage's build args and ENV values, which Dockerfile
+// RUN instructions see as environment variables.
+func (c *converter) runEnv(st *stage) *table {
+	env := &table{}
+	for _, a := range st.args {
+		if _, ok := lookupKV(st.env, a.key); !ok {
+			env.add(a.key, a.value)
+		}
+	}
+	for _, e := range st.env {
+		env.add(e.key, e.value)
+	}
+	return env
+}
+
+// secretEnvValue returns the secret_env entry for a secret mount exposed as
+// an environment variable: its id, or a table when the secret is optional.
+func secretEnvValue(m *instructions.Mount) any {
+	id := m.CacheID
+	if id == "" {
+		id = path.Base(m.Target)
+	}
+	if m.Required {
+		return luaString(id)
+	}
+	opts := &table{}
+	opts.add("id", luaString(id))
+	opts.add("optional", "true")
+	return opts
+}
+
+func (c *converter) mount(st *stage, m *instructions.Mount) string {
+	opts := &table{}
+	switch m.Type {
+	case instructions.MountTypeCache:
+		if m.CacheID != "" {
+			opts.add("id", luaString(m.CacheID))
+		}
+		if m.CacheSharing != "" && m.CacheSharing != instructions.MountSharingShared {
+			opts.add("sharing", luaString(string(m.CacheSharing)))
+		}
+		return "bk.cache(" + c.args(luaString(m.Target), opts) + ")"
+
+	case instructions.MountTypeSecret:
+		id := m.CacheID
+		if id == "" {
+			id = path.Base(m.Target)
+		}
+		target := m.Target
+		if target == "" {
+			target = "/run/secrets/" + id
+		}
+		opts.add("id", luaString(id))
+		c.addIDs(opts, m)
+		if !m.Required {
+			opts.add("optional", "true")
+		}
+		return "bk.secret(" + c.args(luaString(target), opts) + ")"
+
+	case instructions.MountTypeSSH:
+		if m.CacheID != "" && m.CacheID != "default" {
+			opts.add("id", luaString(m.CacheID))
+		}
+		if m.Target != "" {
+			opts.add("dest", luaString(m.Target))
+		}
+		c.addIDs(opts, m)
+		if !m.Required {
+			opts.add("optional", "true")
+		}
+		if opts.empty() {
+			return "bk.ssh()"
+		}
+		return "bk.ssh(" + opts.render("") + ")"
+
+	case instructions.MountTypeTmpfs:
+		if m.SizeLimit > 0 {
+			opts.add("size", strconv.FormatInt(m.SizeLimit, 10))
+		}
+		return "bk.tmpfs(" + c.args(luaString(m.Target), opts) + ")"
+
+	default:
+		if m.Source != "" && m.Source != "/" && m.Source != "." {
+			opts.add("selector", luaString(m.Source))
+		}
+		if !m.ReadOnly {
+			opts.add("readonly", "false")
+		}
+		return "bk.bind(" + c.args(c.from(st, m.From)+", "+luaString(m.Target), opts) + ")"
+	}
+}
+
+func (c *converter) addIDs(opts *table, m *instructions.Mount) {
+	if m.UID != nil {
+		opts.add("uid", strconv.FormatUint(*m.UID, 10))
+	}
+	if m.GID != nil {
+		opts.add("gid", strconv.FormatUint(*m.GID, 10))
+	}
+	if m.Mode != nil {
+		opts.add("mode", strconv.FormatUint(*m.Mode, 10))
+	}
+}
+
+// from returns the Lua expression for a --from value: an earlier stage,
+// an image, or the build context when empty.
+func (c *converter) from(st *stage, from string) string {
+	if from == "" {
+		c.usesContext = true
+		return contextVar
+	}
+	if src, ok := c.stages[strings.ToLower(from)]; ok && src != st {
+		return src.varName
+	}
+	return "bk.image(" + c.expand(from, st) + ")"
+}
+
+func (c *converter) copy(st *stage, cmd *instructions.CopyCommand) {
+	if cmd.Parents {
+		c.todo(cmd, "COPY --parents is not supported, sources are copied without their parent directories")
+	}
+	c.copySources(st, &cmd.SourcesAndDest, c.from(st, cmd.From), cmd.Chown, cmd.Chmod, cmd.ExcludePatterns)
+}
+
+func (c *converter) add(st *stage, cmd *instructions.AddCommand) {
+	var local []string
+	for _, src := range cmd.SourcePaths {
+		switch {
+		case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+			c.addURL(st, cmd, src)
+		case isGitURL(src):
+			c.todo(cmd, "ADD from git is not converted, use bk.git")
+		default:
+			if isArchive(src) {
+				c.todo(cmd, "ADD extracts local archives, extract "+src+" with a run step")
+			}
+			local = append(local, src)
+		}
+	}
+
+	if len(local) > 0 || len(cmd.SourceContents) > 0 {
+		sd := cmd.SourcesAndDest
+		sd.SourcePaths = local
+		c.copySources(st, &sd, c.from(st, ""), cmd.Chown, cmd.Chmod, cmd.ExcludePatterns)
+	}
+}
+
+func (c *converter) addURL(st *stage, cmd *instructions.AddCommand, url string) {
+	opts := &table{}
+	if cmd.Checksum != "" {
+		opts.add("checksum", luaString(cmd.Checksum))
+	}
+	fn := "bk.https"
+	if strings.HasPrefix(url, "http://") {
+		fn = "bk.http"
+	}
+
+	name := path.Base(url)
+	dest := c.dest(st, cmd.DestPath)
+	if strings.HasSuffix(dest, "/") {
+		dest += name
+	}
+
+	src := fn + "(" + c.args(c.expand(url, st), opts) + ")"
+	c.line("%s = %s:copy(%s)", st.varName, st.varName, c.args(src+", "+luaString(name)+", "+c.expand(dest, st), c.copyOptions(st, name, dest, cmd.Chown, cmd.Chmod, nil)))
+}
+
+func (c *converter) copySources(st *stage, sd *instructions.SourcesAndDest, from, chown, chmod string, exclude []string) {
+	dest := c.dest(st, sd.DestPath)
+
+	for _, content := range sd.SourceContents {
+		target := dest
+		if strings.HasSuffix(target, "/") {
+			target += content.Path
+		}
+		opts := &table{}
+		if chmod != "" {
+			opts.add("mode", luaString(chmod))
+		}
+		c.line("%s = %s:mkfile(%s)", st.varName, st.varName, c.args(c.expand(target, st)+", "+luaString(content.Data), opts))
+	}
+
+	for _, src := range sd.SourcePaths {
+		opts := c.copyOptions(st, src, dest, chown, chmod, exclude)
+		c.line("%s = %s:copy(%s)", st.varName, st.varName, c.args(from+", "+c.expand(src, st)+", "+c.expand(dest, st), opts))
+	}
+}
+
+func (c *converter) copyOptions(st *stage, src, dest, chown, chmod string, exclude []string) *table {
+	opts := &table{}
+	if chown != "" {
+		opts.add("owner", ownerTable(chown))
+	}
+	if chmod != "" {
+		opts.add("mode", luaString(chmod))
+	}
+	if strings.ContainsAny(src, "*?[") {
+		opts.add("allow_wildcard", "true")
+	}
+	if len(exclude) > 0 {
+		opts.add("exclude", stringList(exclude))
+	}
+	if dir := path.Dir(strings.TrimSuffix(dest, "/")); dir != "/" && dir != "." {
+		opts.add("create_dest_path", "true")
+	}
+	return opts
+}
+
+// dest resolves a COPY/ADD destination against the stage's WORKDIR.
+func (c *converter) dest(st *stage, dest string) string {
+	if path.IsAbs(dest) {
+		return dest
+	}
+	joined := path.Join("/", st.workdir, dest)
+	if strings.HasSuffix(dest, "/") || dest == "." {
+		joined = strings.TrimSuffix(joined, "/") + "/"
+	}
+	return joined
+}
+
+func ownerTable(chown string) *table {
+	t := &table{}
+	user, group, hasGroup := strings.Cut(chown, ":")
+	t.add("user", ownerValue(user))
+	if hasGroup {
+		t.add("group", ownerValue(group))
+	}
+	return t
+}
+
+func ownerValue(v string) string {
+	if _, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return v
+	}
+	return luaString(v)
+}
+
+func isGitURL(src string) bool {
+	return strings.HasPrefix(src, "git@") || strings.HasPrefix(src, "git://") || strings.HasSuffix(src, ".git")
+}
+
+func isArchive(src string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst"} {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *converter) export(st *stage) {
+	opts := &table{}
+	if st.entrypoint != nil {
+		opts.add("entrypoint", st.entrypoint)
+	}
+	if st.cmd != nil {
+		opts.add("cmd", st.cmd)
+	}
+	if len(st.env) > 0 {
+		env := &table{}
+		for _, e := range st.env {
+			env.add(e.key, e.value)
+		}
+		opts.add("env", env)
+	}
+	if st.workdir != "" {
+		opts.add("workdir", c.expand(st.workdir, st))
+	}
+	if st.user != "" {
+		opts.add("user", c.expand(st.user, st))
+	}
+	if len(st.expose) > 0 {
+		opts.add("expose", stringList(st.expose))
+	}
+	if len(st.labels) > 0 {
+		labels := &table{}
+		for _, l := range st.labels {
+			labels.add(l.key, l.value)
+		}
+		opts.add("labels", labels)
+	}
+
+	c.line("")
+	c.line("bk.export(%s)", c.args(st.varName, opts))
+}
+
+// args renders a call's arguments followed by an options table when it has
+// entries.
+func (c *converter) args(args string, opts *table) string {
+	if opts.empty() {
+		return args
+	}
+	if inline, ok := opts.inline(); ok && len(args)+len(inline) <= maxInlineWidth {
+		return args + ", " + inline
+	}
+	return args + ", " + opts.multiline("")
+}
+
+func (c *converter) output() []byte {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "-- Converted from %s by luakit convert.\n", c.filename)
+	if c.header.Len() > 0 {
+		out.WriteString("\n")
+		out.Write(c.header.Bytes())
+	}
+	if c.usesContext {
+		out.WriteString("\n")
+		fmt.Fprintf(&out, "local %s = bk.local_(\"context\")\n", contextVar)
+	}
+	out.WriteString("\n")
+	out.Write(c.body.Bytes())
+	return out.Bytes()
+}
+
+// expand renders a Dockerfile word as a Lua expression. Quotes and escapes
+// are processed like the Dockerfile shell lexer does; references to build
+// args become Lua variables and references to earlier ENV values are
+// inlined. Unknown variables expand to nothing.
+func (c *converter) expand(word string, st *stage) string {
+	var parts []string
+	var lit strings.Builder
+
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, luaString(lit.String()))
+			lit.Reset()
+		}
+	}
+
+	var quote byte
+	for i := 0; i < len(word); i++ {
+		ch := word[i]
+
+		switch {
+		case quote == '\'':
+			if ch == '\'' {
+				quote = 0
+			} else {
+				lit.WriteByte(ch)
+			}
+			continue
+		case ch == '\\' && i+1 < len(word):
+			next := word[i+1]
+			if quote == '"' && next != '"' && next != '\\' && next != '$' {
+				lit.WriteByte(ch)
+				continue
+			}
+			lit.WriteByte(next)
+			i++
+			continue
+		case ch == '"':
+			if quote == '"' {
+				quote = 0
+			} else {
+				quote = '"'
+			}
+			continue
+		case ch == '\'' && quote == 0:
+			quote = '\''
+			continue
+		case ch != '$':
+			lit.WriteByte(ch)
+			continue
+		}
+
+		name, end := varRef(word, i)
+		if name == "" {
+			lit.WriteByte(ch)
+			continue
+		}
+		value, ok := c.lookup(name, st)
+		if !ok {
+			// Like the Dockerfile frontend, unknown variables expand to
+			// nothing.
+			i = end - 1
+			continue
+		}
+		flush()
+		parts = append(parts, value)
+		i = end - 1
+	}
+	flush()
+
+	if len(parts) == 0 {
+		return `""`
+	}
+	return strings.Join(parts, " .. ")
+}
+
+func (c *converter) lookup(name string, st *stage) (string, bool) {
+	if st == nil {
+		id, ok := c.metaArgs[name]
+		return id, ok
+	}
+	if v, ok := lookupKV(st.env, name); ok {
+		return v, true
+	}
+	return lookupKV(st.args, name)
+}
+
+// varRef parses $NAME or ${NAME} at word[i] and returns the name and the
+// index after the reference. Modifiers such as ${NAME:-x} are not handled.
+func varRef(word string, i int) (string, int) {
+	j := i + 1
+	if j < len(word) && word[j] == '{' {
+		end := strings.IndexByte(word[j:], '}')
+		if end < 0 {
+			return "", i
+		}
+		name := word[j+1 : j+end]
+		if !isIdentifier(name) {
+			return "", i
+		}
+		return name, j + end + 1
+	}
+	for j < len(word) && (word[j] == '_' || word[j] >= 'a' && word[j] <= 'z' || word[j] >= 'A' && word[j] <= 'Z' || j > i+1 && word[j] >= '0' && word[j] <= '9') {
+		j++
+	}
+	if j == i+1 {
+		return "", i
+	}
+	return word[i+1 : j], j
+}
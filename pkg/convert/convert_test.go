@@ -0,0 +1,242 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kasuboski/luakit/pkg/luafmt"
+	"github.com/kasuboski/luakit/pkg/luavm"
+)
+
+func convert(t *testing.T, dockerfile string) string {
+	t.Helper()
+	out, err := Dockerfile([]byte(dockerfile), "Dockerfile")
+	if err != nil {
+		t.Fatalf("Dockerfile failed: %v", err)
+	}
+	return string(out)
+}
+
+// evaluate checks that the generated script runs and exports a state.
+func evaluate(t *testing.T, script string) {
+	t.Helper()
+	result, err := luavm.Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("generated script failed to evaluate: %v\n%s", err, script)
+	}
+	if result.State == nil {
+		t.Fatalf("generated script did not export a state:\n%s", script)
+	}
+}
+
+func assertContains(t *testing.T, script string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !strings.Contains(script, w) {
+			t.Errorf("expected output to contain %q, got:\n%s", w, script)
+		}
+	}
+}
+
+func TestMultiStage(t *testing.T) {
+	script := convert(t, `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.22
+FROM golang:${GO_VERSION} AS builder
+WORKDIR /src
+COPY go.mod go.sum ./
+RUN --mount=type=cache,target=/go/pkg/mod go mod download
+COPY . .
+RUN go build -o /out/app ./cmd/app
+
+FROM alpine:3.19
+COPY --from=builder --chown=app:app /out/app /usr/local/bin/app
+ENTRYPOINT ["/usr/local/bin/app"]
+CMD ["--port", "8080"]
+`)
+
+	assertContains(t, script,
+		`local GO_VERSION = bk.arg("GO_VERSION", "1.22")`,
+		`local context = bk.local_("context")`,
+		"-- Dockerfile:3: FROM golang:${GO_VERSION} AS builder",
+		`local builder = bk.image("golang:" .. GO_VERSION)`,
+		`builder = builder:mkdir("/src", { make_parents = true })`,
+		`builder = builder:copy(context, "go.mod", "/src/")`,
+		`builder = builder:copy(context, "go.sum", "/src/")`,
+		`builder = builder:run("go mod download", { cwd = "/src", mounts = { bk.cache("/go/pkg/mod") } })`,
+		`local final = bk.image("alpine:3.19")`,
+		`final = final:copy(builder, "/out/app", "/usr/local/bin/app", {`,
+		`owner = { user = "app", group = "app" },`,
+		`entrypoint = { "/usr/local/bin/app" },`,
+		`cmd = { "--port", "8080" },`,
+	)
+	evaluate(t, script)
+}
+
+func TestStageFromStage(t *testing.T) {
+	script := convert(t, `FROM alpine AS base
+ENV A=1
+WORKDIR /app
+
+FROM base
+RUN echo $A
+`)
+
+	assertContains(t, script,
+		"local base = bk.image(\"alpine\")",
+		"local final = base\n",
+		`final = final:run("echo $A", { env = { A = "1" }, cwd = "/app" })`,
+		`bk.export(final, { env = { A = "1" }, workdir = "/app" })`,
+	)
+	evaluate(t, script)
+}
+
+func TestEnvQuotingAndExpansion(t *testing.T) {
+	script := convert(t, `FROM alpine
+ENV PORT=8080 GREETING="hello world" SAME_LINE=$PORT
+ENV URL="http://localhost:${PORT}" SINGLE='$PORT' ESCAPED="say \"hi\""
+`)
+
+	assertContains(t, script,
+		`PORT = "8080"`,
+		`GREETING = "hello world"`,
+		`SAME_LINE = ""`,
+		`URL = "http://localhost:" .. "8080"`,
+		`SINGLE = "$PORT"`,
+		`ESCAPED = 'say "hi"'`,
+	)
+	evaluate(t, script)
+}
+
+func TestRunOptions(t *testing.T) {
+	script := convert(t, `FROM alpine
+ARG VERSION=1.0
+RUN --network=none --security=insecure \
+    --mount=type=secret,id=netrc,target=/root/.netrc,required=true \
+    --mount=type=ssh \
+    --mount=type=tmpfs,target=/tmp \
+    --mount=type=bind,source=src,target=/src \
+    make VERSION=$VERSION
+`)
+
+	assertContains(t, script,
+		`local VERSION = bk.arg("VERSION", "1.0")`,
+		`env = { VERSION = VERSION },`,
+		`network = "none",`,
+		`security = "insecure",`,
+		`bk.secret("/root/.netrc", { id = "netrc" }),`,
+		`bk.ssh({ optional = true }),`,
+		`bk.tmpfs("/tmp"),`,
+		`bk.bind(context, "/src", { selector = "src" }),`,
+	)
+	evaluate(t, script)
+}
+
+func TestRunSecretEnv(t *testing.T) {
+	script := convert(t, `FROM alpine
+RUN --mount=type=secret,id=npm_token,env=NPM_TOKEN,required=true \
+    --mount=type=secret,id=aws,env=AWS_CONFIG,target=/root/.aws/config \
+    npm publish
+`)
+
+	assertContains(t, script,
+		`mounts = { bk.secret("/root/.aws/config", { id = "aws", optional = true }) },`,
+		`NPM_TOKEN = "npm_token",`,
+		`AWS_CONFIG = { id = "aws", optional = true },`,
+	)
+	if strings.Contains(script, "TODO") {
+		t.Errorf("expected no TODOs, got:\n%s", script)
+	}
+	evaluate(t, script)
+}
+
+func TestUnsupportedInstructionsBecomeTODOs(t *testing.T) {
+	script := convert(t, `FROM alpine
+HEALTHCHECK CMD curl -f http://localhost/ || exit 1
+VOLUME /data
+RUN <<EOF
+echo hi
+EOF
+`)
+
+	assertContains(t, script,
+		"-- TODO(Dockerfile:2): HEALTHCHECK is not supported: HEALTHCHECK CMD",
+		"-- TODO(Dockerfile:3): VOLUME is not supported",
+		"-- TODO(Dockerfile:4): RUN with heredocs is not supported",
+	)
+	evaluate(t, script)
+}
+
+func TestAddURLAndHeredocCopy(t *testing.T) {
+	script := convert(t, `FROM alpine
+WORKDIR /etc/app
+ADD --checksum=sha256:abcd https://example.com/config.json ./
+COPY <<EOF hello.txt
+hi
+EOF
+`)
+
+	assertContains(t, script,
+		`bk.https("https://example.com/config.json", { checksum = "sha256:abcd" })`,
+		`"config.json", "/etc/app/config.json"`,
+		`final = final:mkfile("/etc/app/hello.txt", "hi\n")`,
+	)
+	evaluate(t, script)
+}
+
+func TestScratchAndExposeLabels(t *testing.T) {
+	script := convert(t, `FROM scratch
+EXPOSE 80 53/udp
+LABEL org.opencontainers.image.title="app" version=1
+USER 1000
+`)
+
+	assertContains(t, script,
+		"local final = bk.scratch()",
+		`"80/tcp"`,
+		`"53/udp"`,
+		`["org.opencontainers.image.title"] = "app"`,
+		`user = "1000",`,
+	)
+	evaluate(t, script)
+}
+
+func TestStageNamesAreSanitized(t *testing.T) {
+	script := convert(t, `FROM alpine AS build-env
+FROM alpine AS end
+COPY --from=build-env /a /a
+`)
+
+	assertContains(t, script,
+		`local build_env = bk.image("alpine")`,
+		`local end_ = bk.image("alpine")`,
+		`end_ = end_:copy(build_env, "/a", "/a")`,
+		"bk.export(end_)",
+	)
+	evaluate(t, script)
+}
+
+func TestOutputIsFormatted(t *testing.T) {
+	script := convert(t, `FROM golang:1.22 AS builder
+WORKDIR /src
+RUN --mount=type=cache,target=/go/pkg/mod --mount=type=cache,target=/root/.cache/go-build go build ./...
+
+FROM alpine
+COPY --from=builder --chown=app:app /out/app /usr/local/bin/app
+LABEL org.opencontainers.image.title="app"
+ENTRYPOINT ["/usr/local/bin/app"]
+`)
+
+	formatted, err := luafmt.Source([]byte(script), "build.lua")
+	if err != nil {
+		t.Fatalf("failed to format generated script: %v", err)
+	}
+	if string(formatted) != script {
+		t.Errorf("generated script is not formatted:\n%s\nluakit fmt:\n%s", script, formatted)
+	}
+}
+
+func TestInvalidDockerfile(t *testing.T) {
+	if _, err := Dockerfile([]byte("RUN echo hi\n"), "Dockerfile"); err == nil {
+		t.Error("expected error for Dockerfile without FROM")
+	}
+}
@@ -0,0 +1,199 @@
+package convert
+
+import (
+	"fmt"
+	"strings"
+)
+
+const indentUnit = "    "
+
+// maxInlineWidth is the longest table rendered on a single line.
+const maxInlineWidth = 72
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true,
+	"or": true, "repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+// table is a Lua table constructor. Values are either rendered Lua code
+// (string) or nested tables.
+type table struct {
+	entries []entry
+}
+
+type entry struct {
+	key   string // empty for list entries
+	value any
+}
+
+func (t *table) add(key string, value any) {
+	t.entries = append(t.entries, entry{key: key, value: value})
+}
+
+func (t *table) push(value any) {
+	t.entries = append(t.entries, entry{value: value})
+}
+
+func (t *table) empty() bool {
+	return t == nil || len(t.entries) == 0
+}
+
+// render returns the table as Lua source. Short tables stay on one line;
+// longer ones are split one entry per line with trailing commas.
+func (t *table) render(indent string) string {
+	if t.empty() {
+		return "{}"
+	}
+
+	if inline, ok := t.inline(); ok && len(indent)+len(inline) <= maxInlineWidth {
+		return inline
+	}
+	return t.multiline(indent)
+}
+
+// multiline renders one entry per line with trailing commas. Nested tables
+// may still be inlined.
+func (t *table) multiline(indent string) string {
+	inner := indent + indentUnit
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, e := range t.entries {
+		b.WriteString(inner)
+		if e.key != "" {
+			b.WriteString(luaKey(e.key))
+			b.WriteString(" = ")
+		}
+		b.WriteString(renderValue(e.value, inner))
+		b.WriteString(",\n")
+	}
+	b.WriteString(indent)
+	b.WriteString("}")
+	return b.String()
+}
+
+func (t *table) inline() (string, bool) {
+	parts := make([]string, 0, len(t.entries))
+	for _, e := range t.entries {
+		var v string
+		switch value := e.value.(type) {
+		case *table:
+			s, ok := value.inline()
+			if !ok {
+				return "", false
+			}
+			v = s
+		case string:
+			if strings.Contains(value, "\n") {
+				return "", false
+			}
+			v = value
+		}
+		if e.key != "" {
+			v = luaKey(e.key) + " = " + v
+		}
+		parts = append(parts, v)
+	}
+	return "{ " + strings.Join(parts, ", ") + " }", true
+}
+
+func renderValue(v any, indent string) string {
+	switch value := v.(type) {
+	case *table:
+		return value.render(indent)
+	case string:
+		return value
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// luaKey renders a table key, bracketing keys that are not identifiers.
+func luaKey(key string) string {
+	if isIdentifier(key) {
+		return key
+	}
+	return "[" + luaString(key) + "]"
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || luaKeywords[s] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// luaString quotes s as a Lua string literal, preferring double quotes.
+func luaString(s string) string {
+	quote := byte('"')
+	if strings.Contains(s, `"`) && !strings.Contains(s, "'") {
+		quote = '\''
+	}
+
+	var b strings.Builder
+	b.WriteByte(quote)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(quote)
+	return b.String()
+}
+
+func stringList(values []string) *table {
+	t := &table{}
+	for _, v := range values {
+		t.push(luaString(v))
+	}
+	return t
+}
+
+// identifier turns a Dockerfile name into a Lua identifier.
+func identifier(name string) string {
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteString("stage_")
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	id := b.String()
+	if id == "" {
+		return "stage"
+	}
+	if luaKeywords[id] {
+		return id + "_"
+	}
+	return id
+}
@@ -0,0 +1,72 @@
+package dag
+
+import (
+	"fmt"
+	"slices"
+
+	pb "github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// FromDefinition rebuilds the graph of a marshaled LLB definition, such as
+// a frontend input, and returns the state it outputs. The last op of def
+// must be the terminal op that selects the output, as llb.Marshal and
+// Serialize write it. The imported ops have no Lua location.
+func FromDefinition(def *pb.Definition) (*State, error) {
+	if def == nil || len(def.Def) == 0 {
+		return nil, fmt.Errorf("empty definition")
+	}
+
+	nodes := make(map[string]*OpNode, len(def.Def))
+	ops := make([]*pb.Op, len(def.Def))
+	dgsts := make([]string, len(def.Def))
+	for i, dt := range def.Def {
+		var op pb.Op
+		if err := op.UnmarshalVT(dt); err != nil {
+			return nil, fmt.Errorf("failed to parse definition: %w", err)
+		}
+		ops[i] = &op
+		dgsts[i] = digest.FromBytes(dt).String()
+	}
+
+	last := ops[len(ops)-1]
+	if last.Op != nil || len(last.Inputs) != 1 {
+		return nil, fmt.Errorf("definition has no terminal op")
+	}
+
+	var build func(dgst string, visiting map[string]bool) (*OpNode, error)
+	build = func(dgst string, visiting map[string]bool) (*OpNode, error) {
+		if node, ok := nodes[dgst]; ok {
+			return node, nil
+		}
+		if visiting[dgst] {
+			return nil, fmt.Errorf("definition has a cycle at %s", dgst)
+		}
+		visiting[dgst] = true
+
+		i := slices.Index(dgsts, dgst)
+		if i < 0 {
+			return nil, fmt.Errorf("definition references missing op %s", dgst)
+		}
+		node := NewOpNode(ops[i], "", 0)
+		if meta, ok := def.Metadata[dgst]; ok {
+			node.SetMetadata(meta)
+		}
+		for _, input := range ops[i].Inputs {
+			parent, err := build(input.Digest, visiting)
+			if err != nil {
+				return nil, err
+			}
+			node.AddInput(NewEdge(parent, int(input.Index)))
+		}
+		nodes[dgst] = node
+		return node, nil
+	}
+
+	output := last.Inputs[0]
+	node, err := build(output.Digest, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	return NewStateWithOutput(node, int(output.Index)), nil
+}
@@ -0,0 +1,43 @@
+package dag
+
+import (
+	"testing"
+
+	pb "github.com/moby/buildkit/solver/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromDefinitionRoundTrip(t *testing.T) {
+	base := imageState("golang:1.23")
+	state := NewStateWithOutput(execState(base, imageState("busybox")).Op(), 1)
+	state.Op().SetMetadata(&pb.OpMetadata{Description: map[string]string{"llb.customname": "make"}})
+
+	def, err := Serialize(state, nil)
+	require.NoError(t, err)
+
+	imported, err := FromDefinition(def)
+	require.NoError(t, err)
+	require.Equal(t, 1, imported.OutputIndex())
+	require.Len(t, imported.Op().Inputs(), 2)
+	require.Empty(t, imported.Op().LuaFile(), "imported ops have no Lua location")
+	require.Equal(t, "make", imported.Op().Metadata().Description["llb.customname"])
+
+	again, err := Serialize(imported, nil)
+	require.NoError(t, err)
+	require.Equal(t, def.Def, again.Def)
+}
+
+func TestFromDefinitionErrors(t *testing.T) {
+	_, err := FromDefinition(&pb.Definition{})
+	require.ErrorContains(t, err, "empty definition")
+
+	source, err := (&pb.Op{Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "scratch"}}}).MarshalVT()
+	require.NoError(t, err)
+	_, err = FromDefinition(&pb.Definition{Def: [][]byte{source}})
+	require.ErrorContains(t, err, "no terminal op")
+
+	terminal, err := (&pb.Op{Inputs: []*pb.Input{{Digest: "sha256:0000000000000000000000000000000000000000000000000000000000000000"}}}).MarshalVT()
+	require.NoError(t, err)
+	_, err = FromDefinition(&pb.Definition{Def: [][]byte{source, terminal}})
+	require.ErrorContains(t, err, "missing op")
+}
@@ -12,6 +12,9 @@ const (
 	maxCacheSize         = 4096
 )
 
+// The caches are content-addressed: an op's deterministic encoding maps to
+// its digest, and a digest to that encoding. Entries never depend on the
+// graph they came from, so concurrent evaluations can share them.
 var (
 	digestCache    = make(map[string]digest.Digest, initialCacheCapacity)
 	digestMu       sync.RWMutex
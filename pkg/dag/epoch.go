@@ -0,0 +1,73 @@
+package dag
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/solver/pb"
+)
+
+// sourceDateEpochEnv is the environment variable runs see the epoch in, as
+// defined by reproducible-builds.org.
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// applySourceDateEpoch stamps the ops state was built from with epoch: file
+// actions that create files get it as their timestamp, and runs get it as
+// SOURCE_DATE_EPOCH unless they set the variable themselves.
+func applySourceDateEpoch(state *State, epoch time.Time) {
+	ts := epoch.UnixNano()
+	env := sourceDateEpochEnv + "=" + strconv.FormatInt(epoch.Unix(), 10)
+	visited := make(map[*OpNode]bool)
+
+	var walkEpoch func(*OpNode)
+	walkEpoch = func(node *OpNode) {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+		for _, edge := range node.Inputs() {
+			walkEpoch(edge.Node())
+		}
+
+		switch op := node.Op().Op.(type) {
+		case *pb.Op_File:
+			for _, action := range op.File.Actions {
+				setActionTimestamp(action, ts)
+			}
+		case *pb.Op_Exec:
+			if op.Exec.Meta == nil {
+				op.Exec.Meta = &pb.Meta{}
+			}
+			if !hasEnv(op.Exec.Meta.Env, sourceDateEpochEnv) {
+				op.Exec.Meta.Env = append(op.Exec.Meta.Env, env)
+			}
+		default:
+			return
+		}
+		node.InvalidateDigest()
+	}
+	walkEpoch(state.Op())
+}
+
+func setActionTimestamp(action *pb.FileAction, ts int64) {
+	switch a := action.Action.(type) {
+	case *pb.FileAction_Copy:
+		a.Copy.Timestamp = ts
+	case *pb.FileAction_Mkdir:
+		a.Mkdir.Timestamp = ts
+	case *pb.FileAction_Mkfile:
+		a.Mkfile.Timestamp = ts
+	case *pb.FileAction_Symlink:
+		a.Symlink.Timestamp = ts
+	}
+}
+
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if k, _, _ := strings.Cut(e, "="); k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package dag
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/moby/buildkit/solver/pb"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeSourceDateEpoch(t *testing.T) {
+	epoch := time.Unix(1700000000, 0).UTC()
+	base := imageState("alpine:3.19")
+	built := execState(base, imageState("busybox"))
+	state := copyState(built, 3)
+
+	opts := &SerializeOptions{SourceDateEpoch: &epoch, InheritImageConfig: true}
+	_, config, err := SerializeImage(state, opts)
+	require.NoError(t, err)
+
+	mkdir := state.Op().Op().GetFile().Actions[0].GetMkdir()
+	require.Equal(t, epoch.UnixNano(), mkdir.Timestamp)
+	require.Contains(t, built.Op().Op().GetExec().Meta.Env, "SOURCE_DATE_EPOCH=1700000000")
+	require.NotNil(t, config.Created)
+	require.True(t, config.Created.Equal(epoch))
+}
+
+func TestSerializeSourceDateEpochKeepsExplicitValues(t *testing.T) {
+	epoch := time.Unix(1700000000, 0).UTC()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := execState(imageState("alpine:3.19"), imageState("busybox"))
+	exec := state.Op().Op().GetExec()
+	exec.Meta.Env = []string{"SOURCE_DATE_EPOCH=0"}
+
+	exported := &dockerspec.DockerOCIImage{}
+	exported.Created = &created
+
+	opts := &SerializeOptions{ImageConfig: exported, SourceDateEpoch: &epoch}
+	_, config, err := SerializeImage(state, opts)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"SOURCE_DATE_EPOCH=0"}, exec.Meta.Env)
+	require.True(t, config.Created.Equal(created))
+}
+
+func TestSetActionTimestamp(t *testing.T) {
+	actions := []*pb.FileAction{
+		{Action: &pb.FileAction_Copy{Copy: &pb.FileActionCopy{}}},
+		{Action: &pb.FileAction_Mkfile{Mkfile: &pb.FileActionMkFile{}}},
+		{Action: &pb.FileAction_Symlink{Symlink: &pb.FileActionSymlink{}}},
+		{Action: &pb.FileAction_Rm{Rm: &pb.FileActionRm{}}},
+	}
+	for _, a := range actions {
+		setActionTimestamp(a, 42)
+	}
+	require.Equal(t, int64(42), actions[0].GetCopy().Timestamp)
+	require.Equal(t, int64(42), actions[1].GetMkfile().Timestamp)
+	require.Equal(t, int64(42), actions[2].GetSymlink().Timestamp)
+}
@@ -10,6 +10,18 @@ import (
 // ImageConfig holds resolved image configuration
 type ImageConfig struct {
 	Config *ocispec.Image
+	// Steps lists the config-only calls, such as state:env, that built
+	// Config, in call order.
+	Steps []ConfigStep
+}
+
+// ConfigStep is a change to the image config made in Lua on the output of
+// an op. It becomes an empty-layer image history entry after the entry for
+// that op.
+type ConfigStep struct {
+	After   *OpNode
+	LuaFile string
+	LuaLine int
 }
 
 var (
@@ -239,6 +251,80 @@ func (n *OpNode) Platform() *pb.Platform {
 	return n.platform
 }
 
+// ApplyPlatform returns a State over a copy of the graph reachable from s
+// in which every Op without a platform gets the one set on its node, else
+// platform. Ops created with an explicit platform keep it, so
+// cross-platform stages (e.g. a native toolchain image) stay intact. The
+// nodes of s are left unchanged, so exports for several platforms can share
+// them.
+func (s *State) ApplyPlatform(platform *pb.Platform) *State {
+	copies := make(map[*OpNode]*OpNode)
+
+	var clone func(*OpNode) *OpNode
+	clone = func(node *OpNode) *OpNode {
+		if c, ok := copies[node]; ok {
+			return c
+		}
+
+		c := &OpNode{
+			op:            node.op.CloneVT(),
+			metadata:      node.metadata.CloneVT(),
+			inputs:        make([]*Edge, 0, len(node.inputs)),
+			luaFile:       node.luaFile,
+			luaLine:       node.luaLine,
+			resolveConfig: node.resolveConfig,
+			platform:      node.platform,
+			imageConfig:   node.imageConfig,
+		}
+		copies[node] = c
+		for _, edge := range node.inputs {
+			c.inputs = append(c.inputs, NewEdge(clone(edge.node), edge.outputIndex))
+		}
+
+		if c.op == nil || c.op.Platform != nil {
+			return c
+		}
+		p := c.platform
+		if p == nil {
+			p = platform
+		}
+		c.op.Platform = &pb.Platform{
+			OS:           p.OS,
+			Architecture: p.Architecture,
+			Variant:      p.Variant,
+			OSVersion:    p.OSVersion,
+			OSFeatures:   p.OSFeatures,
+		}
+		c.platform = c.op.Platform
+		return c
+	}
+
+	root := clone(s.op)
+	for _, c := range copies {
+		c.imageConfig = c.imageConfig.remap(copies)
+	}
+
+	state := s.WithImageConfig(s.imageConfig.remap(copies))
+	state.op = root
+	return state
+}
+
+// remap returns config with the steps made after a node in copies moved to
+// its copy.
+func (c *ImageConfig) remap(copies map[*OpNode]*OpNode) *ImageConfig {
+	if c == nil || len(c.Steps) == 0 {
+		return c
+	}
+	remapped := &ImageConfig{Config: c.Config, Steps: make([]ConfigStep, len(c.Steps))}
+	for i, step := range c.Steps {
+		if after, ok := copies[step.After]; ok {
+			step.After = after
+		}
+		remapped.Steps[i] = step
+	}
+	return remapped
+}
+
 // SetImageConfig sets the image config.
 func (n *OpNode) SetImageConfig(config *ImageConfig) {
 	n.imageConfig = config
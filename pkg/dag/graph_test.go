@@ -118,3 +118,75 @@ func TestDigest(t *testing.T) {
 		t.Error("Expected digests to be equal")
 	}
 }
+
+func TestApplyPlatform(t *testing.T) {
+	native := &pb.Platform{OS: "linux", Architecture: "amd64"}
+	target := &pb.Platform{OS: "linux", Architecture: "arm64"}
+
+	toolchain := NewOpNode(&pb.Op{Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "docker-image://golang"}}}, "", 0)
+	toolchain.SetPlatform(native)
+	base := NewOpNode(&pb.Op{Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "docker-image://alpine"}}}, "", 0)
+
+	merge := NewOpNode(&pb.Op{
+		Inputs: []*pb.Input{{}, {}},
+		Op:     &pb.Op_Merge{Merge: &pb.MergeOp{}},
+	}, "", 0)
+	merge.AddInput(NewEdge(toolchain, 0))
+	merge.AddInput(NewEdge(base, 0))
+
+	before := base.DigestString()
+	applied := NewState(merge).ApplyPlatform(target)
+	copied := applied.Op()
+	copiedToolchain, copiedBase := copied.Inputs()[0].Node(), copied.Inputs()[1].Node()
+
+	if got := copiedToolchain.Op().Platform; got == nil || got.Architecture != "amd64" {
+		t.Errorf("expected explicit platform to be kept, got %v", got)
+	}
+	if got := copiedBase.Op().Platform; got == nil || got.Architecture != "arm64" {
+		t.Errorf("expected target platform on base, got %v", got)
+	}
+	if got := copied.Platform(); got == nil || got.Architecture != "arm64" {
+		t.Errorf("expected node platform to be set, got %v", got)
+	}
+	if copiedBase.DigestString() == before {
+		t.Error("expected digest to change after applying platform")
+	}
+	if base.Op().Platform != nil || merge.Op().Platform != nil || base.DigestString() != before {
+		t.Error("expected the original nodes to be left unchanged")
+	}
+}
+
+func TestApplyPlatformSharedBase(t *testing.T) {
+	base := NewOpNode(&pb.Op{Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "docker-image://alpine"}}}, "", 0)
+	run := NewOpNode(&pb.Op{
+		Inputs: []*pb.Input{{}},
+		Op:     &pb.Op_Exec{Exec: &pb.ExecOp{Meta: &pb.Meta{Args: []string{"true"}}}},
+	}, "", 0)
+	run.AddInput(NewEdge(base, 0))
+	state := NewState(run)
+
+	amd64 := state.ApplyPlatform(&pb.Platform{OS: "linux", Architecture: "amd64"})
+	arm64 := state.ApplyPlatform(&pb.Platform{OS: "linux", Architecture: "arm64"})
+
+	for arch, s := range map[string]*State{"amd64": amd64, "arm64": arm64} {
+		def, err := Serialize(s, nil)
+		if err != nil {
+			t.Fatalf("serialize %s: %v", arch, err)
+		}
+		for _, dt := range def.Def {
+			var op pb.Op
+			if err := op.UnmarshalVT(dt); err != nil {
+				t.Fatal(err)
+			}
+			if op.Op == nil {
+				continue
+			}
+			if op.Platform == nil || op.Platform.Architecture != arch {
+				t.Errorf("%s: expected every op on %s, got %v", arch, arch, op.Platform)
+			}
+		}
+	}
+	if amd64.Op().DigestString() == arm64.Op().DigestString() {
+		t.Error("expected the platforms to have different digests")
+	}
+}
@@ -0,0 +1,206 @@
+package dag
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/moby/buildkit/solver/pb"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// HistoryCommentKey is the op metadata description key holding the comment
+// of the op's image history entry, set with state:with_metadata.
+const HistoryCommentKey = "luakit.history.comment"
+
+// historyBuilder derives image history entries from the ops that built a
+// state, naming each by the Lua line that created it.
+type historyBuilder struct {
+	sources map[string][]byte
+	steps   map[*OpNode][]ConfigStep
+	emitted map[*OpNode]bool
+}
+
+// imageHistory returns the history of the image exported from state: the
+// history of the base image, one entry per op that adds a layer and one
+// empty-layer entry per config step. ok is false when the layers cannot be
+// accounted for, such as for an image whose config was not resolved; the
+// exporter then fills in the history itself.
+func imageHistory(state *State, sources map[string][]byte) (history []ocispec.History, ok bool) {
+	b := &historyBuilder{
+		sources: sources,
+		steps:   make(map[*OpNode][]ConfigStep),
+		emitted: make(map[*OpNode]bool),
+	}
+	var steps []ConfigStep
+	if cfg := state.ImageConfig(); cfg != nil {
+		steps = cfg.Steps
+	}
+	for _, step := range steps {
+		b.steps[step.After] = append(b.steps[step.After], step)
+	}
+
+	history, ok = b.layers(state.Op(), state.OutputIndex())
+	if !ok {
+		return nil, false
+	}
+
+	// Steps made on ops outside the exported chain, such as a config
+	// carried over from another state, go last.
+	for _, step := range steps {
+		if !b.emitted[step.After] {
+			history = append(history, b.configEntry(step))
+		}
+	}
+	return history, true
+}
+
+// layers returns the history of the given output of node followed by the
+// config steps made on it.
+func (b *historyBuilder) layers(node *OpNode, output int) ([]ocispec.History, bool) {
+	var history []ocispec.History
+
+	switch op := node.Op().Op.(type) {
+	case *pb.Op_Source:
+		switch id := op.Source.Identifier; {
+		case id == "scratch":
+		case strings.HasPrefix(id, "docker-image://"):
+			cfg := node.ImageConfig()
+			if cfg == nil || cfg.Config == nil {
+				return nil, false
+			}
+			history = append(history, cfg.Config.History...)
+		default:
+			history = append(history, b.opEntry(node))
+		}
+	case *pb.Op_Exec:
+		input := int64(pb.Empty)
+		found := false
+		for _, m := range op.Exec.Mounts {
+			if m.Output == int64(output) {
+				input, found = m.Input, true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+		base, ok := b.input(node, int(input))
+		if !ok {
+			return nil, false
+		}
+		history = append(base, b.opEntry(node))
+	case *pb.Op_File:
+		input, ok := fileActionBase(op.File, len(node.Inputs()), output)
+		if !ok {
+			return nil, false
+		}
+		base, ok := b.input(node, input)
+		if !ok {
+			return nil, false
+		}
+		history = append(base, b.opEntry(node))
+	case *pb.Op_Merge:
+		for _, edge := range node.Inputs() {
+			h, ok := b.layers(edge.Node(), edge.OutputIndex())
+			if !ok {
+				return nil, false
+			}
+			history = append(history, h...)
+		}
+	default:
+		return nil, false
+	}
+
+	if !b.emitted[node] {
+		b.emitted[node] = true
+		for _, step := range b.steps[node] {
+			history = append(history, b.configEntry(step))
+		}
+	}
+	return history, true
+}
+
+// input returns the history of input i of node, which is empty for a
+// mount or action without an input.
+func (b *historyBuilder) input(node *OpNode, i int) ([]ocispec.History, bool) {
+	if i < 0 {
+		return nil, true
+	}
+	if i >= len(node.Inputs()) {
+		return nil, false
+	}
+	edge := node.Inputs()[i]
+	return b.layers(edge.Node(), edge.OutputIndex())
+}
+
+// fileActionBase returns the op input the file action producing output
+// starts from, following actions that work on the result of an earlier
+// action, or -1 when it starts from scratch.
+func fileActionBase(file *pb.FileOp, inputs, output int) (int, bool) {
+	var action *pb.FileAction
+	for _, a := range file.Actions {
+		if a.Output == int64(output) {
+			action = a
+		}
+	}
+	for action != nil {
+		input := int(action.Input)
+		if input < inputs {
+			return input, true
+		}
+		i := input - inputs
+		if i >= len(file.Actions) {
+			return 0, false
+		}
+		action = file.Actions[i]
+	}
+	return 0, false
+}
+
+func (b *historyBuilder) opEntry(node *OpNode) ocispec.History {
+	h := ocispec.History{CreatedBy: b.createdBy(node.LuaFile(), node.LuaLine())}
+	if h.CreatedBy == "" {
+		h.CreatedBy = describeOp(node.Op())
+	}
+	if meta := node.Metadata(); meta != nil {
+		h.Comment = meta.Description[HistoryCommentKey]
+	}
+	return h
+}
+
+func (b *historyBuilder) configEntry(step ConfigStep) ocispec.History {
+	return ocispec.History{
+		CreatedBy:  b.createdBy(step.LuaFile, step.LuaLine),
+		EmptyLayer: true,
+	}
+}
+
+// createdBy formats a call site as "build.lua:12 " followed by the source
+// line, or just the location when the source is not known.
+func (b *historyBuilder) createdBy(file string, line int) string {
+	if file == "" || line <= 0 {
+		return ""
+	}
+	loc := fmt.Sprintf("%s:%d", file, line)
+	lines := bytes.Split(b.sources[file], []byte("\n"))
+	if line > len(lines) {
+		return loc
+	}
+	if src := strings.TrimSpace(string(lines[line-1])); src != "" {
+		return loc + " " + src
+	}
+	return loc
+}
+
+// describeOp names an op without a Lua call site.
+func describeOp(op *pb.Op) string {
+	switch op := op.Op.(type) {
+	case *pb.Op_Source:
+		return op.Source.Identifier
+	case *pb.Op_Exec:
+		return strings.Join(op.Exec.Meta.GetArgs(), " ")
+	case *pb.Op_File:
+		return "file"
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package dag
+
+import (
+	"testing"
+
+	pb "github.com/moby/buildkit/solver/pb"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func resolvedImageState(history ...ocispec.History) *State {
+	state := imageState("alpine:3.19")
+	state.Op().SetImageConfig(&ImageConfig{Config: &ocispec.Image{History: history}})
+	return state
+}
+
+func copyState(dest *State, line int) *State {
+	node := NewOpNode(&pb.Op{
+		Inputs: []*pb.Input{{}},
+		Op: &pb.Op_File{File: &pb.FileOp{Actions: []*pb.FileAction{
+			{Input: 0, SecondaryInput: -1, Output: 0, Action: &pb.FileAction_Mkdir{Mkdir: &pb.FileActionMkDir{Path: "/app"}}},
+		}}},
+	}, "build.lua", line)
+	node.AddInput(NewEdge(dest.Op(), dest.OutputIndex()))
+	return NewState(node)
+}
+
+func TestImageHistory(t *testing.T) {
+	sources := map[string][]byte{"build.lua": []byte("local base = bk.image(\"alpine\")\nlocal built = base:run(\"make\")\n")}
+	base := resolvedImageState(ocispec.History{CreatedBy: "ADD rootfs"})
+	state := execState(base, imageState("busybox"))
+
+	history, ok := imageHistory(state, sources)
+	require.True(t, ok)
+	require.Equal(t, []ocispec.History{
+		{CreatedBy: "ADD rootfs"},
+		{CreatedBy: `build.lua:2 local built = base:run("make")`},
+	}, history)
+}
+
+func TestImageHistoryConfigSteps(t *testing.T) {
+	base := resolvedImageState(ocispec.History{CreatedBy: "ADD rootfs"})
+	state := copyState(base, 3)
+	state = state.WithImageConfig(&ImageConfig{
+		Config: &ocispec.Image{},
+		Steps: []ConfigStep{
+			{After: base.Op(), LuaFile: "build.lua", LuaLine: 2},
+			{After: state.Op(), LuaFile: "build.lua", LuaLine: 4},
+		},
+	})
+
+	history, ok := imageHistory(state, nil)
+	require.True(t, ok)
+	require.Equal(t, []ocispec.History{
+		{CreatedBy: "ADD rootfs"},
+		{CreatedBy: "build.lua:2", EmptyLayer: true},
+		{CreatedBy: "build.lua:3"},
+		{CreatedBy: "build.lua:4", EmptyLayer: true},
+	}, history)
+}
+
+func TestImageHistoryMerge(t *testing.T) {
+	base := resolvedImageState(ocispec.History{CreatedBy: "ADD rootfs"})
+	node := NewOpNode(&pb.Op{
+		Inputs: []*pb.Input{{}, {}},
+		Op:     &pb.Op_Merge{Merge: &pb.MergeOp{Inputs: []*pb.MergeInput{{Input: 0}, {Input: 1}}}},
+	}, "build.lua", 5)
+	node.AddInput(NewEdge(base.Op(), 0))
+	app := copyState(base, 4)
+	node.AddInput(NewEdge(app.Op(), 0))
+
+	history, ok := imageHistory(NewState(node), nil)
+	require.True(t, ok)
+	require.Equal(t, []ocispec.History{
+		{CreatedBy: "ADD rootfs"},
+		{CreatedBy: "ADD rootfs"},
+		{CreatedBy: "build.lua:4"},
+	}, history)
+}
+
+func TestImageHistoryUnknownLayers(t *testing.T) {
+	_, ok := imageHistory(execState(imageState("alpine"), imageState("busybox")), nil)
+	require.False(t, ok, "an unresolved base image has an unknown number of layers")
+}
@@ -0,0 +1,216 @@
+package dag
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/moby/buildkit/solver/pb"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/kasuboski/luakit/pkg/resolver"
+)
+
+// exportImageConfig layers opts.ImageConfig, the config set with bk.export,
+// over the config of the base image state was built on. With inheritance off,
+// or when there is no resolved base image, the config is used on its own. OS
+// and architecture not set in the config come from the platform of state.
+// The history is derived from the ops that built state, quoting the Lua
+// lines in opts.SourceFiles that created them.
+func exportImageConfig(state *State, opts *SerializeOptions) *dockerspec.DockerOCIImage {
+	config := opts.ImageConfig
+	var base *ocispec.Image
+	if opts.InheritImageConfig {
+		base = baseImageConfig(state.Op(), state.OutputIndex())
+	}
+
+	var img *dockerspec.DockerOCIImage
+	switch {
+	case base != nil:
+		img = cloneImage(base)
+		// As in a Dockerfile, the base image's build time does not carry
+		// over to the new image.
+		img.Created = nil
+		if config != nil {
+			mergeImageConfig(img, config)
+		}
+	case config != nil:
+		img = cloneImage(config)
+	default:
+		img = &dockerspec.DockerOCIImage{}
+		img.Config.Env = []string{}
+	}
+
+	if history, ok := imageHistory(state, opts.SourceFiles); ok {
+		img.History = history
+	}
+	if img.Created == nil && opts.SourceDateEpoch != nil {
+		created := *opts.SourceDateEpoch
+		img.Created = &created
+	}
+
+	if img.OS == "" || img.Architecture == "" {
+		p := exportPlatform(state, base)
+		if img.OS == "" {
+			img.OS = p.OS
+		}
+		if img.Architecture == "" {
+			img.Architecture = p.Architecture
+			img.Variant = p.Variant
+		}
+	}
+	return img
+}
+
+// mergeImageConfig applies the fields set in config to img. Env, labels,
+// exposed ports and volumes are added to those of img; everything else
+// replaces it. A new entrypoint drops the inherited cmd, as in a Dockerfile.
+func mergeImageConfig(img, config *dockerspec.DockerOCIImage) {
+	c := config.Config
+	for _, kv := range c.Env {
+		img.Config.Env = SetEnv(img.Config.Env, kv)
+	}
+	if len(c.Labels) > 0 {
+		if img.Config.Labels == nil {
+			img.Config.Labels = make(map[string]string, len(c.Labels))
+		}
+		maps.Copy(img.Config.Labels, c.Labels)
+	}
+	if len(c.ExposedPorts) > 0 {
+		if img.Config.ExposedPorts == nil {
+			img.Config.ExposedPorts = make(map[string]struct{}, len(c.ExposedPorts))
+		}
+		maps.Copy(img.Config.ExposedPorts, c.ExposedPorts)
+	}
+	if len(c.Volumes) > 0 {
+		if img.Config.Volumes == nil {
+			img.Config.Volumes = make(map[string]struct{}, len(c.Volumes))
+		}
+		maps.Copy(img.Config.Volumes, c.Volumes)
+	}
+	if c.Entrypoint != nil {
+		img.Config.Entrypoint = slices.Clone(c.Entrypoint)
+		img.Config.Cmd = nil
+	}
+	if c.Cmd != nil {
+		img.Config.Cmd = slices.Clone(c.Cmd)
+	}
+	if c.WorkingDir != "" {
+		img.Config.WorkingDir = c.WorkingDir
+	}
+	if c.User != "" {
+		img.Config.User = c.User
+	}
+	if c.StopSignal != "" {
+		img.Config.StopSignal = c.StopSignal
+	}
+	if c.Shell != nil {
+		img.Config.Shell = slices.Clone(c.Shell)
+	}
+	if c.OnBuild != nil {
+		img.Config.OnBuild = slices.Clone(c.OnBuild)
+	}
+	if c.Healthcheck != nil {
+		hc := *c.Healthcheck
+		hc.Test = slices.Clone(hc.Test)
+		img.Config.Healthcheck = &hc
+	}
+	if config.Created != nil {
+		created := *config.Created
+		img.Created = &created
+	}
+	if config.OS != "" {
+		img.OS = config.OS
+	}
+	if config.Architecture != "" {
+		img.Architecture = config.Architecture
+		img.Variant = config.Variant
+	}
+	if config.OSVersion != "" {
+		img.OSVersion = config.OSVersion
+	}
+	if config.OSFeatures != nil {
+		img.OSFeatures = slices.Clone(config.OSFeatures)
+	}
+}
+
+// SetEnv returns a copy of env with the KEY=value entry for the key of kv
+// replaced, or kv appended, keeping the order of env stable. env is not
+// modified.
+func SetEnv(env []string, kv string) []string {
+	key, _, _ := strings.Cut(kv, "=")
+	for i, e := range env {
+		if k, _, _ := strings.Cut(e, "="); k == key {
+			env = slices.Clone(env)
+			env[i] = kv
+			return env
+		}
+	}
+	return append(slices.Clone(env), kv)
+}
+
+// baseImageConfig follows the root filesystem of the given output of node
+// back to an image source and returns its resolved config. It returns nil
+// when the filesystem does not start from a resolved image, such as scratch
+// or the output of a writable exec mount.
+func baseImageConfig(node *OpNode, output int) *ocispec.Image {
+	for node != nil {
+		if config := node.ImageConfig(); config != nil && node.Op().GetSource() != nil {
+			return config.Config
+		}
+
+		input := -1
+		switch op := node.Op().Op.(type) {
+		case *pb.Op_Exec:
+			for _, m := range op.Exec.Mounts {
+				if m.Dest == "/" && m.Output == int64(output) {
+					input = int(m.Input)
+				}
+			}
+		case *pb.Op_File:
+			if len(op.File.Actions) > 0 {
+				input = int(op.File.Actions[0].Input)
+			}
+		case *pb.Op_Merge:
+			input = 0
+		}
+		if input < 0 || input >= len(node.Inputs()) {
+			return nil
+		}
+		edge := node.Inputs()[input]
+		node, output = edge.Node(), edge.OutputIndex()
+	}
+	return nil
+}
+
+// exportPlatform returns the platform of the exported image: the platform of
+// state, else that of the base image, else the default platform.
+func exportPlatform(state *State, base *ocispec.Image) ocispec.Platform {
+	p := state.Platform()
+	if p == nil {
+		p = state.Op().Op().GetPlatform()
+	}
+	if p != nil && p.OS != "" && p.Architecture != "" {
+		return ocispec.Platform{OS: p.OS, Architecture: p.Architecture, Variant: p.Variant}
+	}
+	if base != nil && base.OS != "" && base.Architecture != "" {
+		return base.Platform
+	}
+	return resolver.DefaultPlatform()
+}
+
+// cloneImage deep copies an image config. Resolved configs may be shared
+// through the resolver cache and must not be changed in place.
+func cloneImage(img any) *dockerspec.DockerOCIImage {
+	clone := &dockerspec.DockerOCIImage{}
+	dt, err := json.Marshal(img)
+	if err != nil {
+		return clone
+	}
+	if err := json.Unmarshal(dt, clone); err != nil {
+		return &dockerspec.DockerOCIImage{}
+	}
+	return clone
+}
@@ -0,0 +1,192 @@
+package dag
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/moby/buildkit/solver/pb"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kasuboski/luakit/pkg/resolver"
+)
+
+type fakeResolver struct {
+	config *ocispec.Image
+}
+
+func (r *fakeResolver) Resolve(ctx context.Context, ref string, platform ocispec.Platform) (*resolver.ImageConfig, error) {
+	return &resolver.ImageConfig{Ref: ref, Config: r.config, Platform: platform}, nil
+}
+
+func nginxConfig() *ocispec.Image {
+	img := &ocispec.Image{}
+	img.OS = "linux"
+	img.Architecture = "arm64"
+	img.Config.Env = []string{"PATH=/usr/local/sbin:/usr/bin", "NGINX_VERSION=1.27"}
+	img.Config.Entrypoint = []string{"/docker-entrypoint.sh"}
+	img.Config.Cmd = []string{"nginx", "-g", "daemon off;"}
+	img.Config.ExposedPorts = map[string]struct{}{"80/tcp": {}}
+	img.Config.StopSignal = "SIGQUIT"
+	return img
+}
+
+func imageState(ref string) *State {
+	node := NewOpNode(&pb.Op{
+		Op: &pb.Op_Source{Source: &pb.SourceOp{Identifier: "docker-image://" + ref}},
+	}, "build.lua", 1)
+	node.SetResolveConfig(true)
+	return NewState(node)
+}
+
+// execState runs a command on base with a writable /out mount, as ops.Run
+// does: the rootfs is output 0 and /out output 1.
+func execState(base, out *State) *State {
+	node := NewOpNode(&pb.Op{
+		Inputs: []*pb.Input{{}, {}},
+		Op: &pb.Op_Exec{Exec: &pb.ExecOp{
+			Meta: &pb.Meta{Args: []string{"make"}},
+			Mounts: []*pb.Mount{
+				{Dest: "/", Input: 0, Output: 0},
+				{Dest: "/out", Input: 1, Output: 1},
+			},
+		}},
+	}, "build.lua", 2)
+	node.AddInput(NewEdge(base.Op(), base.OutputIndex()))
+	node.AddInput(NewEdge(out.Op(), out.OutputIndex()))
+	return NewState(node)
+}
+
+func TestSerializeInheritsBaseImageConfig(t *testing.T) {
+	reslv := &fakeResolver{config: nginxConfig()}
+	state := execState(imageState("nginx:1.27"), imageState("busybox"))
+
+	exported := &dockerspec.DockerOCIImage{}
+	exported.Config.Env = []string{"NGINX_VERSION=1.28", "APP=web"}
+	exported.Config.ExposedPorts = map[string]struct{}{"8080/tcp": {}}
+	exported.Config.Labels = map[string]string{"app": "web"}
+
+	opts := &SerializeOptions{ImageConfig: exported, Resolver: reslv, InheritImageConfig: true}
+	_, config, err := SerializeImage(state, opts)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"PATH=/usr/local/sbin:/usr/bin", "NGINX_VERSION=1.28", "APP=web"}, config.Config.Env)
+	require.Equal(t, []string{"/docker-entrypoint.sh"}, config.Config.Entrypoint)
+	require.Equal(t, []string{"nginx", "-g", "daemon off;"}, config.Config.Cmd)
+	require.Equal(t, map[string]struct{}{"80/tcp": {}, "8080/tcp": {}}, config.Config.ExposedPorts)
+	require.Equal(t, "SIGQUIT", config.Config.StopSignal)
+	require.Equal(t, "web", config.Config.Labels["app"])
+	require.Equal(t, "linux", config.OS)
+	require.Equal(t, "arm64", config.Architecture)
+
+	require.Equal(t, []string{"PATH=/usr/local/sbin:/usr/bin", "NGINX_VERSION=1.27"}, reslv.config.Config.Env,
+		"the resolved config must not be modified")
+}
+
+func TestSerializeEntrypointResetsInheritedCmd(t *testing.T) {
+	state := imageState("nginx:1.27")
+
+	exported := &dockerspec.DockerOCIImage{}
+	exported.Config.Entrypoint = []string{"/app"}
+
+	opts := &SerializeOptions{ImageConfig: exported, Resolver: &fakeResolver{config: nginxConfig()}, InheritImageConfig: true}
+	_, config, err := SerializeImage(state, opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/app"}, config.Config.Entrypoint)
+	require.Nil(t, config.Config.Cmd)
+}
+
+func TestSerializeWithoutInheritance(t *testing.T) {
+	base := imageState("nginx:1.27")
+
+	tests := []struct {
+		name    string
+		state   *State
+		inherit bool
+	}{
+		{name: "inherit false", state: base, inherit: false},
+		{name: "mount output", state: NewStateWithOutput(execState(base, imageState("busybox")).Op(), 1), inherit: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exported := &dockerspec.DockerOCIImage{}
+			exported.Config.Cmd = []string{"/app"}
+
+			opts := &SerializeOptions{
+				ImageConfig:        exported,
+				Resolver:           &fakeResolver{config: nginxConfig()},
+				InheritImageConfig: tt.inherit,
+			}
+			_, config, err := SerializeImage(tt.state.WithPlatform(&pb.Platform{OS: "linux", Architecture: "riscv64"}), opts)
+			require.NoError(t, err)
+
+			require.Nil(t, config.Config.Entrypoint)
+			require.Empty(t, config.Config.Env)
+			require.Equal(t, []string{"/app"}, config.Config.Cmd)
+			require.Equal(t, "riscv64", config.Architecture, "platform comes from the state")
+		})
+	}
+}
+
+func TestSerializeImageKeepsOptions(t *testing.T) {
+	exported := &dockerspec.DockerOCIImage{}
+	exported.Config.Cmd = []string{"/app"}
+	opts := &SerializeOptions{ImageConfig: exported, Resolver: &fakeResolver{config: nginxConfig()}, InheritImageConfig: true}
+
+	_, first, err := SerializeImage(imageState("nginx:1.27"), opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/docker-entrypoint.sh"}, first.Config.Entrypoint)
+	require.Same(t, exported, opts.ImageConfig, "the options must not be modified")
+	require.Nil(t, exported.Config.Entrypoint)
+
+	mount := NewStateWithOutput(execState(imageState("nginx:1.27"), imageState("busybox")).Op(), 1)
+	_, second, err := SerializeImage(mount, opts)
+	require.NoError(t, err)
+	require.Nil(t, second.Config.Entrypoint, "a second state must not inherit the first one's config")
+	require.Equal(t, []string{"/app"}, second.Config.Cmd)
+}
+
+func TestSerializeInheritsWithoutExportConfig(t *testing.T) {
+	opts := &SerializeOptions{Resolver: &fakeResolver{config: nginxConfig()}, InheritImageConfig: true}
+	_, config, err := SerializeImage(imageState("nginx:1.27"), opts)
+	require.NoError(t, err)
+	require.NotNil(t, config)
+	require.Equal(t, []string{"/docker-entrypoint.sh"}, config.Config.Entrypoint)
+}
+
+func TestSerializeMergesRuntimeConfig(t *testing.T) {
+	base := nginxConfig()
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	base.Created = &created
+	base.Config.Volumes = map[string]struct{}{"/var/cache/nginx": {}}
+
+	exported := &dockerspec.DockerOCIImage{}
+	exported.Config.Volumes = map[string]struct{}{"/data": {}}
+	exported.Config.Shell = []string{"/bin/bash", "-c"}
+	exported.Config.Healthcheck = &dockerspec.HealthcheckConfig{Test: []string{"CMD", "true"}, Retries: 3}
+	exported.OSFeatures = []string{"win32k"}
+
+	opts := &SerializeOptions{ImageConfig: exported, Resolver: &fakeResolver{config: base}, InheritImageConfig: true}
+	_, config, err := SerializeImage(imageState("nginx:1.27"), opts)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]struct{}{"/var/cache/nginx": {}, "/data": {}}, config.Config.Volumes)
+	require.Equal(t, []string{"/bin/bash", "-c"}, config.Config.Shell)
+	require.Equal(t, exported.Config.Healthcheck, config.Config.Healthcheck)
+	require.Equal(t, "SIGQUIT", config.Config.StopSignal)
+	require.Equal(t, []string{"win32k"}, config.OSFeatures)
+	require.Nil(t, config.Created, "the base image's build time is not inherited")
+}
+
+func TestSetEnv(t *testing.T) {
+	env := []string{"A=1", "B=2"}
+	replaced := SetEnv(env, "B=3")
+	appended := SetEnv(replaced, "C=4")
+
+	require.Equal(t, []string{"A=1", "B=3", "C=4"}, appended)
+	require.Equal(t, []string{"A=1", "B=2"}, env, "env must not be modified")
+	require.Equal(t, []string{"A=1", "B=3"}, replaced)
+}
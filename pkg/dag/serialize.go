@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/kasuboski/luakit/pkg/resolver"
 	"github.com/moby/buildkit/exporter/containerimage/exptypes"
@@ -16,6 +17,14 @@ type SerializeOptions struct {
 	ImageConfig *dockerspec.DockerOCIImage
 	SourceFiles map[string][]byte
 	Resolver    resolver.Interface
+	// InheritImageConfig layers ImageConfig over the resolved config of the
+	// base image the state was built on. SerializeImage returns the result,
+	// which is what the exporter should use.
+	InheritImageConfig bool
+	// SourceDateEpoch, if set, is used as the timestamp of created files,
+	// passed to runs as SOURCE_DATE_EPOCH and used as the image creation
+	// time unless ImageConfig sets one.
+	SourceDateEpoch *time.Time
 }
 
 // resolveImageConfigs walks the DAG and resolves image configs for SourceOps.
@@ -79,6 +88,15 @@ func resolveImageConfigs(ctx context.Context, state *State, reslv resolver.Inter
 
 // Serialize converts the DAG starting from the given state to a pb.Definition.
 func Serialize(state *State, opts *SerializeOptions) (*pb.Definition, error) {
+	def, _, err := SerializeImage(state, opts)
+	return def, err
+}
+
+// SerializeImage is Serialize that also returns the image config to export:
+// opts.ImageConfig, layered over the base image's config with
+// opts.InheritImageConfig. It is nil when there is neither. opts is not
+// modified, so it can be reused for another state.
+func SerializeImage(state *State, opts *SerializeOptions) (*pb.Definition, *dockerspec.DockerOCIImage, error) {
 	visited := make(map[string]bool, 128)
 	smb := NewSourceMapBuilder()
 
@@ -98,7 +116,7 @@ func Serialize(state *State, opts *SerializeOptions) (*pb.Definition, error) {
 	if opts != nil && opts.Resolver != nil {
 		ctx := context.Background()
 		if err := resolveImageConfigs(ctx, state, opts.Resolver); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
 
@@ -107,14 +125,23 @@ func Serialize(state *State, opts *SerializeOptions) (*pb.Definition, error) {
 	// or default cwd to "/" if no config available.
 	propagateImageConfigs(state)
 
+	if opts != nil && opts.SourceDateEpoch != nil {
+		applySourceDateEpoch(state, *opts.SourceDateEpoch)
+	}
+
+	var imageConfig *dockerspec.DockerOCIImage
+	if opts != nil && (opts.ImageConfig != nil || opts.InheritImageConfig) {
+		imageConfig = exportImageConfig(state, opts)
+	}
+
 	if err := walk(state.Op(), visited, def, smb); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if opts != nil && opts.ImageConfig != nil {
-		configBytes, err := json.Marshal(opts.ImageConfig)
+	if imageConfig != nil {
+		configBytes, err := json.Marshal(imageConfig)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		digest := state.Op().DigestString()
@@ -146,11 +173,11 @@ func Serialize(state *State, opts *SerializeOptions) (*pb.Definition, error) {
 	}
 	finalOpBytes, err := finalOp.MarshalVT()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	def.Def = append(def.Def, finalOpBytes)
 
-	return def, nil
+	return def, imageConfig, nil
 }
 
 // walk recursively visits all OpNodes in the DAG and serializes them.
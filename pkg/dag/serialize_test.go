@@ -1,6 +1,7 @@
 package dag
 
 import (
+	"sync"
 	"testing"
 
 	pb "github.com/moby/buildkit/solver/pb"
@@ -871,3 +872,38 @@ func TestSerializeWithBothMetadata(t *testing.T) {
 		t.Errorf("Expected progress group id 'build', got '%s'", metadata.ProgressGroup.Id)
 	}
 }
+
+// TestSerializeConcurrently serializes separate graphs that share ops by
+// content while the digest caches are cleared. Run with -race.
+func TestSerializeConcurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	defs := make([]*pb.Definition, 16)
+	for i := range defs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%4 == 0 {
+				ClearDigestCache()
+			}
+			state := execState(imageState("alpine:3.20"), imageState("busybox"))
+			def, err := Serialize(state, &SerializeOptions{SourceFiles: map[string][]byte{"build.lua": []byte("-- build")}})
+			if err != nil {
+				t.Errorf("Serialize failed: %v", err)
+				return
+			}
+			defs[i] = def
+		}()
+	}
+	wg.Wait()
+
+	for i, def := range defs[1:] {
+		if def == nil || len(def.Def) != len(defs[0].Def) {
+			t.Fatalf("definition %d differs in size", i+1)
+		}
+		for j := range def.Def {
+			if string(def.Def[j]) != string(defs[0].Def[j]) {
+				t.Errorf("definition %d differs at op %d", i+1, j)
+			}
+		}
+	}
+}
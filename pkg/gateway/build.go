@@ -2,19 +2,56 @@ package gateway
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io/fs"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kasuboski/luakit/lua/stdlib"
 	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/lockfile"
 	"github.com/kasuboski/luakit/pkg/luavm"
 	"github.com/kasuboski/luakit/pkg/resolver"
 	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
 	gwclient "github.com/moby/buildkit/frontend/gateway/client"
 )
 
 const (
 	defaultEntrypoint = "build.lua"
+
+	// keyTarget is the frontend opt used by `docker buildx build --target`.
+	keyTarget = "target"
+	// keyPlatform is the frontend opt used by `docker buildx build --platform`.
+	keyPlatform = "platform"
+	// keyFrozen requires every source to be pinned by luakit.lock.
+	keyFrozen = "frozen"
+	// keyStrict turns the deterministic sandbox off when set to "false".
+	keyStrict = "strict"
+	// buildArgPrefix marks build arguments, as sent by
+	// `docker buildx build --build-arg`.
+	buildArgPrefix = "build-arg:"
+	// keySourceDateEpoch is the SOURCE_DATE_EPOCH build argument, which
+	// buildx also sets from the environment variable of the same name.
+	keySourceDateEpoch = buildArgPrefix + "SOURCE_DATE_EPOCH"
+	// contextPrefix marks named contexts for bk.context, as sent by
+	// `docker buildx build --build-context`.
+	contextPrefix = "context:"
+	// keyTimeout overrides defaultTimeout, as a duration such as "30s".
+	// "0" removes the limit.
+	keyTimeout = "timeout"
+	// keyMaxNodes overrides defaultMaxNodes. "0" removes the limit.
+	keyMaxNodes = "max-nodes"
+)
+
+// Limits on the scripts the frontend evaluates, so a runaway script cannot
+// hold the frontend for as long as the client keeps the request open.
+const (
+	defaultTimeout  = 5 * time.Minute
+	defaultMaxNodes = 100000
 )
 
 type BuildOpts struct {
@@ -37,7 +74,12 @@ func Build(ctx context.Context, c gwclient.Client, opts ...BuildOpt) (*gwclient.
 		opt(options)
 	}
 
-	luaSource, err := readLuaFile(ctx, c, options.Entrypoint)
+	contextRef, err := solveContext(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	luaSource, err := readLuaFile(ctx, contextRef, options.Entrypoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read %s: %w", options.Entrypoint, err)
 	}
@@ -46,7 +88,19 @@ func Build(ctx context.Context, c gwclient.Client, opts ...BuildOpt) (*gwclient.
 		return nil, fmt.Errorf("no lua source code provided")
 	}
 
-	result, err := evaluateLua(luaSource, c.BuildOpts().Opts)
+	frontendOpts := c.BuildOpts().Opts
+
+	modules, err := fs.Sub(newReferenceFS(ctx, contextRef), path.Dir(options.Entrypoint))
+	if err != nil {
+		return nil, fmt.Errorf("invalid entrypoint %s: %w", options.Entrypoint, err)
+	}
+
+	inputs, err := inputStates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := evaluateLua(ctx, luaSource, frontendOpts, modules, inputs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to evaluate lua script: %w", err)
 	}
@@ -55,13 +109,34 @@ func Build(ctx context.Context, c gwclient.Client, opts ...BuildOpt) (*gwclient.
 		return nil, fmt.Errorf("no bk.export() call — nothing to build")
 	}
 
+	frozen := frontendOpts[keyFrozen] == "true"
+	lock, err := readLockfile(ctx, contextRef, options.Entrypoint)
+	if err != nil {
+		return nil, err
+	}
+	if lock == nil && frozen {
+		return nil, fmt.Errorf("frozen: %s not found in build context", lockfile.FileName)
+	}
+	if lock != nil {
+		if err := lock.Apply(frozen, result.States()...); err != nil {
+			return nil, err
+		}
+	}
+
 	gwResolver := resolver.NewGatewayResolver(c)
 
-	def, err := dag.Serialize(result.State, &dag.SerializeOptions{
-		ImageConfig: result.ImageConfig,
-		SourceFiles: result.SourceFiles,
-		Resolver:    gwResolver,
-	})
+	if len(result.Platforms) > 0 {
+		return buildPlatforms(ctx, c, result, gwResolver)
+	}
+
+	serializeOpts := &dag.SerializeOptions{
+		ImageConfig:        result.ImageConfig,
+		SourceFiles:        result.SourceFiles,
+		Resolver:           gwResolver,
+		InheritImageConfig: result.InheritImageConfig,
+		SourceDateEpoch:    result.SourceDateEpoch,
+	}
+	def, imageConfig, err := dag.SerializeImage(result.State, serializeOpts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize definition: %w", err)
 	}
@@ -77,10 +152,23 @@ func Build(ctx context.Context, c gwclient.Client, opts ...BuildOpt) (*gwclient.
 		return nil, fmt.Errorf("failed to solve definition: %w", err)
 	}
 
+	if imageConfig != nil {
+		config, err := json.Marshal(imageConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal image config: %w", err)
+		}
+		res.AddMeta(exptypes.ExporterImageConfigKey, config)
+	}
+	for k, v := range result.ExportMetadata() {
+		res.AddMeta(k, v)
+	}
+
 	return res, nil
 }
 
-func readLuaFile(ctx context.Context, c gwclient.Client, filename string) ([]byte, error) {
+// solveContext solves the build context and returns a reference to read
+// files from.
+func solveContext(ctx context.Context, c gwclient.Client) (gwclient.Reference, error) {
 	inputs, err := c.Inputs(ctx)
 	if err != nil || len(inputs) == 0 {
 		inputs = map[string]llb.State{
@@ -110,6 +198,45 @@ func readLuaFile(ctx context.Context, c gwclient.Client, filename string) ([]byt
 		return nil, fmt.Errorf("failed to get reference from result: %w", err)
 	}
 
+	return ref, nil
+}
+
+// inputStates returns the frontend inputs, such as contexts a bake target
+// passes on, as states for bk.context.
+func inputStates(ctx context.Context, c gwclient.Client) (map[string]*dag.State, error) {
+	inputs, err := c.Inputs(ctx)
+	if err != nil || len(inputs) == 0 {
+		return nil, nil
+	}
+
+	states := make(map[string]*dag.State, len(inputs))
+	for name, input := range inputs {
+		def, err := input.Marshal(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal input %s: %w", name, err)
+		}
+		state, err := dag.FromDefinition(def.ToPB())
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", name, err)
+		}
+		states[name] = state
+	}
+	return states, nil
+}
+
+// namedContexts returns the sources of the named contexts given as
+// context:NAME frontend opts.
+func namedContexts(frontendOpts map[string]string) map[string]string {
+	contexts := make(map[string]string)
+	for k, v := range frontendOpts {
+		if name, ok := strings.CutPrefix(k, contextPrefix); ok {
+			contexts[name] = v
+		}
+	}
+	return contexts
+}
+
+func readLuaFile(ctx context.Context, ref gwclient.Reference, filename string) ([]byte, error) {
 	data, err := ref.ReadFile(ctx, gwclient.ReadRequest{
 		Filename: filename,
 	})
@@ -120,6 +247,26 @@ func readLuaFile(ctx context.Context, c gwclient.Client, filename string) ([]byt
 	return data, nil
 }
 
+// readLockfile reads luakit.lock next to the entrypoint. A lockfile that is
+// not in the context yields nil; other read errors are returned.
+func readLockfile(ctx context.Context, ref gwclient.Reference, entrypoint string) (*lockfile.Lockfile, error) {
+	filename := path.Join(path.Dir(entrypoint), lockfile.FileName)
+	data, err := ref.ReadFile(ctx, gwclient.ReadRequest{
+		Filename: filename,
+	})
+	if isNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	lock, err := lockfile.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return lock, nil
+}
+
 func stripSyntaxDirective(source []byte) []byte {
 	lines := strings.Split(string(source), "\n")
 	for len(lines) > 0 {
@@ -137,14 +284,77 @@ func stripSyntaxDirective(source []byte) []byte {
 	return []byte(strings.Join(lines, "\n"))
 }
 
-func evaluateLua(source []byte, frontendOpts map[string]string) (*luavm.EvalResult, error) {
+// buildArgs returns the values for bk.arg, given as
+// `--opt build-arg:NAME=value`. Other opts control the frontend and are not
+// build arguments.
+func buildArgs(frontendOpts map[string]string) map[string]string {
+	args := make(map[string]string)
 	for k, v := range frontendOpts {
-		_ = os.Setenv(k, v)
+		if name, ok := strings.CutPrefix(k, buildArgPrefix); ok {
+			args[name] = v
+		}
 	}
+	return args
+}
 
+// evalLimits returns the evaluation timeout and DAG node cap: the defaults,
+// or the values of the timeout and max-nodes opts.
+func evalLimits(frontendOpts map[string]string) (time.Duration, int, error) {
+	timeout, maxNodes := defaultTimeout, defaultMaxNodes
+	if v, ok := frontendOpts[keyTimeout]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("invalid %s %q, expected a duration such as 30s", keyTimeout, v)
+		}
+		timeout = d
+	}
+	if v, ok := frontendOpts[keyMaxNodes]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid %s %q, expected a number of nodes", keyMaxNodes, v)
+		}
+		maxNodes = n
+	}
+	return timeout, maxNodes, nil
+}
+
+// evaluateLua runs the script. Modules it requires are read from modules,
+// which may be nil, and from the bundled stdlib. inputs are the frontend
+// inputs bk.context may return. Evaluation stops when ctx, the request's
+// context, is done, or at the limits from evalLimits.
+func evaluateLua(ctx context.Context, source []byte, frontendOpts map[string]string, modules fs.FS, inputs map[string]*dag.State) (*luavm.EvalResult, error) {
 	source = stripSyntaxDirective(source)
 
-	result, err := luavm.Evaluate(strings.NewReader(string(source)), "build.lua", nil)
+	platforms, err := luavm.ParsePlatforms(frontendOpts[keyPlatform])
+	if err != nil {
+		return nil, err
+	}
+
+	epoch, err := luavm.ParseSourceDateEpoch(frontendOpts[keySourceDateEpoch])
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, maxNodes, err := evalLimits(frontendOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &luavm.VMConfig{
+		Target:          frontendOpts[keyTarget],
+		Platforms:       platforms,
+		Args:            buildArgs(frontendOpts),
+		ContextFS:       modules,
+		StdlibFS:        stdlib.FS,
+		SourceDateEpoch: epoch,
+		Contexts:        namedContexts(frontendOpts),
+		ContextStates:   inputs,
+		Strict:          frontendOpts[keyStrict] != "false",
+		Timeout:         timeout,
+		MaxNodes:        maxNodes,
+	}
+
+	result, err := luavm.EvaluateContext(ctx, strings.NewReader(string(source)), "build.lua", config)
 	if err != nil {
 		return nil, err
 	}
@@ -1,9 +1,25 @@
 package gateway
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	pb "github.com/moby/buildkit/solver/pb"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kasuboski/luakit/pkg/luavm"
 )
 
 func TestEvaluateLua(t *testing.T) {
@@ -34,7 +50,7 @@ local result = base:run("echo hello > /hello.txt")`,
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := evaluateLua([]byte(tt.source), nil)
+			_, err := evaluateLua(context.Background(), []byte(tt.source), nil, nil, nil)
 			if tt.wantErr {
 				require.Error(t, err)
 			} else {
@@ -54,7 +70,7 @@ bk.export(result, {
     workdir = "/app",
 })`
 
-	result, err := evaluateLua([]byte(source), nil)
+	result, err := evaluateLua(context.Background(), []byte(source), nil, nil, nil)
 	require.NoError(t, err)
 	require.NotNil(t, result.ImageConfig)
 	require.Equal(t, []string{"/bin/sh"}, result.ImageConfig.Config.Entrypoint)
@@ -63,6 +79,65 @@ bk.export(result, {
 	require.Equal(t, "/app", result.ImageConfig.Config.WorkingDir)
 }
 
+func TestEvaluateLuaTarget(t *testing.T) {
+	source := `local base = bk.image("alpine:3.19")
+bk.target("dev", function()
+    bk.export(base:run("echo dev"))
+end)
+bk.target("prod", function()
+    bk.export(base, { user = "nobody" })
+end)`
+
+	result, err := evaluateLua(context.Background(), []byte(source), map[string]string{"target": "dev"}, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "dev", result.Target)
+	require.NotNil(t, result.State.Op().Op().GetExec())
+
+	result, err = evaluateLua(context.Background(), []byte(source), nil, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "prod", result.Target)
+	require.Equal(t, "nobody", result.ImageConfig.Config.User)
+
+	_, err = evaluateLua(context.Background(), []byte(source), map[string]string{"target": "missing"}, nil, nil)
+	require.ErrorContains(t, err, `target "missing" not found`)
+}
+
+func TestEvaluateLuaBuildArgs(t *testing.T) {
+	source := `bk.export(bk.scratch(), { labels = {
+    version = bk.arg("VERSION", "0.0.1"),
+    mode = bk.arg("MODE", "dev"),
+    target = bk.arg("target", "none"),
+    platform = bk.arg("platform", "none"),
+} })`
+
+	result, err := evaluateLua(context.Background(), []byte(source), map[string]string{
+		"VERSION":           "1.0.0",
+		"build-arg:MODE":    "prod",
+		"build-arg:UNUSED":  "x",
+		"platform":          "linux/amd64",
+		"context:base":      "docker-image://alpine",
+		"filename":          "build.lua",
+		"build-arg:VERSION": "2.0.0",
+	}, nil, nil)
+	require.NoError(t, err)
+	labels := result.ImageConfig.Config.Labels
+	require.Equal(t, "2.0.0", labels["version"])
+	require.Equal(t, "prod", labels["mode"])
+	require.Equal(t, "none", labels["target"], "frontend opts are not build arguments")
+	require.Equal(t, "none", labels["platform"], "frontend opts are not build arguments")
+}
+
+func TestBuildArgs(t *testing.T) {
+	args := buildArgs(map[string]string{
+		"build-arg:VERSION": "1.0.0",
+		"target":            "release",
+		"strict":            "false",
+		"VERSION":           "0.0.1",
+		"context:base":      "docker-image://alpine",
+	})
+	require.Equal(t, map[string]string{"VERSION": "1.0.0"}, args)
+}
+
 func TestStripSyntaxDirective(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -98,3 +173,464 @@ func TestStripSyntaxDirective(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildMultiPlatform(t *testing.T) {
+	source := `bk.export(function(platform)
+    return bk.image("alpine:3.19"):run("uname -m")
+end, { user = "nobody" })`
+
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, map[string]string{
+		"platform": "linux/amd64,linux/arm64",
+	})
+
+	res, err := Build(context.Background(), c)
+	require.NoError(t, err)
+
+	require.Len(t, res.Refs, 2)
+	require.Contains(t, res.Refs, "linux/amd64")
+	require.Contains(t, res.Refs, "linux/arm64")
+
+	var expPlatforms exptypes.Platforms
+	require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterPlatformsKey], &expPlatforms))
+	require.Len(t, expPlatforms.Platforms, 2)
+	require.Equal(t, "linux/amd64", expPlatforms.Platforms[0].ID)
+	require.Equal(t, "arm64", expPlatforms.Platforms[1].Platform.Architecture)
+
+	var config dockerspec.DockerOCIImage
+	require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey+"/linux/arm64"], &config))
+	require.Equal(t, "arm64", config.Architecture)
+	require.Equal(t, "nobody", config.Config.User)
+
+	// One solve for the context plus one per platform.
+	require.Len(t, c.solved, 3)
+	for _, def := range c.solved[1:] {
+		for _, dt := range def.Def {
+			var op pb.Op
+			require.NoError(t, op.UnmarshalVT(dt))
+			if op.Op == nil {
+				continue
+			}
+			require.NotNil(t, op.Platform, "every op should carry the target platform")
+		}
+	}
+}
+
+func TestBuildRequiresModulesFromContext(t *testing.T) {
+	source := `
+local golang = require("lib.go")
+local prelude = require("prelude")
+bk.export(golang.build(prelude.from_alpine()))
+`
+	module := `
+local M = {}
+function M.build(base)
+	return base:run("go build ./...")
+end
+return M
+`
+
+	c := newFakeClient(map[string][]byte{
+		"build.lua":  []byte(source),
+		"lib/go.lua": []byte(module),
+	}, nil)
+
+	_, err := Build(context.Background(), c)
+	require.NoError(t, err)
+}
+
+func TestBuildRequiresModulesNextToEntrypoint(t *testing.T) {
+	c := newFakeClient(map[string][]byte{
+		"ci/build.lua":   []byte(`bk.export(require("helpers").base())`),
+		"ci/helpers.lua": []byte(`return { base = function() return bk.image("alpine:3.19") end }`),
+	}, nil)
+
+	_, err := Build(context.Background(), c, WithEntrypoint("ci/build.lua"))
+	require.NoError(t, err)
+}
+
+func TestReferenceFS(t *testing.T) {
+	ref := &fakeRef{files: map[string][]byte{
+		"build.lua":         []byte("bk.export(bk.scratch())"),
+		"lib/go.lua":        []byte("return {}"),
+		"lib/node/init.lua": []byte("return {}"),
+	}}
+
+	require.NoError(t, fstest.TestFS(newReferenceFS(context.Background(), ref), "build.lua", "lib/go.lua", "lib/node/init.lua"))
+}
+
+func TestBuildSinglePlatformExport(t *testing.T) {
+	source := `bk.export({ ["linux/amd64"] = bk.image("alpine:3.19"), ["linux/arm64"] = bk.image("alpine:3.19") })`
+
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, map[string]string{
+		"platform": "linux/arm64",
+	})
+
+	res, err := Build(context.Background(), c)
+	require.NoError(t, err)
+	require.NotNil(t, res.Ref)
+	require.Empty(t, res.Refs)
+
+	var config dockerspec.DockerOCIImage
+	require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey], &config))
+	require.Equal(t, "arm64", config.Architecture)
+}
+
+func sourceIdentifiers(t *testing.T, def *pb.Definition) []string {
+	t.Helper()
+	var ids []string
+	for _, dt := range def.Def {
+		var op pb.Op
+		require.NoError(t, op.UnmarshalVT(dt))
+		if src := op.GetSource(); src != nil {
+			ids = append(ids, src.Identifier)
+		}
+	}
+	return ids
+}
+
+func TestBuildAppliesLockfile(t *testing.T) {
+	source := `bk.export({ ["linux/amd64"] = bk.image("alpine:3.19"):run("true") })`
+	lock := `{
+  "version": 1,
+  "images": [
+    {
+      "ref": "docker.io/library/alpine:3.19",
+      "platform": "linux/amd64",
+      "digest": "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+    }
+  ]
+}`
+
+	c := newFakeClient(map[string][]byte{
+		"build.lua":   []byte(source),
+		"luakit.lock": []byte(lock),
+	}, map[string]string{
+		"platform": "linux/amd64",
+		"frozen":   "true",
+	})
+
+	_, err := Build(context.Background(), c)
+	require.NoError(t, err)
+	require.Len(t, c.solved, 2)
+	require.Contains(t, sourceIdentifiers(t, c.solved[1]),
+		"docker-image://docker.io/library/alpine:3.19@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+}
+
+func TestBuildFrozenWithoutLockfile(t *testing.T) {
+	source := `bk.export(bk.image("alpine:3.19"))`
+
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, map[string]string{
+		"frozen": "true",
+	})
+
+	_, err := Build(context.Background(), c)
+	require.ErrorContains(t, err, "luakit.lock not found")
+}
+
+func TestBuildFrozenMissingEntry(t *testing.T) {
+	source := `bk.export(bk.image("alpine:3.19"))`
+
+	c := newFakeClient(map[string][]byte{
+		"build.lua":   []byte(source),
+		"luakit.lock": []byte(`{"version": 1}`),
+	}, map[string]string{
+		"frozen": "true",
+	})
+
+	_, err := Build(context.Background(), c)
+	require.ErrorContains(t, err, "image docker.io/library/alpine:3.19")
+	require.ErrorContains(t, err, "is not in luakit.lock")
+}
+
+func TestBuildInheritsBaseImageConfig(t *testing.T) {
+	base := &ocispec.Image{}
+	base.Config.Env = []string{"PATH=/usr/bin", "NGINX_VERSION=1.27"}
+	base.Config.Entrypoint = []string{"/docker-entrypoint.sh"}
+	base.Config.ExposedPorts = map[string]struct{}{"80/tcp": {}}
+
+	tests := []struct {
+		name    string
+		source  string
+		inherit bool
+	}{
+		{
+			name:    "inherit",
+			source:  `bk.export(bk.image("nginx:1.27"):run("echo hi"), { env = { APP = "web" } })`,
+			inherit: true,
+		},
+		{
+			name:   "inherit false",
+			source: `bk.export(bk.image("nginx:1.27"):run("echo hi"), { env = { APP = "web" }, inherit = false })`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeClient(map[string][]byte{"build.lua": []byte(tt.source)}, nil)
+			c.imageConfig = base
+
+			res, err := Build(context.Background(), c)
+			require.NoError(t, err)
+
+			var config dockerspec.DockerOCIImage
+			require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey], &config))
+			require.Equal(t, "linux", config.OS)
+			if tt.inherit {
+				require.Equal(t, []string{"PATH=/usr/bin", "NGINX_VERSION=1.27", "APP=web"}, config.Config.Env)
+				require.Equal(t, []string{"/docker-entrypoint.sh"}, config.Config.Entrypoint)
+				require.Contains(t, config.Config.ExposedPorts, "80/tcp")
+			} else {
+				require.Equal(t, []string{"APP=web"}, config.Config.Env)
+				require.Nil(t, config.Config.Entrypoint)
+			}
+		})
+	}
+}
+
+func TestBuildInheritsPerPlatform(t *testing.T) {
+	base := &ocispec.Image{}
+	base.Config.Cmd = []string{"nginx"}
+
+	source := `bk.export(function(p) return bk.image("nginx:1.27", { platform = p }) end)`
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, map[string]string{
+		"platform": "linux/amd64,linux/arm64",
+	})
+	c.imageConfig = base
+
+	res, err := Build(context.Background(), c)
+	require.NoError(t, err)
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		var config dockerspec.DockerOCIImage
+		require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey+"/linux/"+arch], &config))
+		require.Equal(t, []string{"nginx"}, config.Config.Cmd)
+		require.Equal(t, arch, config.Architecture)
+	}
+}
+
+func TestBuildWritesExportAnnotations(t *testing.T) {
+	source := `bk.export(bk.scratch(), {
+    healthcheck = { test = "true", interval = "10s" },
+    annotations = { ["org.opencontainers.image.source"] = "https://example.com/app" },
+    index_annotations = { ["org.opencontainers.image.title"] = "app" },
+})`
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, nil)
+
+	res, err := Build(context.Background(), c)
+	require.NoError(t, err)
+
+	var config dockerspec.DockerOCIImage
+	require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey], &config))
+	require.NotNil(t, config.Config.Healthcheck)
+	require.Equal(t, []string{"CMD-SHELL", "true"}, config.Config.Healthcheck.Test)
+	require.Equal(t, 10*time.Second, config.Config.Healthcheck.Interval)
+
+	require.Equal(t, "https://example.com/app",
+		string(res.Metadata[exptypes.AnnotationManifestKey(nil, "org.opencontainers.image.source")]))
+	require.Equal(t, "app", string(res.Metadata[exptypes.AnnotationIndexKey("org.opencontainers.image.title")]))
+}
+
+func TestBuildImageHistory(t *testing.T) {
+	base := &ocispec.Image{}
+	base.History = []ocispec.History{{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in /"}}
+
+	source := `local base = bk.image("alpine:3.19")
+local env = base:env("GOFLAGS", "-mod=vendor")
+local app = env:run("make"):with_metadata({ comment = "compile" })
+bk.export(app:workdir("/app"))`
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, nil)
+	c.imageConfig = base
+
+	res, err := Build(context.Background(), c)
+	require.NoError(t, err)
+
+	var config dockerspec.DockerOCIImage
+	require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey], &config))
+	require.Equal(t, []ocispec.History{
+		{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in /"},
+		{CreatedBy: `build.lua:2 local env = base:env("GOFLAGS", "-mod=vendor")`, EmptyLayer: true},
+		{CreatedBy: `build.lua:3 local app = env:run("make"):with_metadata({ comment = "compile" })`, Comment: "compile"},
+		{CreatedBy: `build.lua:4 bk.export(app:workdir("/app"))`, EmptyLayer: true},
+	}, config.History)
+}
+
+func TestBuildSourceDateEpoch(t *testing.T) {
+	source := `bk.export(bk.image("alpine:3.19"):run("make"))`
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, map[string]string{
+		"build-arg:SOURCE_DATE_EPOCH": "1700000000",
+	})
+
+	res, err := Build(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, "1700000000", string(res.Metadata["source.date.epoch"]))
+
+	var config dockerspec.DockerOCIImage
+	require.NoError(t, json.Unmarshal(res.Metadata[exptypes.ExporterImageConfigKey], &config))
+	require.NotNil(t, config.Created)
+	require.Equal(t, int64(1700000000), config.Created.Unix())
+}
+
+func TestBuildInvalidSourceDateEpoch(t *testing.T) {
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(`bk.export(bk.scratch())`)}, map[string]string{
+		"build-arg:SOURCE_DATE_EPOCH": "soon",
+	})
+	_, err := Build(context.Background(), c)
+	require.ErrorContains(t, err, "invalid SOURCE_DATE_EPOCH")
+}
+
+func TestBuildNamedContexts(t *testing.T) {
+	source := `
+local base = bk.context("base", { default = bk.image("alpine:3.20") })
+local web = bk.context("web")
+bk.export(base:copy(web, "/app", "/srv"))
+`
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, map[string]string{
+		"context:base": "docker-image://golang:1.23",
+	})
+	c.inputs = map[string]llb.State{
+		"web": llb.Image("node:22").Run(llb.Shlex("npm run build")).Root(),
+	}
+
+	_, err := Build(context.Background(), c)
+	require.NoError(t, err)
+
+	ids := sourceIdentifiers(t, c.solved[len(c.solved)-1])
+	require.ElementsMatch(t, []string{
+		"docker-image://docker.io/library/golang:1.23",
+		"docker-image://docker.io/library/node:22",
+	}, ids)
+}
+
+func TestBuildNamedContextDefault(t *testing.T) {
+	source := `bk.export(bk.context("base", { default = bk.image("alpine:3.20") }))`
+	c := newFakeClient(map[string][]byte{"build.lua": []byte(source)}, nil)
+
+	_, err := Build(context.Background(), c)
+	require.NoError(t, err)
+	require.Equal(t, []string{"docker-image://docker.io/library/alpine:3.20"}, sourceIdentifiers(t, c.solved[len(c.solved)-1]))
+}
+
+func TestBuildStopsWhenContextIsDone(t *testing.T) {
+	c := newFakeClient(map[string][]byte{"build.lua": []byte("while true do end")}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := Build(ctx, c)
+	require.ErrorIs(t, err, luavm.ErrTimeout)
+}
+
+func TestEvaluateLuaLimits(t *testing.T) {
+	timeout, maxNodes, err := evalLimits(nil)
+	require.NoError(t, err)
+	require.Equal(t, defaultTimeout, timeout)
+	require.Equal(t, defaultMaxNodes, maxNodes)
+
+	_, err = evaluateLua(context.Background(), []byte("while true do end"), map[string]string{"timeout": "50ms"}, nil, nil)
+	var limitErr *luavm.LimitError
+	require.ErrorAs(t, err, &limitErr)
+	require.ErrorIs(t, err, luavm.ErrTimeout)
+	require.Equal(t, 1, limitErr.Line)
+
+	source := `local s = bk.image("alpine:3.20")
+for i = 1, 100 do s = s:run("echo " .. i) end
+bk.export(s)`
+	_, err = evaluateLua(context.Background(), []byte(source), map[string]string{"max-nodes": "10"}, nil, nil)
+	require.ErrorAs(t, err, &limitErr)
+	require.ErrorIs(t, err, luavm.ErrTooManyNodes)
+
+	_, err = evaluateLua(context.Background(), []byte(source), map[string]string{"max-nodes": "0", "timeout": "0"}, nil, nil)
+	require.NoError(t, err)
+
+	_, err = evaluateLua(context.Background(), []byte(source), map[string]string{"timeout": "soon"}, nil, nil)
+	require.ErrorContains(t, err, `invalid timeout "soon"`)
+	_, err = evaluateLua(context.Background(), []byte(source), map[string]string{"max-nodes": "-1"}, nil, nil)
+	require.ErrorContains(t, err, `invalid max-nodes "-1"`)
+}
+
+func TestEvaluateLuaStrictByDefault(t *testing.T) {
+	t.Setenv("HOME", "/home/builder")
+	source := `bk.export(bk.scratch():env({ NOW = tostring(os.time()), HOME = os.getenv("HOME") or "unset" }))`
+
+	result, err := evaluateLua(context.Background(), []byte(source), nil, nil, nil)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"NOW=0", "HOME=unset"}, result.ImageConfig.Config.Env)
+	require.Equal(t, []string{"HOME"}, result.EnvReads)
+
+	result, err = evaluateLua(context.Background(), []byte(source), map[string]string{"strict": "false"}, nil, nil)
+	require.NoError(t, err)
+	require.Contains(t, result.ImageConfig.Config.Env, "HOME=/home/builder")
+}
+
+func TestBuildReadsContextFiles(t *testing.T) {
+	c := newFakeClient(map[string][]byte{
+		"ci/build.lua": []byte(`
+local services = {}
+for _, f in ipairs(bk.glob("services/*.txt")) do
+	table.insert(services, bk.read_file(f))
+end
+assert(bk.exists("services"))
+assert(not bk.exists("missing.txt"))
+bk.export(bk.image("alpine:3.19"):run("echo " .. table.concat(services, " ")))
+`),
+		"ci/services/api.txt": []byte("api"),
+		"ci/services/web.txt": []byte("web"),
+		"other.txt":           []byte("outside"),
+	}, nil)
+
+	_, err := Build(context.Background(), c, WithEntrypoint("ci/build.lua"))
+	require.NoError(t, err)
+
+	c.files["ci/build.lua"] = []byte(`bk.read_file("../other.txt")`)
+	_, err = Build(context.Background(), c, WithEntrypoint("ci/build.lua"))
+	require.ErrorContains(t, err, "outside the build context")
+}
+
+func TestBuildDecodesManifestFromContext(t *testing.T) {
+	c := newFakeClient(map[string][]byte{
+		"build.lua": []byte(`
+local json = require("luakit.json")
+local manifest = json.decode(bk.read_file("manifest.json"))
+bk.export(bk.image(manifest.base):run("echo " .. manifest.name))
+`),
+		"manifest.json": []byte(`{"base": "alpine:3.19", "name": "app"}`),
+	}, nil)
+
+	_, err := Build(context.Background(), c)
+	require.NoError(t, err)
+}
+
+// errorRef fails every read with err.
+type errorRef struct {
+	gwclient.Reference
+	err error
+}
+
+func (r *errorRef) ReadFile(ctx context.Context, req gwclient.ReadRequest) ([]byte, error) {
+	return nil, r.err
+}
+
+func TestReadLockfileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr string
+	}{
+		{name: "not exist", err: fmt.Errorf("open luakit.lock: %w", os.ErrNotExist)},
+		{name: "not exist over grpc", err: status.Error(codes.Unknown, "open /tmp/ctx/luakit.lock: no such file or directory")},
+		{name: "not found", err: status.Error(codes.NotFound, "luakit.lock")},
+		{name: "permission", err: fmt.Errorf("open luakit.lock: %w", os.ErrPermission), wantErr: "failed to read luakit.lock"},
+		{name: "transport", err: status.Error(codes.Unavailable, "connection reset"), wantErr: "connection reset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock, err := readLockfile(context.Background(), &errorRef{err: tt.err}, "build.lua")
+			require.Nil(t, lock)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/client/llb/sourceresolver"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	pb "github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+// fakeClient is an in-memory gwclient.Client. Every Solve returns a reference
+// backed by files, and image configs resolve to imageConfig, or an empty
+// config, for the requested platform.
+type fakeClient struct {
+	gwclient.Client
+
+	mu          sync.Mutex
+	opts        map[string]string
+	files       map[string][]byte
+	solved      []*pb.Definition
+	imageConfig *ocispec.Image
+	inputs      map[string]llb.State
+}
+
+func newFakeClient(files map[string][]byte, opts map[string]string) *fakeClient {
+	if opts == nil {
+		opts = map[string]string{}
+	}
+	return &fakeClient{files: files, opts: opts}
+}
+
+func (c *fakeClient) BuildOpts() gwclient.BuildOpts {
+	return gwclient.BuildOpts{Opts: c.opts}
+}
+
+func (c *fakeClient) Inputs(ctx context.Context) (map[string]llb.State, error) {
+	return c.inputs, nil
+}
+
+func (c *fakeClient) Solve(ctx context.Context, req gwclient.SolveRequest) (*gwclient.Result, error) {
+	c.mu.Lock()
+	c.solved = append(c.solved, req.Definition)
+	c.mu.Unlock()
+
+	res := gwclient.NewResult()
+	res.SetRef(&fakeRef{files: c.files})
+	return res, nil
+}
+
+func (c *fakeClient) ResolveImageConfig(ctx context.Context, ref string, opt sourceresolver.Opt) (string, digest.Digest, []byte, error) {
+	img := ocispec.Image{}
+	if c.imageConfig != nil {
+		img = *c.imageConfig
+	}
+	if opt.ImageOpt != nil && opt.ImageOpt.Platform != nil {
+		img.Platform = *opt.ImageOpt.Platform
+	}
+	dt, err := json.Marshal(img)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return ref, digest.FromBytes(dt), dt, nil
+}
+
+type fakeRef struct {
+	gwclient.Reference
+	files map[string][]byte
+}
+
+func (r *fakeRef) ReadFile(ctx context.Context, req gwclient.ReadRequest) ([]byte, error) {
+	dt, ok := r.files[req.Filename]
+	if !ok {
+		return nil, fmt.Errorf("open %s: %w", req.Filename, os.ErrNotExist)
+	}
+	return slices.Clone(dt), nil
+}
+
+// StatFile and ReadDir treat every prefix of a file path as a directory.
+func (r *fakeRef) StatFile(ctx context.Context, req gwclient.StatRequest) (*fstypes.Stat, error) {
+	name := path.Clean(req.Path)
+	if dt, ok := r.files[name]; ok {
+		return &fstypes.Stat{Path: name, Mode: 0644, Size: int64(len(dt))}, nil
+	}
+	for file := range r.files {
+		if name == "." || strings.HasPrefix(file, name+"/") {
+			return &fstypes.Stat{Path: name, Mode: uint32(os.ModeDir | 0755)}, nil
+		}
+	}
+	return nil, fmt.Errorf("stat %s: %w", req.Path, os.ErrNotExist)
+}
+
+func (r *fakeRef) ReadDir(ctx context.Context, req gwclient.ReadDirRequest) ([]*fstypes.Stat, error) {
+	dir := path.Clean(req.Path)
+	seen := map[string]bool{}
+	var stats []*fstypes.Stat
+	for file := range r.files {
+		rel := file
+		if dir != "." {
+			var ok bool
+			if rel, ok = strings.CutPrefix(file, dir+"/"); !ok {
+				continue
+			}
+		}
+		name, _, _ := strings.Cut(rel, "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		st, err := r.StatFile(ctx, gwclient.StatRequest{Path: path.Join(dir, name)})
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &fstypes.Stat{Path: name, Mode: st.Mode, Size: st.Size})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Path < stats[j].Path })
+	return stats, nil
+}
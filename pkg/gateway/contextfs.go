@@ -0,0 +1,160 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	fstypes "github.com/tonistiigi/fsutil/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// isNotExist reports whether err, returned by a gateway read, means the file
+// does not exist. The gateway sends OS errors as gRPC errors without their
+// type, so the message is checked too.
+func isNotExist(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, fs.ErrNotExist) || status.Code(err) == codes.NotFound {
+		return true
+	}
+	return strings.Contains(err.Error(), syscall.ENOENT.Error())
+}
+
+// pathError returns a PathError for a failed gateway call, with
+// fs.ErrNotExist for a missing file.
+func pathError(op, name string, err error) error {
+	if isNotExist(err) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// referenceFS is a read-only fs.FS over a solved reference, such as the
+// build context. Every call goes through the gateway.
+type referenceFS struct {
+	ctx context.Context
+	ref gwclient.Reference
+}
+
+var (
+	_ fs.ReadFileFS = (*referenceFS)(nil)
+	_ fs.ReadDirFS  = (*referenceFS)(nil)
+	_ fs.StatFS     = (*referenceFS)(nil)
+)
+
+func newReferenceFS(ctx context.Context, ref gwclient.Reference) *referenceFS {
+	return &referenceFS{ctx: ctx, ref: ref}
+}
+
+func (f *referenceFS) Open(name string) (fs.File, error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		entries, err := f.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &referenceDir{info: info, entries: entries}, nil
+	}
+	data, err := f.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &referenceFile{info: info, Reader: bytes.NewReader(data)}, nil
+}
+
+func (f *referenceFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := f.ref.ReadFile(f.ctx, gwclient.ReadRequest{Filename: name})
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+	return data, nil
+}
+
+func (f *referenceFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	stats, err := f.ref.ReadDir(f.ctx, gwclient.ReadDirRequest{Path: name})
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	entries := make([]fs.DirEntry, 0, len(stats))
+	for _, st := range stats {
+		entries = append(entries, fs.FileInfoToDirEntry(statInfo{st}))
+	}
+	return entries, nil
+}
+
+func (f *referenceFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	st, err := f.ref.StatFile(f.ctx, gwclient.StatRequest{Path: name})
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+	return statInfo{st}, nil
+}
+
+type referenceFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *referenceFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *referenceFile) Close() error               { return nil }
+
+type referenceDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+}
+
+func (d *referenceDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *referenceDir) Close() error               { return nil }
+
+func (d *referenceDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *referenceDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// statInfo is the fs.FileInfo of a file stat returned by the gateway.
+type statInfo struct {
+	*fstypes.Stat
+}
+
+func (s statInfo) Name() string       { return path.Base(s.Path) }
+func (s statInfo) Size() int64        { return s.Stat.Size }
+func (s statInfo) Mode() fs.FileMode  { return fs.FileMode(s.Stat.Mode) }
+func (s statInfo) ModTime() time.Time { return time.Unix(0, s.Stat.ModTime) }
+func (s statInfo) IsDir() bool        { return s.Mode().IsDir() }
+func (s statInfo) Sys() any           { return s.Stat }
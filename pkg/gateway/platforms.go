@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/platforms"
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/luavm"
+	"github.com/kasuboski/luakit/pkg/resolver"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	gwclient "github.com/moby/buildkit/frontend/gateway/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// buildPlatforms solves one definition per exported platform and assembles a
+// result with per-platform refs and image configs, as the Dockerfile frontend
+// does for multi-platform builds.
+func buildPlatforms(ctx context.Context, c gwclient.Client, result *luavm.EvalResult, reslv resolver.Interface) (*gwclient.Result, error) {
+	res := gwclient.NewResult()
+	expPlatforms := &exptypes.Platforms{
+		Platforms: make([]exptypes.Platform, 0, len(result.Platforms)),
+	}
+	multi := len(result.Platforms) > 1
+
+	for _, ps := range result.Platforms {
+		p := ocispec.Platform{
+			OS:           ps.Platform.OS,
+			Architecture: ps.Platform.Architecture,
+			Variant:      ps.Platform.Variant,
+		}
+		id := platforms.FormatAll(p)
+
+		serializeOpts := &dag.SerializeOptions{
+			ImageConfig:        ps.ImageConfig,
+			SourceFiles:        result.SourceFiles,
+			Resolver:           reslv,
+			InheritImageConfig: result.InheritImageConfig,
+			SourceDateEpoch:    result.SourceDateEpoch,
+		}
+		def, imageConfig, err := dag.SerializeImage(ps.State, serializeOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize definition for %s: %w", id, err)
+		}
+
+		r, err := c.Solve(ctx, gwclient.SolveRequest{
+			Definition: def,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to solve definition for %s: %w", id, err)
+		}
+
+		ref, err := r.SingleRef()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reference for %s: %w", id, err)
+		}
+
+		config, err := json.Marshal(imageConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal image config for %s: %w", id, err)
+		}
+
+		if multi {
+			res.AddRef(id, ref)
+			res.AddMeta(fmt.Sprintf("%s/%s", exptypes.ExporterImageConfigKey, id), config)
+		} else {
+			res.SetRef(ref)
+			res.AddMeta(exptypes.ExporterImageConfigKey, config)
+		}
+
+		expPlatforms.Platforms = append(expPlatforms.Platforms, exptypes.Platform{
+			ID:       id,
+			Platform: p,
+		})
+	}
+
+	dt, err := json.Marshal(expPlatforms)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal platforms: %w", err)
+	}
+	res.AddMeta(exptypes.ExporterPlatformsKey, dt)
+	for k, v := range result.ExportMetadata() {
+		res.AddMeta(k, v)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,63 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultConfigFile is the config file looked up next to the build script.
+const DefaultConfigFile = ".luakit-lint.json"
+
+// Config toggles rules by ID. Rules not listed are enabled.
+//
+//	{ "rules": { "image-digest": false } }
+type Config struct {
+	Rules map[string]bool `json:"rules"`
+}
+
+// LoadConfig reads a JSON lint config and rejects unknown rule IDs.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- Path is user-provided config for lint tool
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lint config: %w", err)
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse lint config %s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, rule := range Rules() {
+		known[rule.ID] = true
+	}
+	for id := range config.Rules {
+		if !known[id] {
+			return nil, fmt.Errorf("lint config %s: unknown rule %q", path, id)
+		}
+	}
+
+	return &config, nil
+}
+
+// OnlyRules returns a config that enables just the given rules.
+func OnlyRules(ids ...string) *Config {
+	config := &Config{Rules: make(map[string]bool)}
+	for _, rule := range Rules() {
+		config.Rules[rule.ID] = false
+	}
+	for _, id := range ids {
+		config.Rules[id] = true
+	}
+	return config
+}
+
+// Enabled reports whether a rule is enabled.
+func (c *Config) Enabled(rule string) bool {
+	if c == nil || c.Rules == nil {
+		return true
+	}
+	enabled, ok := c.Rules[rule]
+	return !ok || enabled
+}
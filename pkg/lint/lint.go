@@ -0,0 +1,262 @@
+// Package lint implements static checks over an evaluated build DAG.
+package lint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+)
+
+// Finding is a single rule violation located at the Lua call site that
+// created the offending operation.
+type Finding struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+func (f Finding) String() string {
+	if f.File == "" {
+		return fmt.Sprintf("[%s] %s", f.Rule, f.Message)
+	}
+	return fmt.Sprintf("%s:%d: [%s] %s", f.File, f.Line, f.Rule, f.Message)
+}
+
+// Rule checks a single OpNode. Check returns one message per violation.
+type Rule struct {
+	ID          string
+	Description string
+	Check       func(node *dag.OpNode) []string
+}
+
+// Linter applies a set of rules to a DAG.
+type Linter struct {
+	rules      []*Rule
+	config     *Config
+	directives map[string]*directives
+}
+
+// New creates a Linter with the built-in rules, the given config and the
+// Lua source files used to honor inline directives.
+func New(config *Config, sourceFiles map[string][]byte) *Linter {
+	if config == nil {
+		config = &Config{}
+	}
+
+	l := &Linter{
+		rules:      Rules(),
+		config:     config,
+		directives: make(map[string]*directives, len(sourceFiles)),
+	}
+	for filename, data := range sourceFiles {
+		l.directives[filename] = parseDirectives(data)
+	}
+	return l
+}
+
+// Run walks every OpNode reachable from the states and returns the findings
+// sorted by file and line.
+func (l *Linter) Run(states ...*dag.State) []Finding {
+	visited := make(map[*dag.OpNode]bool)
+	var findings []Finding
+
+	var walk func(*dag.OpNode)
+	walk = func(node *dag.OpNode) {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+
+		for _, edge := range node.Inputs() {
+			walk(edge.Node())
+		}
+
+		for _, rule := range l.rules {
+			if !l.enabled(rule.ID, node.LuaFile(), node.LuaLine()) {
+				continue
+			}
+			for _, msg := range rule.Check(node) {
+				findings = append(findings, Finding{
+					Rule:    rule.ID,
+					Message: msg,
+					File:    node.LuaFile(),
+					Line:    node.LuaLine(),
+				})
+			}
+		}
+	}
+
+	for _, state := range states {
+		if state != nil {
+			walk(state.Op())
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].File != findings[j].File {
+			return findings[i].File < findings[j].File
+		}
+		if findings[i].Line != findings[j].Line {
+			return findings[i].Line < findings[j].Line
+		}
+		return findings[i].Rule < findings[j].Rule
+	})
+
+	return findings
+}
+
+func (l *Linter) enabled(rule, file string, line int) bool {
+	if !l.config.Enabled(rule) {
+		return false
+	}
+	if d, ok := l.directives[file]; ok && d.disabled(rule, line) {
+		return false
+	}
+	return true
+}
+
+const directivePrefix = "luakit-lint:"
+
+// directives holds inline rule toggles parsed from Lua comments:
+//
+//	-- luakit-lint: disable=image-digest,apt-cache   (this and the next line)
+//	-- luakit-lint: disable-file=image-digest        (whole file)
+type directives struct {
+	file  map[string]bool
+	lines map[int]map[string]bool
+}
+
+func parseDirectives(data []byte) *directives {
+	d := &directives{
+		file:  make(map[string]bool),
+		lines: make(map[int]map[string]bool),
+	}
+
+	for _, c := range luaComments(string(data)) {
+		comment := strings.TrimSpace(c.text)
+		if !strings.HasPrefix(comment, directivePrefix) {
+			continue
+		}
+		directive := strings.TrimSpace(strings.TrimPrefix(comment, directivePrefix))
+
+		key, value, ok := strings.Cut(directive, "=")
+		if !ok {
+			continue
+		}
+
+		for _, rule := range strings.Split(value, ",") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+			switch strings.TrimSpace(key) {
+			case "disable-file":
+				d.file[rule] = true
+			case "disable":
+				for _, n := range []int{c.line, c.line + 1} {
+					if d.lines[n] == nil {
+						d.lines[n] = make(map[string]bool)
+					}
+					d.lines[n][rule] = true
+				}
+			}
+		}
+	}
+
+	return d
+}
+
+// luaComment is a comment in Lua source, without its leading "--".
+type luaComment struct {
+	line int
+	text string
+}
+
+// luaComments returns the comments in src. Quoted and long bracket strings
+// are skipped, so a "--" inside a string does not start a comment.
+func luaComments(src string) []luaComment {
+	var comments []luaComment
+	line := 1
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == '\n':
+			line++
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			start, end := i+2, len(src)
+			if level, ok := longBracket(src[start:]); ok {
+				end = closeLongBracket(src, start+level+2, level)
+			} else if n := strings.IndexByte(src[start:], '\n'); n >= 0 {
+				end = start + n
+			}
+			comments = append(comments, luaComment{line: line, text: src[start:end]})
+			line += strings.Count(src[i:end], "\n")
+			i = end
+		case c == '"' || c == '\'':
+			i++
+			for i < len(src) && src[i] != c && src[i] != '\n' {
+				if src[i] == '\\' && i+1 < len(src) {
+					if src[i+1] == '\n' {
+						line++
+					}
+					i++
+				}
+				i++
+			}
+			if i < len(src) && src[i] == c {
+				i++
+			}
+		case c == '[':
+			level, ok := longBracket(src[i:])
+			if !ok {
+				i++
+				continue
+			}
+			end := closeLongBracket(src, i+level+2, level)
+			line += strings.Count(src[i:end], "\n")
+			i = end
+		default:
+			i++
+		}
+	}
+	return comments
+}
+
+// longBracket reports whether s starts with an opening long bracket such as
+// [[ or [==[, and its level.
+func longBracket(s string) (int, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return 0, false
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1, true
+	}
+	return 0, false
+}
+
+// closeLongBracket returns the offset just past the closing bracket of the
+// given level, searching from start, or len(src) if it is not closed.
+func closeLongBracket(src string, start, level int) int {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	if n := strings.Index(src[start:], closing); n >= 0 {
+		return start + n + len(closing)
+	}
+	return len(src)
+}
+
+func (d *directives) disabled(rule string, line int) bool {
+	if d.file[rule] || d.file["all"] {
+		return true
+	}
+	if rules, ok := d.lines[line]; ok {
+		return rules[rule] || rules["all"]
+	}
+	return false
+}
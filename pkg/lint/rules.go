@@ -0,0 +1,262 @@
+package lint
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	pb "github.com/moby/buildkit/solver/pb"
+)
+
+// Rules returns the built-in rules.
+func Rules() []*Rule {
+	return []*Rule{
+		{
+			ID:          "network-host",
+			Description: "run uses network=host",
+			Check:       checkNetworkHost,
+		},
+		{
+			ID:          "security-insecure",
+			Description: "run uses security=insecure",
+			Check:       checkSecurityInsecure,
+		},
+		{
+			ID:          "image-digest",
+			Description: "bk.image reference is not pinned to a digest",
+			Check:       checkImageDigest,
+		},
+		{
+			ID:          "apt-cache",
+			Description: "apt-get install runs without a cache mount",
+			Check:       checkAptCache,
+		},
+		{
+			ID:          "copy-before-install",
+			Description: "dependency install runs after copying the full local context",
+			Check:       checkCopyBeforeInstall,
+		},
+		{
+			ID:          "http-checksum",
+			Description: "bk.http source has no checksum",
+			Check:       checkHTTPChecksum,
+		},
+		{
+			ID:          "http-credentials",
+			Description: "bk.http source has literal credentials",
+			Check:       checkHTTPCredentials,
+		},
+	}
+}
+
+func checkNetworkHost(node *dag.OpNode) []string {
+	exec := node.Op().GetExec()
+	if exec == nil || exec.Network != pb.NetMode_HOST {
+		return nil
+	}
+	return []string{"run uses network=host; the step can reach services on the build host"}
+}
+
+func checkSecurityInsecure(node *dag.OpNode) []string {
+	exec := node.Op().GetExec()
+	if exec == nil || exec.Security != pb.SecurityMode_INSECURE {
+		return nil
+	}
+	return []string{"run uses security=insecure; the step runs with elevated privileges"}
+}
+
+func checkImageDigest(node *dag.OpNode) []string {
+	source := node.Op().GetSource()
+	if source == nil || !strings.HasPrefix(source.Identifier, "docker-image://") {
+		return nil
+	}
+	if strings.Contains(source.Identifier, "@sha256:") {
+		return nil
+	}
+	ref := strings.TrimPrefix(source.Identifier, "docker-image://")
+	return []string{fmt.Sprintf("image %s is not pinned to a digest", ref)}
+}
+
+func checkAptCache(node *dag.OpNode) []string {
+	exec := node.Op().GetExec()
+	if exec == nil || exec.Meta == nil {
+		return nil
+	}
+	cmd := strings.Join(exec.Meta.Args, " ")
+	if !strings.Contains(cmd, "apt-get install") && !strings.Contains(cmd, "apt install") {
+		return nil
+	}
+	for _, m := range exec.Mounts {
+		if m.MountType == pb.MountType_CACHE && (strings.HasPrefix(m.Dest, "/var/cache/apt") || strings.HasPrefix(m.Dest, "/var/lib/apt")) {
+			return nil
+		}
+	}
+	return []string{"apt-get install without a cache mount on /var/cache/apt; add bk.cache(\"/var/cache/apt\", { sharing = \"locked\" })"}
+}
+
+var installCommands = []string{
+	"npm install",
+	"npm ci",
+	"yarn install",
+	"pnpm install",
+	"pip install",
+	"poetry install",
+	"go mod download",
+	"bundle install",
+	"cargo fetch",
+	"composer install",
+}
+
+func checkCopyBeforeInstall(node *dag.OpNode) []string {
+	exec := node.Op().GetExec()
+	if exec == nil || exec.Meta == nil {
+		return nil
+	}
+	cmd := strings.Join(exec.Meta.Args, " ")
+	installs := false
+	for _, c := range installCommands {
+		if strings.Contains(cmd, c) {
+			installs = true
+			break
+		}
+	}
+	if !installs {
+		return nil
+	}
+
+	for n := rootParent(node); n != nil; n = rootParent(n) {
+		if copyNode := fullContextCopy(n); copyNode != nil {
+			return []string{fmt.Sprintf(
+				"dependency install runs after copying the full local context at %s:%d; copy dependency manifests first so the install step stays cached",
+				copyNode.LuaFile(), copyNode.LuaLine())}
+		}
+	}
+	return nil
+}
+
+// rootParent returns the node providing the root filesystem of node.
+func rootParent(node *dag.OpNode) *dag.OpNode {
+	inputs := node.Inputs()
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	switch op := node.Op().Op.(type) {
+	case *pb.Op_Exec:
+		for _, m := range op.Exec.Mounts {
+			if m.Dest == "/" && m.Input >= 0 && int(m.Input) < len(inputs) {
+				return inputs[m.Input].Node()
+			}
+		}
+	case *pb.Op_File:
+		if len(op.File.Actions) > 0 {
+			idx := op.File.Actions[0].Input
+			if idx >= 0 && int(idx) < len(inputs) {
+				return inputs[idx].Node()
+			}
+		}
+	}
+
+	return inputs[0].Node()
+}
+
+// fullContextCopy returns node if it copies the whole of an unfiltered local
+// source into the filesystem.
+func fullContextCopy(node *dag.OpNode) *dag.OpNode {
+	file := node.Op().GetFile()
+	if file == nil {
+		return nil
+	}
+
+	for _, action := range file.Actions {
+		cp := action.GetCopy()
+		if cp == nil || len(cp.IncludePatterns) > 0 {
+			continue
+		}
+		if cp.Src != "." && cp.Src != "/" && cp.Src != "./" {
+			continue
+		}
+		idx := action.SecondaryInput
+		if idx < 0 || int(idx) >= len(node.Inputs()) {
+			continue
+		}
+		source := node.Inputs()[idx].Node().Op().GetSource()
+		if source == nil || !strings.HasPrefix(source.Identifier, "local://") {
+			continue
+		}
+		filtered := false
+		for key := range source.Attrs {
+			if strings.HasPrefix(key, "includepattern") {
+				filtered = true
+				break
+			}
+		}
+		if !filtered {
+			return node
+		}
+	}
+
+	return nil
+}
+
+func checkHTTPChecksum(node *dag.OpNode) []string {
+	source := node.Op().GetSource()
+	if source == nil {
+		return nil
+	}
+	if !strings.HasPrefix(source.Identifier, "http://") && !strings.HasPrefix(source.Identifier, "https://") {
+		return nil
+	}
+	if source.Attrs["checksum"] != "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("download %s has no checksum; pass { checksum = \"sha256:...\" }", source.Identifier)}
+}
+
+// credentialHeaders are header names, and words in header names, whose
+// values are treated as credentials.
+var credentialHeaders = []string{"authorization", "cookie", "token", "secret", "api-key", "apikey", "password"}
+
+func checkHTTPCredentials(node *dag.OpNode) []string {
+	source := node.Op().GetSource()
+	if source == nil {
+		return nil
+	}
+	if !strings.HasPrefix(source.Identifier, "http://") && !strings.HasPrefix(source.Identifier, "https://") {
+		return nil
+	}
+
+	var msgs []string
+	download := source.Identifier
+	if u, err := url.Parse(source.Identifier); err == nil && u.User != nil {
+		download = u.Redacted()
+		if _, ok := u.User.Password(); ok {
+			msgs = append(msgs, fmt.Sprintf("download %s has a password in the URL; use { auth_secret = \"<id>\" }", download))
+		}
+	}
+	if source.Attrs["http.basicauth"] != "" {
+		msgs = append(msgs, fmt.Sprintf("download %s passes username and password in the definition; use { auth_secret = \"<id>\" }", download))
+	}
+
+	var headers []string
+	for key := range source.Attrs {
+		name, ok := strings.CutPrefix(key, "http.header.")
+		if !ok {
+			continue
+		}
+		lower := strings.ToLower(name)
+		for _, word := range credentialHeaders {
+			if strings.Contains(lower, word) {
+				headers = append(headers, name)
+				break
+			}
+		}
+	}
+	slices.Sort(headers)
+	for _, name := range headers {
+		msgs = append(msgs, fmt.Sprintf("download %s passes the %s header in the definition; read credentials from a secret with { header_secrets = { Authorization = \"<id>\" } }", download, name))
+	}
+	return msgs
+}
@@ -0,0 +1,80 @@
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+)
+
+// Apply rewrites the SourceOps reachable from the states to their locked
+// form: images gain an @digest, git refs are replaced by commits and HTTP
+// sources get a checksum. With frozen set, an unpinned source missing from
+// the lockfile is an error; otherwise it is left floating. All missing
+// sources are reported together.
+func (l *Lockfile) Apply(frozen bool, states ...*dag.State) error {
+	var missing []error
+	for _, node := range sourceNodes(states...) {
+		source := node.Op().GetSource()
+		id := source.Identifier
+
+		switch {
+		case strings.HasPrefix(id, imagePrefix):
+			ref, pinned := imageRef(id)
+			if pinned {
+				continue
+			}
+			platform := formatPlatform(nodePlatform(node))
+			digest, ok := l.image(ref, platform)
+			if !ok {
+				if frozen {
+					missing = append(missing, missingError(node, fmt.Sprintf("image %s (%s)", ref, platform)))
+				}
+				continue
+			}
+			source.Identifier = imagePrefix + ref + "@" + digest
+			node.InvalidateDigest()
+
+		case strings.HasPrefix(id, gitPrefix):
+			remote, ref, subdir := gitSource(id)
+			if isCommit(ref) {
+				continue
+			}
+			commit, ok := l.git(remote, ref)
+			if !ok {
+				if frozen {
+					missing = append(missing, missingError(node, fmt.Sprintf("git %s#%s", remote, ref)))
+				}
+				continue
+			}
+			source.Identifier = gitPrefix + remote + "#" + commit + subdir
+			node.InvalidateDigest()
+
+		case isHTTP(id):
+			if source.Attrs["checksum"] != "" {
+				continue
+			}
+			checksum, ok := l.http(id)
+			if !ok {
+				if frozen {
+					missing = append(missing, missingError(node, id))
+				}
+				continue
+			}
+			if source.Attrs == nil {
+				source.Attrs = make(map[string]string)
+			}
+			source.Attrs["checksum"] = checksum
+			node.InvalidateDigest()
+		}
+	}
+	return errors.Join(missing...)
+}
+
+func missingError(node *dag.OpNode, what string) error {
+	if node.LuaFile() == "" {
+		return fmt.Errorf("%s is not in %s; run luakit lock", what, FileName)
+	}
+	return fmt.Errorf("%s:%d: %s is not in %s; run luakit lock", node.LuaFile(), node.LuaLine(), what, FileName)
+}
@@ -0,0 +1,168 @@
+package lockfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/resolver"
+)
+
+// GitResolver resolves a git ref to a commit.
+type GitResolver interface {
+	ResolveCommit(ctx context.Context, remote, ref string) (string, error)
+}
+
+// HTTPResolver computes the checksum of a download.
+type HTTPResolver interface {
+	Checksum(ctx context.Context, url string) (string, error)
+}
+
+// Generator resolves the sources of a DAG into lockfile entries.
+type Generator struct {
+	Images resolver.Interface
+	Git    GitResolver
+	HTTP   HTTPResolver
+}
+
+// NewGenerator returns a Generator that resolves images with images, git
+// refs with `git ls-remote` and downloads over HTTP.
+func NewGenerator(images resolver.Interface) *Generator {
+	return &Generator{
+		Images: images,
+		Git:    &lsRemote{},
+		HTTP:   &httpChecksum{client: http.DefaultClient},
+	}
+}
+
+// Lock adds an entry to lock for every unpinned source reachable from the
+// states. Sources already present in lock are not resolved again.
+func (g *Generator) Lock(ctx context.Context, lock *Lockfile, states ...*dag.State) error {
+	for _, node := range sourceNodes(states...) {
+		source := node.Op().GetSource()
+		id := source.Identifier
+
+		switch {
+		case strings.HasPrefix(id, imagePrefix):
+			ref, pinned := imageRef(id)
+			if pinned {
+				continue
+			}
+			platform := nodePlatform(node)
+			if _, ok := lock.image(ref, formatPlatform(platform)); ok {
+				continue
+			}
+			cfg, err := g.Images.Resolve(ctx, id, platform)
+			if err != nil {
+				return fmt.Errorf("failed to lock image %s: %w", ref, err)
+			}
+			lock.Images = append(lock.Images, ImageEntry{
+				Ref:      ref,
+				Platform: formatPlatform(platform),
+				Digest:   cfg.Digest,
+			})
+
+		case strings.HasPrefix(id, gitPrefix):
+			remote, ref, _ := gitSource(id)
+			if isCommit(ref) {
+				continue
+			}
+			if _, ok := lock.git(remote, ref); ok {
+				continue
+			}
+			commit, err := g.Git.ResolveCommit(ctx, remote, ref)
+			if err != nil {
+				return fmt.Errorf("failed to lock git %s#%s: %w", remote, ref, err)
+			}
+			lock.Git = append(lock.Git, GitEntry{Remote: remote, Ref: ref, Commit: commit})
+
+		case isHTTP(id):
+			if _, ok := lock.http(id); ok {
+				continue
+			}
+			checksum := source.Attrs["checksum"]
+			if checksum == "" {
+				var err error
+				checksum, err = g.HTTP.Checksum(ctx, id)
+				if err != nil {
+					return fmt.Errorf("failed to lock %s: %w", id, err)
+				}
+			}
+			lock.HTTP = append(lock.HTTP, HTTPEntry{URL: id, Checksum: checksum})
+		}
+	}
+	return nil
+}
+
+// lsRemote resolves refs with the git CLI.
+type lsRemote struct{}
+
+func (lsRemote) ResolveCommit(ctx context.Context, remote, ref string) (string, error) {
+	url := remote
+	if !strings.Contains(url, "://") && !strings.Contains(url, "@") {
+		url = "https://" + url
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", url, ref) // #nosec G204 -- Remote and ref come from the build script
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git ls-remote: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Prefer the peeled commit of an annotated tag over the tag object.
+	var commit string
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if strings.HasSuffix(fields[1], "^{}") || commit == "" {
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("ref %q not found", ref)
+	}
+	return commit, nil
+}
+
+// httpChecksum downloads a URL and returns its sha256 digest.
+type httpChecksum struct {
+	client *http.Client
+}
+
+func (h *httpChecksum) Checksum(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.client.Do(req) // #nosec G107 -- URL comes from the build script
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,140 @@
+// Package lockfile pins floating build sources — image tags, git refs and
+// HTTP downloads — to immutable digests, commits and checksums.
+package lockfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const (
+	// FileName is the lockfile written next to the build script.
+	FileName = "luakit.lock"
+
+	// Version is the current lockfile format version.
+	Version = 1
+)
+
+// Lockfile records the resolved form of every source a build uses.
+type Lockfile struct {
+	Version int          `json:"version"`
+	Images  []ImageEntry `json:"images,omitempty"`
+	Git     []GitEntry   `json:"git,omitempty"`
+	HTTP    []HTTPEntry  `json:"http,omitempty"`
+}
+
+// ImageEntry pins an image reference on one platform to a manifest digest.
+type ImageEntry struct {
+	Ref      string `json:"ref"`
+	Platform string `json:"platform"`
+	Digest   string `json:"digest"`
+}
+
+// GitEntry pins a git remote and ref to a commit.
+type GitEntry struct {
+	Remote string `json:"remote"`
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+}
+
+// HTTPEntry pins a download URL to a content checksum.
+type HTTPEntry struct {
+	URL      string `json:"url"`
+	Checksum string `json:"checksum"`
+}
+
+// New returns an empty lockfile.
+func New() *Lockfile {
+	return &Lockfile{Version: Version}
+}
+
+// Load reads a lockfile from disk.
+func Load(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- Path is the lockfile next to the user's build script
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile: %w", err)
+	}
+	lock, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return lock, nil
+}
+
+// Parse decodes a lockfile.
+func Parse(data []byte) (*Lockfile, error) {
+	var lock Lockfile
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile: %w", err)
+	}
+	if lock.Version != Version {
+		return nil, fmt.Errorf("unsupported lockfile version %d (expected %d)", lock.Version, Version)
+	}
+	return &lock, nil
+}
+
+// Marshal encodes the lockfile with entries sorted so the output is stable.
+func (l *Lockfile) Marshal() ([]byte, error) {
+	sort.Slice(l.Images, func(i, j int) bool {
+		if l.Images[i].Ref != l.Images[j].Ref {
+			return l.Images[i].Ref < l.Images[j].Ref
+		}
+		return l.Images[i].Platform < l.Images[j].Platform
+	})
+	sort.Slice(l.Git, func(i, j int) bool {
+		if l.Git[i].Remote != l.Git[j].Remote {
+			return l.Git[i].Remote < l.Git[j].Remote
+		}
+		return l.Git[i].Ref < l.Git[j].Ref
+	})
+	sort.Slice(l.HTTP, func(i, j int) bool {
+		return l.HTTP[i].URL < l.HTTP[j].URL
+	})
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Write saves the lockfile to path.
+func (l *Lockfile) Write(path string) error {
+	data, err := l.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil { // #nosec G306 -- Lockfile is meant to be committed
+		return fmt.Errorf("failed to write lockfile: %w", err)
+	}
+	return nil
+}
+
+func (l *Lockfile) image(ref, platform string) (string, bool) {
+	for _, e := range l.Images {
+		if e.Ref == ref && e.Platform == platform {
+			return e.Digest, true
+		}
+	}
+	return "", false
+}
+
+func (l *Lockfile) git(remote, ref string) (string, bool) {
+	for _, e := range l.Git {
+		if e.Remote == remote && e.Ref == ref {
+			return e.Commit, true
+		}
+	}
+	return "", false
+}
+
+func (l *Lockfile) http(url string) (string, bool) {
+	for _, e := range l.HTTP {
+		if e.URL == url {
+			return e.Checksum, true
+		}
+	}
+	return "", false
+}
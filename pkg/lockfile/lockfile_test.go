@@ -0,0 +1,240 @@
+package lockfile
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/luavm"
+	"github.com/kasuboski/luakit/pkg/resolver"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type fakeImages struct {
+	calls int
+}
+
+func (f *fakeImages) Resolve(ctx context.Context, ref string, platform ocispec.Platform) (*resolver.ImageConfig, error) {
+	f.calls++
+	return &resolver.ImageConfig{
+		Ref:      ref,
+		Digest:   fmt.Sprintf("sha256:%064x", len(platform.Architecture)),
+		Platform: platform,
+	}, nil
+}
+
+type fakeGit map[string]string
+
+func (f fakeGit) ResolveCommit(ctx context.Context, remote, ref string) (string, error) {
+	commit, ok := f[remote+"#"+ref]
+	if !ok {
+		return "", fmt.Errorf("ref %q not found", ref)
+	}
+	return commit, nil
+}
+
+type fakeHTTP map[string]string
+
+func (f fakeHTTP) Checksum(ctx context.Context, url string) (string, error) {
+	return f[url], nil
+}
+
+const commit = "0123456789abcdef0123456789abcdef01234567"
+
+const script = `
+local base = bk.image("alpine:3.19")
+local pinned = bk.image("alpine@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+local src = bk.git("https://github.com/example/repo.git", { ref = "main" })
+local tool = bk.https("https://example.com/tool.tar.gz")
+local out = base:copy(src, "/", "/src"):copy(tool, "/", "/tool"):copy(pinned, "/etc", "/pinned")
+bk.export(out)
+`
+
+func evaluate(t *testing.T, src string, config *luavm.VMConfig) []*dag.State {
+	t.Helper()
+	result, err := luavm.Evaluate(strings.NewReader(src), "build.lua", config)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	return result.States()
+}
+
+func newTestGenerator() (*Generator, *fakeImages) {
+	images := &fakeImages{}
+	return &Generator{
+		Images: images,
+		Git:    fakeGit{"https://github.com/example/repo.git#main": commit},
+		HTTP:   fakeHTTP{"https://example.com/tool.tar.gz": "sha256:abcd"},
+	}, images
+}
+
+func sourceIdentifiers(states []*dag.State) map[string]string {
+	ids := make(map[string]string)
+	for _, node := range sourceNodes(states...) {
+		source := node.Op().GetSource()
+		ids[source.Identifier] = source.Attrs["checksum"]
+	}
+	return ids
+}
+
+func TestLockAndApply(t *testing.T) {
+	gen, images := newTestGenerator()
+	lock := New()
+
+	if err := gen.Lock(context.Background(), lock, evaluate(t, script, nil)...); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	if len(lock.Images) != 1 {
+		t.Fatalf("expected 1 image entry (pinned images are skipped), got %v", lock.Images)
+	}
+	if lock.Images[0].Ref != "docker.io/library/alpine:3.19" {
+		t.Errorf("unexpected image ref %q", lock.Images[0].Ref)
+	}
+	if images.calls != 1 {
+		t.Errorf("expected 1 resolve call, got %d", images.calls)
+	}
+	if len(lock.Git) != 1 || lock.Git[0].Commit != commit || lock.Git[0].Ref != "main" {
+		t.Errorf("unexpected git entries %v", lock.Git)
+	}
+	if len(lock.HTTP) != 1 || lock.HTTP[0].Checksum != "sha256:abcd" {
+		t.Errorf("unexpected http entries %v", lock.HTTP)
+	}
+
+	states := evaluate(t, script, nil)
+	if err := lock.Apply(true, states...); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	ids := sourceIdentifiers(states)
+	wantImage := "docker-image://docker.io/library/alpine:3.19@" + lock.Images[0].Digest
+	if _, ok := ids[wantImage]; !ok {
+		t.Errorf("expected pinned image %s, got %v", wantImage, ids)
+	}
+	if _, ok := ids["git://https://github.com/example/repo.git#"+commit]; !ok {
+		t.Errorf("expected git source pinned to commit, got %v", ids)
+	}
+	if ids["https://example.com/tool.tar.gz"] != "sha256:abcd" {
+		t.Errorf("expected http checksum attr, got %v", ids)
+	}
+}
+
+func TestLockPerPlatform(t *testing.T) {
+	src := `bk.export(function(p) return bk.image("alpine:3.19") end)`
+	platforms, err := luavm.ParsePlatforms("linux/amd64,linux/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, _ := newTestGenerator()
+	lock := New()
+	states := evaluate(t, src, &luavm.VMConfig{Platforms: platforms})
+	if err := gen.Lock(context.Background(), lock, states...); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	if len(lock.Images) != 2 {
+		t.Fatalf("expected one image entry per platform, got %v", lock.Images)
+	}
+	seen := map[string]bool{}
+	for _, e := range lock.Images {
+		seen[e.Platform] = true
+	}
+	if !seen["linux/amd64"] || !seen["linux/arm64"] {
+		t.Errorf("expected amd64 and arm64 entries, got %v", lock.Images)
+	}
+}
+
+func TestApplyFrozen(t *testing.T) {
+	lock := New()
+
+	states := evaluate(t, script, nil)
+	err := lock.Apply(true, states...)
+	if err == nil {
+		t.Fatal("expected frozen error")
+	}
+	if !strings.Contains(err.Error(), "build.lua:2: image docker.io/library/alpine:3.19") || !strings.Contains(err.Error(), "run luakit lock") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	states = evaluate(t, script, nil)
+	if err := lock.Apply(false, states...); err != nil {
+		t.Fatalf("expected non-frozen apply to leave sources floating, got %v", err)
+	}
+	if _, ok := sourceIdentifiers(states)["docker-image://docker.io/library/alpine:3.19"]; !ok {
+		t.Error("expected image identifier to be unchanged")
+	}
+}
+
+func TestApplyChangesDigest(t *testing.T) {
+	src := `bk.export(bk.image("alpine:3.19"):run("echo hi"))`
+	gen, _ := newTestGenerator()
+	lock := New()
+	if err := gen.Lock(context.Background(), lock, evaluate(t, src, nil)...); err != nil {
+		t.Fatal(err)
+	}
+
+	floating := evaluate(t, src, nil)
+	pinned := evaluate(t, src, nil)
+	if err := lock.Apply(true, pinned...); err != nil {
+		t.Fatal(err)
+	}
+
+	defFloating, err := dag.Serialize(floating[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defPinned, err := dag.Serialize(pinned[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(defFloating.Def[len(defFloating.Def)-1]) == string(defPinned.Def[len(defPinned.Def)-1]) {
+		t.Error("expected pinning the image to change the exported digest")
+	}
+}
+
+func TestGitSubdirPreserved(t *testing.T) {
+	remote, ref, subdir := gitSource("git://github.com/example/repo.git#v1.0:docs")
+	if remote != "github.com/example/repo.git" || ref != "v1.0" || subdir != ":docs" {
+		t.Errorf("unexpected split %q %q %q", remote, ref, subdir)
+	}
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	lock := &Lockfile{
+		Version: Version,
+		Images: []ImageEntry{
+			{Ref: "b", Platform: "linux/amd64", Digest: "sha256:2"},
+			{Ref: "a", Platform: "linux/arm64", Digest: "sha256:1"},
+			{Ref: "a", Platform: "linux/amd64", Digest: "sha256:0"},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := lock.Write(path); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	got := []string{}
+	for _, e := range loaded.Images {
+		got = append(got, e.Ref+" "+e.Platform)
+	}
+	want := "a linux/amd64,a linux/arm64,b linux/amd64"
+	if strings.Join(got, ",") != want {
+		t.Errorf("expected sorted entries %q, got %q", want, strings.Join(got, ","))
+	}
+}
+
+func TestParseRejectsUnknownVersion(t *testing.T) {
+	_, err := Parse([]byte(`{"version": 99}`))
+	if err == nil || !strings.Contains(err.Error(), "unsupported lockfile version 99") {
+		t.Errorf("expected version error, got %v", err)
+	}
+}
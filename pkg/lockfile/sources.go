@@ -0,0 +1,103 @@
+package lockfile
+
+import (
+	"strings"
+
+	"github.com/containerd/platforms"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/resolver"
+)
+
+const (
+	imagePrefix = "docker-image://"
+	gitPrefix   = "git://"
+)
+
+// sourceNodes returns every SourceOp node reachable from the states, each
+// node once, in walk order.
+func sourceNodes(states ...*dag.State) []*dag.OpNode {
+	visited := make(map[*dag.OpNode]bool)
+	var nodes []*dag.OpNode
+
+	var walk func(*dag.OpNode)
+	walk = func(node *dag.OpNode) {
+		if visited[node] {
+			return
+		}
+		visited[node] = true
+		for _, edge := range node.Inputs() {
+			walk(edge.Node())
+		}
+		if node.Op().GetSource() != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	for _, state := range states {
+		if state != nil {
+			walk(state.Op())
+		}
+	}
+	return nodes
+}
+
+// imageRef returns the reference of an image identifier and whether it is
+// already pinned to a digest.
+func imageRef(identifier string) (string, bool) {
+	ref := strings.TrimPrefix(identifier, imagePrefix)
+	return ref, strings.Contains(ref, "@")
+}
+
+// nodePlatform returns the platform an image source is pulled for.
+func nodePlatform(node *dag.OpNode) ocispec.Platform {
+	p := node.Op().Platform
+	if p == nil {
+		p = node.Platform()
+	}
+	if p == nil {
+		return resolver.DefaultPlatform()
+	}
+	return ocispec.Platform{
+		OS:           p.OS,
+		Architecture: p.Architecture,
+		Variant:      p.Variant,
+	}
+}
+
+func formatPlatform(p ocispec.Platform) string {
+	return platforms.Format(p)
+}
+
+// gitSource splits a git identifier into remote, ref and the optional
+// ":subdir" suffix of the fragment.
+func gitSource(identifier string) (remote, ref, subdir string) {
+	remote = strings.TrimPrefix(identifier, gitPrefix)
+	if i := strings.LastIndex(remote, "#"); i >= 0 {
+		ref = remote[i+1:]
+		remote = remote[:i]
+	}
+	if i := strings.Index(ref, ":"); i >= 0 {
+		subdir = ref[i:]
+		ref = ref[:i]
+	}
+	return remote, ref, subdir
+}
+
+// isCommit reports whether ref is a full hex commit SHA.
+func isCommit(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
+	}
+	for _, c := range ref {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isHTTP(identifier string) bool {
+	return strings.HasPrefix(identifier, "http://") || strings.HasPrefix(identifier, "https://")
+}
@@ -0,0 +1,127 @@
+// Package luafmt formats Lua build scripts in a canonical style.
+//
+// Statements are indented with four spaces. Tables stay on one line when
+// they fit and are split one field per line, with trailing commas, when they
+// do not, when they hold comments, or when the source already started their
+// first field on a new line. Comments, single blank lines, long bracket
+// strings and method chains split across lines are kept.
+package luafmt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Source formats a build script. name is used in parse errors.
+func Source(src []byte, name string) ([]byte, error) {
+	header, body := splitHeader(string(src))
+
+	chunk, err := parse.Parse(strings.NewReader(body), name)
+	if err != nil {
+		return nil, err
+	}
+	scanned := scan(body)
+
+	p := newPrinter(scanned, chunk)
+	for _, s := range chunk {
+		p.stmt(s)
+	}
+	p.flushComments(int(^uint(0) >> 1))
+
+	formatted := strings.TrimLeft(p.buf.String(), "\n")
+	if formatted != "" && !strings.HasSuffix(formatted, "\n") {
+		formatted += "\n"
+	}
+
+	// Check the output is the same program with the same comments, so a
+	// formatter bug can never silently change a script.
+	_, outBody := splitHeader(formatted)
+	outChunk, err := parse.Parse(strings.NewReader(outBody), name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: formatting produced invalid Lua: %w", name, err)
+	}
+	if dump(chunk) != dump(outChunk) {
+		return nil, fmt.Errorf("%s: formatting changed the meaning of the script", name)
+	}
+	if len(scan(outBody).comments) != len(scanned.comments) {
+		return nil, fmt.Errorf("%s: formatting lost comments", name)
+	}
+
+	return []byte(header + formatted), nil
+}
+
+// splitHeader separates the leading `# syntax=` directive lines that the
+// gateway frontend strips, and a first line starting with #, which Lua
+// skips, from the Lua source. The returned body keeps blank lines in their
+// place so line numbers match the original file.
+func splitHeader(src string) (header, body string) {
+	lines := strings.SplitAfter(src, "\n")
+	var kept []string
+	i := 0
+	for ; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" && i < len(lines)-1 {
+			continue
+		}
+		if strings.HasPrefix(line, "# syntax=") || strings.HasPrefix(line, "#syntax=") ||
+			i == 0 && strings.HasPrefix(line, "#") {
+			kept = append(kept, line+"\n")
+			continue
+		}
+		break
+	}
+	if len(kept) == 0 {
+		return "", src
+	}
+	header = strings.Join(kept, "")
+	if i > 0 && strings.TrimSpace(lines[i-1]) == "" {
+		header += "\n"
+	}
+	return header, strings.Repeat("\n", i) + strings.Join(lines[i:], "")
+}
+
+// dump renders a chunk without line numbers, so two chunks compare equal
+// when they only differ in layout.
+func dump(chunk []ast.Stmt) string {
+	var b strings.Builder
+	dumpValue(&b, reflect.ValueOf(chunk))
+	return b.String()
+}
+
+var nodeType = reflect.TypeOf(ast.Node{})
+
+func dumpValue(b *strings.Builder, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		dumpValue(b, v.Elem())
+	case reflect.Struct:
+		if v.Type() == nodeType {
+			return
+		}
+		b.WriteString(v.Type().Name() + "{")
+		for i := 0; i < v.NumField(); i++ {
+			dumpValue(b, v.Field(i))
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	case reflect.Slice:
+		b.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			dumpValue(b, v.Index(i))
+			b.WriteString(",")
+		}
+		b.WriteString("]")
+	case reflect.String:
+		fmt.Fprintf(b, "%q", v.String())
+	default:
+		fmt.Fprint(b, v)
+	}
+}
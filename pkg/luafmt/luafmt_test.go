@@ -0,0 +1,184 @@
+package luafmt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func format(t *testing.T, src string) string {
+	t.Helper()
+	out, err := Source([]byte(src), "build.lua")
+	if err != nil {
+		t.Fatalf("Source failed: %v", err)
+	}
+	again, err := Source(out, "build.lua")
+	if err != nil {
+		t.Fatalf("formatting the output failed: %v\n%s", err, out)
+	}
+	if string(again) != string(out) {
+		t.Errorf("formatting is not idempotent:\n%s\nthen:\n%s", out, again)
+	}
+	return string(out)
+}
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "spacing and quotes",
+			src:  "local base=bk.image 'alpine'\nlocal s='say \"hi\"'..x\n",
+			want: "local base = bk.image(\"alpine\")\nlocal s = 'say \"hi\"' .. x\n",
+		},
+		{
+			name: "short option table stays inline",
+			src:  "local s = base:run(\"make\",{cwd=\"/src\",mounts={bk.cache(\"/c\")}})\n",
+			want: "local s = base:run(\"make\", { cwd = \"/src\", mounts = { bk.cache(\"/c\") } })\n",
+		},
+		{
+			name: "long option table is split",
+			src:  `local out = base:run("make",{cwd="/src",mounts={bk.cache("/root/.cache/go-build"),bk.cache("/go/pkg/mod")}})` + "\n",
+			want: `local out = base:run("make", {
+    cwd = "/src",
+    mounts = { bk.cache("/root/.cache/go-build"), bk.cache("/go/pkg/mod") },
+})
+`,
+		},
+		{
+			name: "split table keeps its layout",
+			src: `local s = base:run("make", {
+  cwd = "/src",
+  mounts = {
+    bk.cache("/c")
+  }
+})
+`,
+			want: `local s = base:run("make", {
+    cwd = "/src",
+    mounts = {
+        bk.cache("/c"),
+    },
+})
+`,
+		},
+		{
+			name: "split command list is packed",
+			src:  "local s = base:run({\n  \"apk\", \"add\", \"git\"\n})\n",
+			want: "local s = base:run({ \"apk\", \"add\", \"git\" })\n",
+		},
+		{
+			name: "comments and blank lines",
+			src: `-- header
+
+
+local a = 1   -- one
+local t = {
+  -- leading
+  x = 1, -- trailing
+
+
+  ["y-z"] = 2,
+}
+`,
+			want: `-- header
+
+local a = 1 -- one
+local t = {
+    -- leading
+    x = 1, -- trailing
+
+    ["y-z"] = 2,
+}
+`,
+		},
+		{
+			name: "method chain",
+			src:  "local s = bk.image(\"alpine\")\n  :run(\"a\")\n  :run(\"b\", {cwd=\"/\"})\n",
+			want: "local s = bk.image(\"alpine\")\n    :run(\"a\")\n    :run(\"b\", { cwd = \"/\" })\n",
+		},
+		{
+			name: "split arguments and concatenation",
+			src:  "local s = base:run(\n  \"a && \" ..\n  \"b\"\n)\n",
+			want: "local s = base:run(\n    \"a && \" ..\n    \"b\"\n)\n",
+		},
+		{
+			name: "long strings are kept",
+			src:  "local s = base:run([[\necho hi\n  indented\n]])\n",
+			want: "local s = base:run([[\necho hi\n  indented\n]])\n",
+		},
+		{
+			name: "blocks",
+			src: `local function f(a, ...)
+if a then return 1 elseif not a then return 2 else
+-- otherwise
+return -(-a) end
+end
+for i=1,3 do print(i) end
+local g = function() end
+`,
+			want: `local function f(a, ...)
+    if a then
+        return 1
+    elseif not a then
+        return 2
+    else
+        -- otherwise
+        return - -a
+    end
+end
+for i = 1, 3 do
+    print(i)
+end
+local g = function() end
+`,
+		},
+		{
+			name: "parentheses",
+			src:  "local x = (a + b) * c ^ (d - e)\nlocal y = (f())\nlocal z = (\"x\"):rep(2)\n",
+			want: "local x = (a + b) * c ^ (d - e)\nlocal y = (f())\nlocal z = (\"x\"):rep(2)\n",
+		},
+		{
+			name: "syntax directive",
+			src:  "# syntax=ghcr.io/kasuboski/luakit:latest\n\nbk.export(bk.image \"alpine\")\n",
+			want: "# syntax=ghcr.io/kasuboski/luakit:latest\n\nbk.export(bk.image(\"alpine\"))\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := format(t, tt.src); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSourceSyntaxError(t *testing.T) {
+	if _, err := Source([]byte("local = 1\n"), "build.lua"); err == nil {
+		t.Error("expected parse error")
+	}
+}
+
+// TestExamples formats every example script to check the output parses to
+// the same program.
+func TestExamples(t *testing.T) {
+	files, err := filepath.Glob("../../examples/*.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			if out := format(t, string(src)); !strings.HasSuffix(out, "\n") {
+				t.Errorf("output does not end with a newline")
+			}
+		})
+	}
+}
@@ -0,0 +1,752 @@
+package luafmt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/gopher-lua/ast"
+)
+
+const indentUnit = "    "
+
+// maxWidth is the longest line a table is kept inline on.
+const maxWidth = 100
+
+var keywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true,
+	"end": true, "false": true, "for": true, "function": true, "goto": true,
+	"if": true, "in": true, "local": true, "nil": true, "not": true,
+	"or": true, "repeat": true, "return": true, "then": true, "true": true,
+	"until": true, "while": true,
+}
+
+// Operator precedence, lowest first.
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precConcat
+	precAdd
+	precMul
+	precUnary
+	precPow
+	precAtom
+)
+
+type printer struct {
+	src *source
+	// tables and chains map AST nodes to what the scan found for them.
+	tables map[*ast.TableExpr]brace
+	colons map[*ast.FuncCallExpr]colon
+
+	buf     strings.Builder
+	indent  int
+	comment int // next comment to print
+	// fresh is set at the start of a block, where blank lines are dropped.
+	fresh bool
+	// last is the source line of the last statement, field or comment.
+	last int
+
+	// inline is set when trying an expression on a single line; failed
+	// records that it needs more than one.
+	inline bool
+	failed bool
+}
+
+func newPrinter(src *source, chunk []ast.Stmt) *printer {
+	p := &printer{
+		src:    src,
+		tables: make(map[*ast.TableExpr]brace),
+		colons: make(map[*ast.FuncCallExpr]colon),
+		fresh:  true,
+	}
+	p.match(chunk)
+	return p
+}
+
+// match pairs table constructors and method calls with the braces and
+// colons found by the scan. Both are visited in source order; if the counts
+// disagree the layout hints are dropped rather than misapplied.
+func (p *printer) match(chunk []ast.Stmt) {
+	var tables []*ast.TableExpr
+	var methods []any
+	walkStmts(chunk, func(n any) {
+		switch n := n.(type) {
+		case *ast.TableExpr:
+			tables = append(tables, n)
+		case *ast.FuncCallExpr:
+			if n.Receiver != nil {
+				methods = append(methods, n)
+			}
+		case *ast.FuncName:
+			if n.Method != "" {
+				methods = append(methods, n)
+			}
+		}
+	})
+	if len(tables) == len(p.src.braces) {
+		for i, t := range tables {
+			p.tables[t] = p.src.braces[i]
+		}
+	}
+	if len(methods) == len(p.src.colons) {
+		for i, n := range methods {
+			if call, ok := n.(*ast.FuncCallExpr); ok {
+				p.colons[call] = p.src.colons[i]
+			}
+		}
+	}
+}
+
+// write appends s, indenting first if at the start of a line.
+func (p *printer) write(s string) {
+	if p.buf.Len() > 0 && strings.HasSuffix(p.buf.String(), "\n") {
+		p.buf.WriteString(strings.Repeat(indentUnit, p.indent))
+	}
+	p.buf.WriteString(s)
+	p.fresh = false
+}
+
+func (p *printer) newline() {
+	if p.inline {
+		p.failed = true
+		return
+	}
+	p.buf.WriteString("\n")
+}
+
+// column is the width of the current line.
+func (p *printer) column() int {
+	s := p.buf.String()
+	col := len(s) - strings.LastIndexByte(s, '\n') - 1
+	if strings.HasSuffix(s, "\n") {
+		col = len(indentUnit) * p.indent
+	}
+	return col
+}
+
+// blankBefore writes an empty line if the source had one between the last
+// item and line.
+func (p *printer) blankBefore(line int) {
+	last := p.last
+	p.last = line
+	if p.fresh || p.inline || p.buf.Len() == 0 || line-1 <= last || !p.src.blank(line-1) {
+		return
+	}
+	if !strings.HasSuffix(p.buf.String(), "\n\n") {
+		p.buf.WriteString("\n")
+	}
+}
+
+// flushComments prints the comments that start before line. A comment that
+// followed code stays at the end of the last printed line.
+func (p *printer) flushComments(line int) {
+	if p.inline {
+		return
+	}
+	for p.comment < len(p.src.comments) && p.src.comments[p.comment].line < line {
+		c := p.src.comments[p.comment]
+		p.comment++
+		out := p.buf.String()
+		if c.trailing && strings.HasSuffix(out, "\n") && !strings.HasSuffix(out, "\n\n") && !strings.Contains(c.text, "\n") {
+			p.buf.Reset()
+			p.buf.WriteString(out[:len(out)-1] + " " + c.text + "\n")
+			continue
+		}
+		p.blankBefore(c.line)
+		p.write(c.text)
+		p.buf.WriteString("\n")
+	}
+}
+
+func (p *printer) block(stmts []ast.Stmt, end int) {
+	p.indent++
+	p.newline()
+	p.fresh = true
+	for _, s := range stmts {
+		p.stmt(s)
+	}
+	p.flushComments(end)
+	p.indent--
+	p.fresh = false
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	p.flushComments(s.Line())
+	p.blankBefore(s.Line())
+
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		if needsSemicolon(s.Lhs[0]) {
+			p.write(";")
+		}
+		p.exprList(s.Lhs)
+		p.write(" = ")
+		p.exprList(s.Rhs)
+	case *ast.LocalAssignStmt:
+		if s.LastLine() != 0 && len(s.Exprs) == 1 {
+			if fn, ok := s.Exprs[0].(*ast.FunctionExpr); ok {
+				p.write("local function " + s.Names[0])
+				p.function(fn)
+				break
+			}
+		}
+		p.write("local " + strings.Join(s.Names, ", "))
+		if len(s.Exprs) > 0 {
+			p.write(" = ")
+			p.exprList(s.Exprs)
+		}
+	case *ast.FuncCallStmt:
+		if needsSemicolon(s.Expr) {
+			p.write(";")
+		}
+		p.expr(s.Expr)
+	case *ast.DoBlockStmt:
+		p.write("do")
+		p.block(s.Stmts, s.LastLine())
+		p.write("end")
+	case *ast.WhileStmt:
+		p.write("while ")
+		p.expr(s.Condition)
+		p.write(" do")
+		p.block(s.Stmts, s.LastLine())
+		p.write("end")
+	case *ast.RepeatStmt:
+		p.write("repeat")
+		p.block(s.Stmts, s.LastLine())
+		p.write("until ")
+		p.expr(s.Condition)
+	case *ast.IfStmt:
+		p.ifStmt(s, s.LastLine())
+	case *ast.NumberForStmt:
+		p.write("for " + s.Name + " = ")
+		p.expr(s.Init)
+		p.write(", ")
+		p.expr(s.Limit)
+		if s.Step != nil {
+			p.write(", ")
+			p.expr(s.Step)
+		}
+		p.write(" do")
+		p.block(s.Stmts, s.LastLine())
+		p.write("end")
+	case *ast.GenericForStmt:
+		p.write("for " + strings.Join(s.Names, ", ") + " in ")
+		p.exprList(s.Exprs)
+		p.write(" do")
+		p.block(s.Stmts, s.LastLine())
+		p.write("end")
+	case *ast.FuncDefStmt:
+		p.write("function ")
+		if s.Name.Func != nil {
+			p.expr(s.Name.Func)
+		} else {
+			p.prefix(s.Name.Receiver)
+			p.write(":" + s.Name.Method)
+		}
+		p.function(s.Func)
+	case *ast.ReturnStmt:
+		p.write("return")
+		if len(s.Exprs) > 0 {
+			p.write(" ")
+			p.exprList(s.Exprs)
+		}
+	case *ast.BreakStmt:
+		p.write("break")
+	case *ast.LabelStmt:
+		p.write("::" + s.Name + "::")
+	case *ast.GotoStmt:
+		p.write("goto " + s.Label)
+	default:
+		panic(fmt.Sprintf("luafmt: unexpected statement %T", s))
+	}
+	p.newline()
+}
+
+// ifStmt prints an if statement. An else block holding only an if
+// statement without its own end is an elseif.
+func (p *printer) ifStmt(s *ast.IfStmt, end int) {
+	p.write("if ")
+	for {
+		p.expr(s.Condition)
+		p.write(" then")
+		if len(s.Else) == 1 {
+			if next, ok := s.Else[0].(*ast.IfStmt); ok && next.LastLine() == 0 {
+				p.block(s.Then, next.Line())
+				p.write("elseif ")
+				s = next
+				continue
+			}
+		}
+		if len(s.Else) == 0 {
+			p.block(s.Then, end)
+			break
+		}
+		elseLine := p.src.elseBefore(s.Else[0].Line())
+		p.block(s.Then, elseLine)
+		p.write("else")
+		p.block(s.Else, end)
+		break
+	}
+	p.write("end")
+}
+
+// function prints a parameter list and body. The function keyword and name
+// are written by the caller.
+func (p *printer) function(fn *ast.FunctionExpr) {
+	params := fn.ParList.Names
+	if fn.ParList.HasVargs {
+		params = append(params[:len(params):len(params)], "...")
+	}
+	p.write("(" + strings.Join(params, ", ") + ")")
+	if len(fn.Stmts) == 0 && !p.hasComments(fn.Line(), fn.LastLine()) {
+		p.write(" end")
+		return
+	}
+	p.block(fn.Stmts, fn.LastLine())
+	p.write("end")
+}
+
+func (p *printer) hasComments(from, to int) bool {
+	for _, c := range p.src.comments[p.comment:] {
+		if c.line >= from && c.line < to {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) exprList(exprs []ast.Expr) {
+	for i, e := range exprs {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expr(e)
+	}
+}
+
+func (p *printer) expr(e ast.Expr) {
+	switch e := e.(type) {
+	case *ast.NilExpr:
+		p.write("nil")
+	case *ast.TrueExpr:
+		p.write("true")
+	case *ast.FalseExpr:
+		p.write("false")
+	case *ast.NumberExpr:
+		p.write(e.Value)
+	case *ast.StringExpr:
+		p.str(e.Value)
+	case *ast.Comma3Expr:
+		if e.AdjustRet {
+			p.write("(...)")
+		} else {
+			p.write("...")
+		}
+	case *ast.IdentExpr:
+		p.write(e.Value)
+	case *ast.AttrGetExpr:
+		p.prefix(e.Object)
+		if key, ok := e.Key.(*ast.StringExpr); ok && isIdentifier(key.Value) {
+			p.write("." + key.Value)
+		} else {
+			p.write("[")
+			p.expr(e.Key)
+			p.write("]")
+		}
+	case *ast.TableExpr:
+		p.table(e)
+	case *ast.FuncCallExpr:
+		p.call(e)
+	case *ast.FunctionExpr:
+		p.write("function")
+		p.function(e)
+	case *ast.LogicalOpExpr:
+		p.binary(e.Lhs, e.Operator, e.Rhs)
+	case *ast.RelationalOpExpr:
+		p.binary(e.Lhs, e.Operator, e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		p.binary(e.Lhs, e.Operator, e.Rhs)
+	case *ast.StringConcatOpExpr:
+		p.concat(e)
+	case *ast.UnaryMinusOpExpr:
+		p.write("-")
+		if _, ok := e.Expr.(*ast.UnaryMinusOpExpr); ok {
+			p.write(" ")
+		}
+		p.operand(e.Expr, precUnary, false)
+	case *ast.UnaryNotOpExpr:
+		p.write("not ")
+		p.operand(e.Expr, precUnary, false)
+	case *ast.UnaryLenOpExpr:
+		p.write("#")
+		p.operand(e.Expr, precUnary, false)
+	default:
+		panic(fmt.Sprintf("luafmt: unexpected expression %T", e))
+	}
+}
+
+// prefix prints e where Lua requires a prefix expression, such as before
+// an index or call.
+func (p *printer) prefix(e ast.Expr) {
+	if isPrefix(e) {
+		p.expr(e)
+		return
+	}
+	p.write("(")
+	p.expr(e)
+	p.write(")")
+}
+
+func isPrefix(e ast.Expr) bool {
+	switch e := e.(type) {
+	case *ast.IdentExpr, *ast.AttrGetExpr:
+		return true
+	case *ast.FuncCallExpr:
+		return true
+	case *ast.Comma3Expr:
+		return e.AdjustRet
+	}
+	return false
+}
+
+// needsSemicolon reports whether a statement starting with e would begin
+// with a parenthesis, which Lua could read as a call on the line above.
+func needsSemicolon(e ast.Expr) bool {
+	for {
+		switch x := e.(type) {
+		case *ast.AttrGetExpr:
+			e = x.Object
+		case *ast.FuncCallExpr:
+			if x.AdjustRet {
+				return true
+			}
+			if x.Receiver != nil {
+				e = x.Receiver
+			} else {
+				e = x.Func
+			}
+		default:
+			return !isPrefix(e) || isAdjusted(e)
+		}
+	}
+}
+
+func isAdjusted(e ast.Expr) bool {
+	c, ok := e.(*ast.Comma3Expr)
+	return ok && c.AdjustRet
+}
+
+// call prints a function or method call. A chain of method calls is split
+// one call per line when the source split any of them.
+func (p *printer) call(e *ast.FuncCallExpr) {
+	if e.AdjustRet {
+		p.write("(")
+		defer p.write(")")
+	}
+	if e.Receiver == nil {
+		p.prefix(e.Func)
+		p.args(e.Args, e.Func.Line())
+		return
+	}
+
+	var links []*ast.FuncCallExpr
+	var base ast.Expr = e
+	for {
+		link, ok := base.(*ast.FuncCallExpr)
+		if !ok || link.Receiver == nil || (link.AdjustRet && link != e) {
+			break
+		}
+		links = append(links, link)
+		base = link.Receiver
+	}
+	broken := false
+	for _, link := range links {
+		broken = broken || p.colons[link].firstOnLine
+	}
+
+	p.prefix(base)
+	if broken {
+		p.indent++
+		defer func() { p.indent-- }()
+	}
+	for i := len(links) - 1; i >= 0; i-- {
+		if broken {
+			p.newline()
+		}
+		p.write(":" + links[i].Method)
+		p.args(links[i].Args, p.colons[links[i]].line)
+	}
+}
+
+// args prints call arguments. When the source started them on a line
+// after the call, each goes on its own line.
+func (p *printer) args(args []ast.Expr, line int) {
+	p.write("(")
+	if len(args) == 0 || p.inline || line == 0 || args[0].Line() <= line {
+		p.exprList(args)
+		p.write(")")
+		return
+	}
+	p.indent++
+	p.fresh = true
+	for i, arg := range args {
+		if i > 0 {
+			p.write(",")
+		}
+		p.newline()
+		p.flushComments(arg.Line())
+		p.blankBefore(arg.Line())
+		p.expr(arg)
+	}
+	p.indent--
+	p.newline()
+	p.write(")")
+}
+
+func precedence(e ast.Expr) (int, bool) {
+	switch e := e.(type) {
+	case *ast.LogicalOpExpr:
+		return operatorPrecedence(e.Operator)
+	case *ast.RelationalOpExpr:
+		return operatorPrecedence(e.Operator)
+	case *ast.ArithmeticOpExpr:
+		return operatorPrecedence(e.Operator)
+	case *ast.StringConcatOpExpr:
+		return precConcat, true
+	case *ast.UnaryMinusOpExpr, *ast.UnaryNotOpExpr, *ast.UnaryLenOpExpr:
+		return precUnary, false
+	}
+	return precAtom, false
+}
+
+func operatorPrecedence(op string) (prec int, right bool) {
+	switch op {
+	case "or":
+		return precOr, false
+	case "and":
+		return precAnd, false
+	case "+", "-":
+		return precAdd, false
+	case "*", "/", "%":
+		return precMul, false
+	case "^":
+		return precPow, true
+	}
+	return precCompare, false
+}
+
+func (p *printer) binary(lhs ast.Expr, op string, rhs ast.Expr) {
+	prec, right := operatorPrecedence(op)
+	p.operand(lhs, prec, right)
+	p.write(" " + op + " ")
+	p.operand(rhs, prec, !right)
+}
+
+// operand prints an operand of an operator with the given precedence,
+// adding parentheses where leaving them out would change the grouping.
+// tight is set for the side that binds an equal-precedence operator.
+func (p *printer) operand(e ast.Expr, prec int, tight bool) {
+	own, _ := precedence(e)
+	if own < prec || own == prec && tight {
+		p.write("(")
+		p.expr(e)
+		p.write(")")
+		return
+	}
+	p.expr(e)
+}
+
+// concat prints a chain of .. operators, keeping operands that started a
+// new line in the source on their own line.
+func (p *printer) concat(e *ast.StringConcatOpExpr) {
+	var operands []ast.Expr
+	var rest ast.Expr = e
+	for {
+		c, ok := rest.(*ast.StringConcatOpExpr)
+		if !ok {
+			break
+		}
+		operands = append(operands, c.Lhs)
+		rest = c.Rhs
+	}
+	operands = append(operands, rest)
+
+	// A chain that starts its own line is already at the indent its
+	// continuation lines use.
+	aligned := strings.HasSuffix(p.buf.String(), "\n")
+	indented := false
+	for i, operand := range operands {
+		if i > 0 {
+			if !p.inline && operand.Line() > operands[i-1].Line() {
+				if !aligned && !indented {
+					p.indent++
+					indented = true
+				}
+				p.write(" ..")
+				p.newline()
+			} else {
+				p.write(" .. ")
+			}
+		}
+		p.operand(operand, precConcat, i < len(operands)-1)
+	}
+	if indented {
+		p.indent--
+	}
+}
+
+// table prints a table constructor. It stays on one line unless the source
+// started its first field on a new line, it holds comments or multi-line
+// values, or it would not fit in maxWidth.
+func (p *printer) table(t *ast.TableExpr) {
+	b, known := p.tables[t]
+	multiline := known && (p.src.commentsIn(b.open, b.close) ||
+		len(t.Fields) > 0 && fieldLine(t.Fields[0]) > b.openLine && !isScalarList(t))
+
+	if len(t.Fields) == 0 && !multiline {
+		p.write("{}")
+		return
+	}
+	if !multiline {
+		trial := &printer{src: p.src, tables: p.tables, colons: p.colons, inline: true}
+		trial.tableInline(t)
+		if trial.failed || p.column()+len(trial.buf.String()) > maxWidth {
+			multiline = true
+		} else {
+			p.write(trial.buf.String())
+			return
+		}
+	}
+	if p.inline {
+		p.failed = true
+		return
+	}
+
+	end := b.closeLine
+	if !known {
+		end = 0
+	}
+	p.write("{")
+	p.indent++
+	p.newline()
+	p.fresh = true
+	for _, f := range t.Fields {
+		p.flushComments(fieldLine(f))
+		p.blankBefore(fieldLine(f))
+		p.field(f)
+		p.write(",")
+		p.newline()
+	}
+	if end > 0 {
+		p.flushComments(end)
+	}
+	p.indent--
+	p.write("}")
+}
+
+// isScalarList reports whether t is a plain list of constants, such as a
+// command. Those are packed onto one line whenever they fit.
+func isScalarList(t *ast.TableExpr) bool {
+	for _, f := range t.Fields {
+		if f.Key != nil {
+			return false
+		}
+		switch f.Value.(type) {
+		case *ast.StringExpr, *ast.NumberExpr, *ast.TrueExpr, *ast.FalseExpr, *ast.NilExpr, *ast.IdentExpr:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (p *printer) tableInline(t *ast.TableExpr) {
+	p.write("{ ")
+	for i, f := range t.Fields {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.field(f)
+	}
+	p.write(" }")
+}
+
+func (p *printer) field(f *ast.Field) {
+	if f.Key != nil {
+		if key, ok := f.Key.(*ast.StringExpr); ok && isIdentifier(key.Value) {
+			p.write(key.Value)
+		} else {
+			p.write("[")
+			p.expr(f.Key)
+			p.write("]")
+		}
+		p.write(" = ")
+	}
+	p.expr(f.Value)
+}
+
+func fieldLine(f *ast.Field) int {
+	if f.Key != nil && f.Key.Line() > 0 {
+		return f.Key.Line()
+	}
+	return f.Value.Line()
+}
+
+// str prints a string literal. Strings written with long brackets keep
+// that form; others use double quotes unless the value contains them.
+func (p *printer) str(s string) {
+	if raw, ok := p.src.longStrings[s]; ok {
+		if p.inline && strings.Contains(raw, "\n") {
+			p.failed = true
+		}
+		p.write(raw)
+		return
+	}
+	p.write(quote(s))
+}
+
+func quote(s string) string {
+	q := byte('"')
+	if strings.Contains(s, `"`) && !strings.Contains(s, "'") {
+		q = '\''
+	}
+
+	var b strings.Builder
+	b.WriteByte(q)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03d`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+func isIdentifier(s string) bool {
+	if s == "" || keywords[s] {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,211 @@
+package luafmt
+
+import (
+	"sort"
+	"strings"
+)
+
+// comment is a Lua comment. The AST does not keep comments, so they are
+// collected by a separate scan and reattached by line.
+type comment struct {
+	line     int
+	text     string
+	offset   int
+	trailing bool // code precedes the comment on its line
+}
+
+// brace is a matched pair of table braces.
+type brace struct {
+	openLine  int
+	closeLine int
+	open      int
+	close     int
+}
+
+// colon is the ':' of a method call or method definition.
+type colon struct {
+	line        int
+	firstOnLine bool
+}
+
+// source holds what the formatter needs from the raw text that the AST does
+// not record.
+type source struct {
+	comments []comment
+	braces   []brace
+	colons   []colon
+	// elses are the lines of else keywords, in order.
+	elses []int
+	// longStrings maps the value of each long bracket string to its text,
+	// so such strings can be written back in the same form.
+	longStrings map[string]string
+	code        map[int]bool
+	commented   map[int]bool
+}
+
+// blank reports whether line holds neither code nor a comment.
+func (s *source) blank(line int) bool {
+	return line > 0 && !s.code[line] && !s.commented[line]
+}
+
+// elseBefore returns the line of the last else keyword at or before line.
+func (s *source) elseBefore(line int) int {
+	i := sort.SearchInts(s.elses, line+1)
+	if i == 0 {
+		return 0
+	}
+	return s.elses[i-1]
+}
+
+// commentsIn reports whether a comment starts between the offsets.
+func (s *source) commentsIn(start, end int) bool {
+	for _, c := range s.comments {
+		if c.offset > start && c.offset < end {
+			return true
+		}
+	}
+	return false
+}
+
+// scan tokenizes src just far enough to find comments, braces, method
+// colons, else keywords and long strings. src must already parse.
+func scan(src string) *source {
+	s := &source{
+		longStrings: make(map[string]string),
+		code:        make(map[int]bool),
+		commented:   make(map[int]bool),
+	}
+	var open []int
+	line := 1
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			end := len(src)
+			if level, ok := longBracket(src[i+2:]); ok {
+				end = closeLongBracket(src, i+2+level+2, level)
+			} else if n := strings.IndexByte(src[i:], '\n'); n >= 0 {
+				end = i + n
+			}
+			text := strings.TrimRight(src[i:end], " \t\r")
+			s.comments = append(s.comments, comment{
+				line:     line,
+				text:     text,
+				offset:   i,
+				trailing: s.code[line],
+			})
+			for n := line + strings.Count(text, "\n"); line <= n; line++ {
+				s.commented[line] = true
+			}
+			line--
+			i = end
+		case c == '"' || c == '\'':
+			s.code[line] = true
+			i++
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' {
+					i++
+				}
+				if i < len(src) && src[i] == '\n' {
+					line++
+					s.code[line] = true
+				}
+				i++
+			}
+			i++
+		case c == '[':
+			level, ok := longBracket(src[i:])
+			if !ok {
+				s.code[line] = true
+				i++
+				continue
+			}
+			end := closeLongBracket(src, i+level+2, level)
+			raw := src[i:end]
+			value := raw[level+2 : len(raw)-level-2]
+			if strings.HasPrefix(value, "\r\n") {
+				value = value[2:]
+			} else if strings.HasPrefix(value, "\n") {
+				value = value[1:]
+			}
+			s.longStrings[value] = raw
+			for n := line + strings.Count(raw, "\n"); line <= n; line++ {
+				s.code[line] = true
+			}
+			line--
+			i = end
+		case c == '{':
+			s.code[line] = true
+			open = append(open, len(s.braces))
+			s.braces = append(s.braces, brace{openLine: line, open: i})
+			i++
+		case c == '}':
+			s.code[line] = true
+			if n := len(open); n > 0 {
+				b := &s.braces[open[n-1]]
+				b.closeLine = line
+				b.close = i
+				open = open[:n-1]
+			}
+			i++
+		case c == ':':
+			if strings.HasPrefix(src[i:], "::") {
+				s.code[line] = true
+				i += 2
+				continue
+			}
+			s.colons = append(s.colons, colon{line: line, firstOnLine: !s.code[line]})
+			s.code[line] = true
+			i++
+		case isWordByte(c):
+			start := i
+			for i < len(src) && isWordByte(src[i]) {
+				i++
+			}
+			if src[start:i] == "else" {
+				s.elses = append(s.elses, line)
+			}
+			s.code[line] = true
+		default:
+			s.code[line] = true
+			i++
+		}
+	}
+	return s
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.'
+}
+
+// longBracket reports whether s starts with an opening long bracket such as
+// [[ or [==[, and its level.
+func longBracket(s string) (int, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return 0, false
+	}
+	level := 0
+	for level+1 < len(s) && s[level+1] == '=' {
+		level++
+	}
+	if level+1 < len(s) && s[level+1] == '[' {
+		return level, true
+	}
+	return 0, false
+}
+
+// closeLongBracket returns the offset just past the closing bracket of the
+// given level, searching from start.
+func closeLongBracket(src string, start, level int) int {
+	closing := "]" + strings.Repeat("=", level) + "]"
+	if n := strings.Index(src[start:], closing); n >= 0 {
+		return start + n + len(closing)
+	}
+	return len(src)
+}
@@ -0,0 +1,112 @@
+package luafmt
+
+import "github.com/yuin/gopher-lua/ast"
+
+// walkStmts calls visit for every expression, table field and function name
+// in stmts, in the order they appear in the source.
+func walkStmts(stmts []ast.Stmt, visit func(any)) {
+	for _, s := range stmts {
+		walkStmt(s, visit)
+	}
+}
+
+func walkStmt(s ast.Stmt, visit func(any)) {
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		walkExprs(s.Lhs, visit)
+		walkExprs(s.Rhs, visit)
+	case *ast.LocalAssignStmt:
+		walkExprs(s.Exprs, visit)
+	case *ast.FuncCallStmt:
+		walkExpr(s.Expr, visit)
+	case *ast.DoBlockStmt:
+		walkStmts(s.Stmts, visit)
+	case *ast.WhileStmt:
+		walkExpr(s.Condition, visit)
+		walkStmts(s.Stmts, visit)
+	case *ast.RepeatStmt:
+		walkStmts(s.Stmts, visit)
+		walkExpr(s.Condition, visit)
+	case *ast.IfStmt:
+		walkExpr(s.Condition, visit)
+		walkStmts(s.Then, visit)
+		walkStmts(s.Else, visit)
+	case *ast.NumberForStmt:
+		walkExpr(s.Init, visit)
+		walkExpr(s.Limit, visit)
+		if s.Step != nil {
+			walkExpr(s.Step, visit)
+		}
+		walkStmts(s.Stmts, visit)
+	case *ast.GenericForStmt:
+		walkExprs(s.Exprs, visit)
+		walkStmts(s.Stmts, visit)
+	case *ast.FuncDefStmt:
+		if s.Name.Func != nil {
+			walkExpr(s.Name.Func, visit)
+		} else {
+			walkExpr(s.Name.Receiver, visit)
+		}
+		visit(s.Name)
+		walkExpr(s.Func, visit)
+	case *ast.ReturnStmt:
+		walkExprs(s.Exprs, visit)
+	}
+}
+
+func walkExprs(exprs []ast.Expr, visit func(any)) {
+	for _, e := range exprs {
+		walkExpr(e, visit)
+	}
+}
+
+// walkExpr visits each node where its first own token appears: a table at
+// its brace, a method call at its colon.
+func walkExpr(e ast.Expr, visit func(any)) {
+	switch e := e.(type) {
+	case *ast.AttrGetExpr:
+		walkExpr(e.Object, visit)
+		visit(e)
+		walkExpr(e.Key, visit)
+	case *ast.TableExpr:
+		visit(e)
+		for _, f := range e.Fields {
+			if f.Key != nil {
+				walkExpr(f.Key, visit)
+			}
+			walkExpr(f.Value, visit)
+		}
+	case *ast.FuncCallExpr:
+		if e.Receiver != nil {
+			walkExpr(e.Receiver, visit)
+			visit(e)
+		} else {
+			visit(e)
+			walkExpr(e.Func, visit)
+		}
+		walkExprs(e.Args, visit)
+	case *ast.LogicalOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.RelationalOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.StringConcatOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.ArithmeticOpExpr:
+		walkExpr(e.Lhs, visit)
+		walkExpr(e.Rhs, visit)
+	case *ast.UnaryMinusOpExpr:
+		walkExpr(e.Expr, visit)
+	case *ast.UnaryNotOpExpr:
+		walkExpr(e.Expr, visit)
+	case *ast.UnaryLenOpExpr:
+		walkExpr(e.Expr, visit)
+	case *ast.FunctionExpr:
+		visit(e)
+		walkStmts(e.Stmts, visit)
+	default:
+		visit(e)
+	}
+}
@@ -1,9 +1,13 @@
 package luavm
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"maps"
+	"slices"
 	"strings"
+	"time"
 	"unicode"
 
 	pb "github.com/moby/buildkit/solver/pb"
@@ -18,6 +22,37 @@ type vmData struct {
 	L                   *lua.LState
 	exportedState       *dag.State
 	exportedImageConfig *dockerspec.DockerOCIImage
+	inheritImageConfig  bool
+	annotations         Annotations
+	sourceDateEpoch     *time.Time
+	targets             []*buildTarget
+	platforms           []*pb.Platform
+	exportedPlatforms   []*PlatformState
+	args                map[string]string
+	declaredArgs        []ArgSpec
+	contexts            map[string]string
+	contextStates       map[string]*dag.State
+	// sourceFiles holds the script and the modules it required, by file
+	// name, for source maps and image history.
+	sourceFiles map[string][]byte
+	// envReads lists the variables read with os.getenv, in read order.
+	envReads      []string
+	epochOverride *time.Time
+	// contextFS is the build context bk.read_file, bk.exists and bk.glob
+	// read. fileReads lists the files read and fileLookups the paths and
+	// patterns looked up, in call order.
+	contextFS   fs.FS
+	fileReads   []string
+	fileLookups []string
+
+	// Evaluation limits, see VMConfig.
+	ctx           context.Context
+	timeout       time.Duration
+	callStackSize int
+	registrySize  int
+	maxNodes      int
+	nodes         map[*dag.OpNode]struct{}
+	nodeLimitHit  bool
 }
 
 func registerAPI(L *lua.LState) {
@@ -30,6 +65,7 @@ func registerAPI(L *lua.LState) {
 	L.SetField(bk, "http", L.NewFunction(bkHTTP))
 	L.SetField(bk, "https", L.NewFunction(bkHTTPS))
 	L.SetField(bk, "export", L.NewFunction(bkExport))
+	L.SetField(bk, "target", L.NewFunction(bkTarget))
 	L.SetField(bk, "cache", L.NewFunction(bkCache))
 	L.SetField(bk, "secret", L.NewFunction(bkSecret))
 	L.SetField(bk, "ssh", L.NewFunction(bkSSH))
@@ -38,6 +74,12 @@ func registerAPI(L *lua.LState) {
 	L.SetField(bk, "merge", L.NewFunction(bkMerge))
 	L.SetField(bk, "diff", L.NewFunction(bkDiff))
 	L.SetField(bk, "platform", L.NewFunction(bkPlatform))
+	L.SetField(bk, "arg", L.NewFunction(bkArg))
+	L.SetField(bk, "set_epoch", L.NewFunction(bkSetEpoch))
+	L.SetField(bk, "context", L.NewFunction(bkContext))
+	L.SetField(bk, "read_file", L.NewFunction(bkReadFile))
+	L.SetField(bk, "exists", L.NewFunction(bkExists))
+	L.SetField(bk, "glob", L.NewFunction(bkGlob))
 
 	L.SetGlobal("bk", bk)
 }
@@ -201,8 +243,6 @@ func bkHTTPImpl(L *lua.LState, scheme string) int {
 }
 
 func bkExport(L *lua.LState) int {
-	state := checkState(L, 1)
-
 	data := getVMData(L)
 	if data.exportedState != nil {
 		L.RaiseError("bk.export: already called once")
@@ -210,19 +250,41 @@ func bkExport(L *lua.LState) int {
 	}
 
 	var exportOpts *lua.LTable
+	data.inheritImageConfig = true
 	if L.GetTop() >= 2 {
 		exportOpts = L.CheckTable(2)
+		if inherit := L.GetField(exportOpts, "inherit"); inherit.Type() == lua.LTBool {
+			data.inheritImageConfig = lua.LVAsBool(inherit)
+		}
+		data.annotations = parseAnnotations(L, exportOpts)
 	}
 
-	data.exportedState = state
+	switch arg := L.Get(1); arg.Type() {
+	case lua.LTFunction:
+		data.exportedPlatforms = exportPlatformFunc(L, arg.(*lua.LFunction), data.platforms)
+	case lua.LTTable:
+		data.exportedPlatforms = exportPlatformTable(L, arg.(*lua.LTable), data.platforms)
+	default:
+		data.exportedState = checkState(L, 1)
+	}
+
+	if exportOpts != nil && L.GetField(exportOpts, "platform") != lua.LNil {
+		data.exportedPlatforms = exportPlatformOption(L, data.exportedState, exportOpts, data.platforms)
+	} else if data.exportedState != nil && len(data.platforms) > 0 {
+		data.exportedPlatforms = exportRequestedPlatforms(data.exportedState, data.platforms)
+	}
 
-	if exportOpts != nil {
-		imageConfig := parseExportOptions(L, exportOpts)
-		if imageConfig != nil {
-			data.exportedImageConfig = imageConfig
+	if len(data.exportedPlatforms) > 0 {
+		for _, ps := range data.exportedPlatforms {
+			ps.ImageConfig = platformImageConfig(exportImageConfig(L, ps.State, exportOpts), ps.Platform)
 		}
+		data.exportedState = data.exportedPlatforms[0].State
+		data.exportedImageConfig = data.exportedPlatforms[0].ImageConfig
+		return 0
 	}
 
+	data.exportedImageConfig = exportImageConfig(L, data.exportedState, exportOpts)
+
 	return 0
 }
 
@@ -238,13 +300,50 @@ func getVMData(L *lua.LState) *vmData {
 	return ud.Value.(*vmData)
 }
 
-func parseExportOptions(L *lua.LState, opts *lua.LTable) *dockerspec.DockerOCIImage {
+func newImageConfig() *dockerspec.DockerOCIImage {
 	config := &dockerspec.DockerOCIImage{}
-	config.OS = "linux"
-	config.Architecture = "amd64"
 	config.Config.Env = []string{}
 	config.Config.ExposedPorts = make(map[string]struct{})
 	config.Config.Labels = make(map[string]string)
+	return config
+}
+
+// exportImageConfig builds the exported image config from the config set on
+// the state with state:env, state:workdir and friends, overridden by the
+// bk.export options. It returns nil when neither is set.
+func exportImageConfig(L *lua.LState, state *dag.State, opts *lua.LTable) *dockerspec.DockerOCIImage {
+	cfg := state.ImageConfig()
+	if cfg == nil || cfg.Config == nil {
+		if opts == nil {
+			return nil
+		}
+		return parseExportOptions(L, opts)
+	}
+
+	config := newImageConfig()
+	stateConfig := cfg.Config.Config
+	config.Config.Env = append(config.Config.Env, stateConfig.Env...)
+	config.Config.WorkingDir = stateConfig.WorkingDir
+	config.Config.User = stateConfig.User
+	config.Config.Entrypoint = slices.Clone(stateConfig.Entrypoint)
+	config.Config.Cmd = slices.Clone(stateConfig.Cmd)
+	maps.Copy(config.Config.Labels, stateConfig.Labels)
+
+	if opts != nil {
+		applyExportOptions(L, config, opts)
+	}
+	return config
+}
+
+func parseExportOptions(L *lua.LState, opts *lua.LTable) *dockerspec.DockerOCIImage {
+	config := newImageConfig()
+	applyExportOptions(L, config, opts)
+	return config
+}
+
+// applyExportOptions sets the bk.export options on config. Env entries and
+// labels replace those with the same key.
+func applyExportOptions(L *lua.LState, config *dockerspec.DockerOCIImage, opts *lua.LTable) {
 
 	if entrypointVal := L.GetField(opts, "entrypoint"); entrypointVal.Type() == lua.LTTable {
 		entrypointTable := entrypointVal.(*lua.LTable)
@@ -258,8 +357,9 @@ func parseExportOptions(L *lua.LState, opts *lua.LTable) *dockerspec.DockerOCIIm
 
 	if envVal := L.GetField(opts, "env"); envVal.Type() == lua.LTTable {
 		envTable := envVal.(*lua.LTable)
-		env := parseEnvTable(L, envTable)
-		config.Config.Env = append(config.Config.Env, env...)
+		for _, kv := range parseEnvTable(L, envTable) {
+			config.Config.Env = dag.SetEnv(config.Config.Env, kv)
+		}
 	}
 
 	if workdirVal := L.GetField(opts, "workdir"); workdirVal.Type() == lua.LTString {
@@ -296,7 +396,122 @@ func parseExportOptions(L *lua.LState, opts *lua.LTable) *dockerspec.DockerOCIIm
 		config.Variant = variantVal.String()
 	}
 
-	return config
+	if osVersionVal := L.GetField(opts, "os_version"); osVersionVal.Type() == lua.LTString {
+		config.OSVersion = osVersionVal.String()
+	}
+
+	if osFeaturesVal := L.GetField(opts, "os_features"); osFeaturesVal.Type() == lua.LTTable {
+		config.OSFeatures = luaTableToStringSlice(L, osFeaturesVal.(*lua.LTable))
+	}
+
+	if volumesVal := L.GetField(opts, "volumes"); volumesVal.Type() == lua.LTTable {
+		if config.Config.Volumes == nil {
+			config.Config.Volumes = make(map[string]struct{})
+		}
+		for _, volume := range luaTableToStringSlice(L, volumesVal.(*lua.LTable)) {
+			config.Config.Volumes[volume] = struct{}{}
+		}
+	}
+
+	if stopSignalVal := L.GetField(opts, "stop_signal"); stopSignalVal.Type() == lua.LTString {
+		config.Config.StopSignal = stopSignalVal.String()
+	}
+
+	if shellVal := L.GetField(opts, "shell"); shellVal.Type() == lua.LTTable {
+		config.Config.Shell = luaTableToStringSlice(L, shellVal.(*lua.LTable))
+	}
+
+	if onbuildVal := L.GetField(opts, "onbuild"); onbuildVal.Type() == lua.LTTable {
+		config.Config.OnBuild = luaTableToStringSlice(L, onbuildVal.(*lua.LTable))
+	}
+
+	if healthcheckVal := L.GetField(opts, "healthcheck"); healthcheckVal.Type() == lua.LTTable {
+		config.Config.Healthcheck = parseHealthcheck(L, healthcheckVal.(*lua.LTable))
+	}
+
+	switch createdVal := L.GetField(opts, "created"); createdVal.Type() {
+	case lua.LTString:
+		created, err := time.Parse(time.RFC3339, createdVal.String())
+		if err != nil {
+			L.RaiseError("bk.export: created must be an RFC 3339 timestamp: %v", err)
+		}
+		created = created.UTC()
+		config.Created = &created
+	case lua.LTNumber:
+		created := time.Unix(int64(createdVal.(lua.LNumber)), 0).UTC()
+		config.Created = &created
+	}
+}
+
+// parseHealthcheck reads the healthcheck export option. A string test is run
+// with the image's shell, like HEALTHCHECK CMD in a Dockerfile; a list is
+// run directly unless it starts with CMD, CMD-SHELL or NONE.
+func parseHealthcheck(L *lua.LState, table *lua.LTable) *dockerspec.HealthcheckConfig {
+	hc := &dockerspec.HealthcheckConfig{}
+
+	switch testVal := L.GetField(table, "test"); testVal.Type() {
+	case lua.LTString:
+		hc.Test = []string{"CMD-SHELL", testVal.String()}
+	case lua.LTTable:
+		hc.Test = luaTableToStringSlice(L, testVal.(*lua.LTable))
+		if len(hc.Test) > 0 && !slices.Contains([]string{"CMD", "CMD-SHELL", "NONE"}, hc.Test[0]) {
+			hc.Test = append([]string{"CMD"}, hc.Test...)
+		}
+	case lua.LTNil:
+		L.RaiseError("bk.export: healthcheck.test is required")
+	default:
+		L.RaiseError("bk.export: healthcheck.test must be a string or a table")
+	}
+
+	hc.Interval = parseHealthcheckDuration(L, table, "interval")
+	hc.Timeout = parseHealthcheckDuration(L, table, "timeout")
+	hc.StartPeriod = parseHealthcheckDuration(L, table, "start_period")
+	hc.StartInterval = parseHealthcheckDuration(L, table, "start_interval")
+
+	if retriesVal := L.GetField(table, "retries"); retriesVal.Type() == lua.LTNumber {
+		hc.Retries = int(retriesVal.(lua.LNumber))
+		if hc.Retries < 0 {
+			L.RaiseError("bk.export: healthcheck.retries must not be negative")
+		}
+	}
+	return hc
+}
+
+// parseHealthcheckDuration reads a duration such as "30s", or a number of
+// seconds, from field of a healthcheck table.
+func parseHealthcheckDuration(L *lua.LState, table *lua.LTable, field string) time.Duration {
+	var d time.Duration
+	switch val := L.GetField(table, field); val.Type() {
+	case lua.LTNil:
+		return 0
+	case lua.LTNumber:
+		d = time.Duration(float64(val.(lua.LNumber)) * float64(time.Second))
+	case lua.LTString:
+		var err error
+		d, err = time.ParseDuration(val.String())
+		if err != nil {
+			L.RaiseError("bk.export: healthcheck.%s: %v", field, err)
+		}
+	default:
+		L.RaiseError("bk.export: healthcheck.%s must be a duration string or a number of seconds", field)
+	}
+	if d < 0 {
+		L.RaiseError("bk.export: healthcheck.%s must not be negative", field)
+	}
+	return d
+}
+
+// parseAnnotations reads the annotations and index_annotations export
+// options.
+func parseAnnotations(L *lua.LState, opts *lua.LTable) Annotations {
+	var a Annotations
+	if val := L.GetField(opts, "annotations"); val.Type() == lua.LTTable {
+		a.Manifest = parseLabelsTable(L, val.(*lua.LTable))
+	}
+	if val := L.GetField(opts, "index_annotations"); val.Type() == lua.LTTable {
+		a.Index = parseLabelsTable(L, val.(*lua.LTable))
+	}
+	return a
 }
 
 func parseLabelsTable(L *lua.LState, table *lua.LTable) map[string]string {
@@ -404,7 +619,7 @@ func parseLocalOptions(L *lua.LState, opts *lua.LTable) *ops.LocalOptions {
 }
 
 func parseImageOptions(L *lua.LState, opts *lua.LTable) *ops.ImageOptions {
-	imageOpts := &ops.ImageOptions{}
+	imageOpts := &ops.ImageOptions{ResolveDigest: true}
 
 	if platformVal := L.GetField(opts, "platform"); platformVal.Type() == lua.LTString {
 		imageOpts.Platform = platformVal.String()
@@ -412,7 +627,6 @@ func parseImageOptions(L *lua.LState, opts *lua.LTable) *ops.ImageOptions {
 
 	if resolveDigestVal := L.GetField(opts, "resolve_digest"); resolveDigestVal.Type() == lua.LTBool {
 		imageOpts.ResolveDigest = bool(resolveDigestVal.(lua.LBool))
-		fmt.Printf("[DEBUG] Parsed resolve_digest=%v\n", imageOpts.ResolveDigest)
 	}
 
 	return imageOpts
@@ -429,9 +643,81 @@ func parseGitOptions(L *lua.LState, opts *lua.LTable) *ops.GitOptions {
 		gitOpts.KeepGitDir = bool(keepGitDirVal.(lua.LBool))
 	}
 
+	gitOpts.Subdir = gitStringOption(L, opts, "subdir")
+	gitOpts.AuthTokenSecret = gitStringOption(L, opts, "auth_token_secret")
+	gitOpts.AuthHeaderSecret = gitStringOption(L, opts, "auth_header_secret")
+	gitOpts.SSH = gitStringOption(L, opts, "ssh")
+
+	if gitOpts.Checksum = gitStringOption(L, opts, "checksum"); gitOpts.Checksum != "" && !isHex(gitOpts.Checksum) {
+		L.RaiseError("bk.git: checksum must be a commit SHA, got %q", gitOpts.Checksum)
+		return nil
+	}
+
+	if submodulesVal := L.GetField(opts, "submodules"); submodulesVal.Type() == lua.LTBool {
+		gitOpts.SkipSubmodules = !bool(submodulesVal.(lua.LBool))
+	} else if submodulesVal.Type() != lua.LTNil {
+		L.RaiseError("bk.git: submodules must be a boolean")
+		return nil
+	}
+
+	if mountVal := L.GetField(opts, "mount_ssh_sock"); mountVal.Type() == lua.LTBool {
+		gitOpts.NoMountSSHSock = !bool(mountVal.(lua.LBool))
+	} else if mountVal.Type() != lua.LTNil {
+		L.RaiseError("bk.git: mount_ssh_sock must be a boolean")
+		return nil
+	}
+	if gitOpts.NoMountSSHSock && gitOpts.SSH != "" {
+		L.RaiseError("bk.git: ssh cannot be combined with mount_ssh_sock = false")
+		return nil
+	}
+
+	switch hostsVal := L.GetField(opts, "known_ssh_hosts").(type) {
+	case lua.LString:
+		gitOpts.KnownSSHHosts = []string{string(hostsVal)}
+	case *lua.LTable:
+		for i := 1; i <= hostsVal.Len(); i++ {
+			host, ok := hostsVal.RawGetInt(i).(lua.LString)
+			if !ok {
+				L.RaiseError("bk.git: known_ssh_hosts entries must be strings")
+				return nil
+			}
+			gitOpts.KnownSSHHosts = append(gitOpts.KnownSSHHosts, string(host))
+		}
+	case *lua.LNilType:
+	default:
+		L.RaiseError("bk.git: known_ssh_hosts must be a string or a list of strings")
+		return nil
+	}
+
 	return gitOpts
 }
 
+// gitStringOption returns the string option name of bk.git, or "" if unset.
+func gitStringOption(L *lua.LState, opts *lua.LTable, name string) string {
+	val := L.GetField(opts, name)
+	switch val.Type() {
+	case lua.LTNil:
+		return ""
+	case lua.LTString:
+		if val.String() == "" {
+			L.RaiseError("bk.git: %s must not be empty", name)
+		}
+		return val.String()
+	default:
+		L.RaiseError("bk.git: %s must be a string", name)
+		return ""
+	}
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return s != ""
+}
+
 func parseHTTPOptions(L *lua.LState, opts *lua.LTable) *ops.HTTPOptions {
 	httpOpts := &ops.HTTPOptions{}
 
@@ -465,6 +751,43 @@ func parseHTTPOptions(L *lua.LState, opts *lua.LTable) *ops.HTTPOptions {
 		httpOpts.Password = passwordVal.String()
 	}
 
+	if authVal := L.GetField(opts, "auth_secret"); authVal.Type() == lua.LTString {
+		httpOpts.AuthSecret = authVal.String()
+		if httpOpts.AuthSecret == "" {
+			L.RaiseError("http options auth_secret must not be empty")
+			return nil
+		}
+	} else if authVal.Type() != lua.LTNil {
+		L.RaiseError("http options auth_secret must be a string")
+		return nil
+	}
+
+	// BuildKit reads only the Authorization header from a secret, so
+	// header_secrets is another way to spell auth_secret.
+	if headerSecretsVal := L.GetField(opts, "header_secrets"); headerSecretsVal.Type() == lua.LTTable {
+		headerSecretsVal.(*lua.LTable).ForEach(func(key, value lua.LValue) {
+			name := key.String()
+			if !strings.EqualFold(name, "Authorization") {
+				L.RaiseError("http options header_secrets: only the Authorization header can be read from a secret, got %q", name)
+			}
+			if value.Type() != lua.LTString || value.String() == "" {
+				L.RaiseError("http options header_secrets: %s must be a secret id", name)
+			}
+			if httpOpts.AuthSecret != "" {
+				L.RaiseError("http options: Authorization is set by both auth_secret and header_secrets")
+			}
+			httpOpts.AuthSecret = value.String()
+		})
+	} else if headerSecretsVal.Type() != lua.LTNil {
+		L.RaiseError("http options header_secrets must be a table")
+		return nil
+	}
+
+	if httpOpts.AuthSecret != "" && (httpOpts.Username != "" || httpOpts.Password != "") {
+		L.RaiseError("http options: auth_secret cannot be combined with username and password")
+		return nil
+	}
+
 	return httpOpts
 }
 
@@ -560,12 +883,7 @@ func platformToString(L *lua.LState) int {
 		return 0
 	}
 
-	result := platform.OS + "/" + platform.Architecture
-	if platform.Variant != "" {
-		result += "/" + platform.Variant
-	}
-
-	L.Push(lua.LString(result))
+	L.Push(lua.LString(formatPlatform(platform)))
 	return 1
 }
 
@@ -2,7 +2,9 @@ package luavm
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
 	lua "github.com/yuin/gopher-lua"
@@ -125,12 +127,10 @@ func TestParseExportOptionsMinimal(t *testing.T) {
 		t.Fatal("parseExportOptions returned nil")
 	}
 
-	if config.OS != "linux" {
-		t.Errorf("Expected default OS 'linux', got '%s'", config.OS)
-	}
-
-	if config.Architecture != "amd64" {
-		t.Errorf("Expected default architecture 'amd64', got '%s'", config.Architecture)
+	// OS and architecture are left to serialization, which takes them from
+	// the exported state's platform.
+	if config.OS != "" || config.Architecture != "" {
+		t.Errorf("Expected no platform, got '%s/%s'", config.OS, config.Architecture)
 	}
 
 	if len(config.Config.Env) != 0 {
@@ -377,3 +377,130 @@ func TestWithMetadataInvalidType(t *testing.T) {
 		t.Fatalf("Expected error message, got: %v", err)
 	}
 }
+
+func TestExportInheritOption(t *testing.T) {
+	tests := []struct {
+		script string
+		want   bool
+	}{
+		{script: `bk.export(bk.image("nginx"))`, want: true},
+		{script: `bk.export(bk.image("nginx"), { cmd = { "nginx" } })`, want: true},
+		{script: `bk.export(bk.image("nginx"), { inherit = false })`, want: false},
+	}
+
+	for _, tt := range tests {
+		result, err := Evaluate(strings.NewReader(tt.script), "build.lua", nil)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if result.InheritImageConfig != tt.want {
+			t.Errorf("%s: expected InheritImageConfig %v, got %v", tt.script, tt.want, result.InheritImageConfig)
+		}
+	}
+}
+
+func TestExportImageConfigOptions(t *testing.T) {
+	script := `
+bk.export(bk.image("nginx"), {
+    healthcheck = {
+        test = { "curl", "-f", "http://localhost/" },
+        interval = "30s",
+        timeout = 5,
+        start_period = "1m",
+        retries = 3,
+    },
+    volumes = { "/data", "/cache" },
+    stop_signal = "SIGQUIT",
+    shell = { "/bin/bash", "-c" },
+    onbuild = { "RUN make" },
+    os_version = "10.0.17763.1040",
+    os_features = { "win32k" },
+    created = "2024-05-01T12:00:00+02:00",
+    annotations = { ["org.opencontainers.image.source"] = "https://example.com/app" },
+    index_annotations = { ["org.opencontainers.image.title"] = "app" },
+})
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	config := result.ImageConfig
+	hc := config.Config.Healthcheck
+	if hc == nil {
+		t.Fatal("expected a healthcheck")
+	}
+	if strings.Join(hc.Test, " ") != "CMD curl -f http://localhost/" {
+		t.Errorf("unexpected healthcheck test %q", hc.Test)
+	}
+	if hc.Interval != 30*time.Second || hc.Timeout != 5*time.Second || hc.StartPeriod != time.Minute || hc.Retries != 3 {
+		t.Errorf("unexpected healthcheck %+v", hc)
+	}
+	if len(config.Config.Volumes) != 2 {
+		t.Errorf("expected 2 volumes, got %v", config.Config.Volumes)
+	}
+	if config.Config.StopSignal != "SIGQUIT" {
+		t.Errorf("expected stop signal SIGQUIT, got %q", config.Config.StopSignal)
+	}
+	if strings.Join(config.Config.Shell, " ") != "/bin/bash -c" {
+		t.Errorf("unexpected shell %q", config.Config.Shell)
+	}
+	if len(config.Config.OnBuild) != 1 || config.Config.OnBuild[0] != "RUN make" {
+		t.Errorf("unexpected onbuild %q", config.Config.OnBuild)
+	}
+	if config.OSVersion != "10.0.17763.1040" || len(config.OSFeatures) != 1 {
+		t.Errorf("unexpected os version %q and features %q", config.OSVersion, config.OSFeatures)
+	}
+	if config.Created == nil || !config.Created.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected created %v", config.Created)
+	}
+
+	meta := result.Annotations.Metadata()
+	if got := string(meta["annotation-manifest.org.opencontainers.image.source"]); got != "https://example.com/app" {
+		t.Errorf("unexpected manifest annotation %q", got)
+	}
+	if got := string(meta["annotation-index.org.opencontainers.image.title"]); got != "app" {
+		t.Errorf("unexpected index annotation %q", got)
+	}
+}
+
+func TestExportHealthcheckTest(t *testing.T) {
+	tests := []struct {
+		test string
+		want []string
+	}{
+		{test: `"curl -f http://localhost/"`, want: []string{"CMD-SHELL", "curl -f http://localhost/"}},
+		{test: `{ "CMD-SHELL", "true" }`, want: []string{"CMD-SHELL", "true"}},
+		{test: `{ "NONE" }`, want: []string{"NONE"}},
+	}
+
+	for _, tt := range tests {
+		script := `bk.export(bk.scratch(), { healthcheck = { test = ` + tt.test + ` } })`
+		result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if got := result.ImageConfig.Config.Healthcheck.Test; strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: expected %q, got %q", tt.test, tt.want, got)
+		}
+	}
+}
+
+func TestExportImageConfigOptionErrors(t *testing.T) {
+	tests := []struct {
+		opts    string
+		wantErr string
+	}{
+		{opts: `{ healthcheck = { interval = "30s" } }`, wantErr: "healthcheck.test is required"},
+		{opts: `{ healthcheck = { test = "true", interval = "soon" } }`, wantErr: "healthcheck.interval"},
+		{opts: `{ healthcheck = { test = "true", timeout = -1 } }`, wantErr: "must not be negative"},
+		{opts: `{ created = "yesterday" }`, wantErr: "RFC 3339"},
+	}
+
+	for _, tt := range tests {
+		_, err := Evaluate(strings.NewReader(`bk.export(bk.scratch(), `+tt.opts+`)`), "build.lua", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.opts, tt.wantErr, err)
+		}
+	}
+}
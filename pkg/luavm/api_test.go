@@ -1165,6 +1165,55 @@ func TestBkHTTPWithBasicAuth(t *testing.T) {
 	}
 }
 
+func TestBkHTTPWithAuthSecret(t *testing.T) {
+	for _, opts := range []string{
+		`{ auth_secret = "registry_token" }`,
+		`{ header_secrets = { Authorization = "registry_token" } }`,
+		`{ header_secrets = { authorization = "registry_token" } }`,
+	} {
+		L := NewVM(nil)
+
+		if err := L.DoString(`result = bk.https("https://example.com/file.tar.gz", ` + opts + `)`); err != nil {
+			L.Close()
+			t.Fatalf("Failed to call bk.https with %s: %v", opts, err)
+		}
+
+		ud := L.GetGlobal("result").(*lua.LUserData)
+		sourceOp := ud.Value.(*dag.State).Op().Op().GetSource()
+		if sourceOp.Attrs["http.authheadersecret"] != "registry_token" {
+			t.Errorf("%s: expected http.authheadersecret 'registry_token', got '%s'", opts, sourceOp.Attrs["http.authheadersecret"])
+		}
+		if _, ok := sourceOp.Attrs["http.header.Authorization"]; ok {
+			t.Errorf("%s: the Authorization header must not be stored in the definition", opts)
+		}
+		L.Close()
+	}
+}
+
+func TestBkHTTPAuthSecretErrors(t *testing.T) {
+	tests := []struct {
+		opts    string
+		wantErr string
+	}{
+		{opts: `{ auth_secret = "" }`, wantErr: "auth_secret must not be empty"},
+		{opts: `{ auth_secret = 1 }`, wantErr: "auth_secret must be a string"},
+		{opts: `{ header_secrets = "token" }`, wantErr: "header_secrets must be a table"},
+		{opts: `{ header_secrets = { ["X-Api-Key"] = "token" } }`, wantErr: "only the Authorization header"},
+		{opts: `{ header_secrets = { Authorization = "" } }`, wantErr: "must be a secret id"},
+		{opts: `{ auth_secret = "a", header_secrets = { Authorization = "b" } }`, wantErr: "both auth_secret and header_secrets"},
+		{opts: `{ auth_secret = "a", username = "u", password = "p" }`, wantErr: "cannot be combined with username"},
+	}
+
+	for _, tt := range tests {
+		L := NewVM(nil)
+		err := L.DoString(`bk.https("https://example.com/file.tar.gz", ` + tt.opts + `)`)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.opts, tt.wantErr, err)
+		}
+		L.Close()
+	}
+}
+
 func TestBkHTTPWithAllOptions(t *testing.T) {
 	L := NewVM(nil)
 	defer L.Close()
@@ -0,0 +1,161 @@
+package luavm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Build argument types accepted by bk.arg.
+const (
+	ArgTypeString = "string"
+	ArgTypeBool   = "bool"
+	ArgTypeNumber = "number"
+	ArgTypeList   = "list"
+)
+
+// ArgSpec describes a build argument declared with bk.arg.
+type ArgSpec struct {
+	Name string
+	Type string
+	// Default is the default in its command-line form, e.g. "a,b" for a
+	// list. HasDefault tells an empty default from none.
+	Default     string
+	HasDefault  bool
+	Description string
+}
+
+// bkArg declares a build argument and returns its value, converted to the
+// declared type. It is called as bk.arg(name), bk.arg(name, default) or
+// bk.arg(name, { default = ..., type = ..., description = ... }).
+func bkArg(L *lua.LState) int {
+	name := L.CheckString(1)
+	if name == "" || isWhitespaceOnly(name) {
+		L.RaiseError("bk.arg: name must not be empty")
+		return 0
+	}
+
+	spec := ArgSpec{Name: name, Type: ArgTypeString}
+	defaultVal := lua.LNil
+
+	switch opt := L.Get(2); opt.Type() {
+	case lua.LTNil:
+	case lua.LTTable:
+		opts := opt.(*lua.LTable)
+		defaultVal = L.GetField(opts, "default")
+		if typeVal := L.GetField(opts, "type"); typeVal.Type() != lua.LTNil {
+			spec.Type = typeVal.String()
+		}
+		if descVal := L.GetField(opts, "description"); descVal.Type() == lua.LTString {
+			spec.Description = descVal.String()
+		}
+	case lua.LTString, lua.LTNumber, lua.LTBool:
+		defaultVal = opt
+		if opt.Type() == lua.LTNumber {
+			spec.Type = ArgTypeNumber
+		} else if opt.Type() == lua.LTBool {
+			spec.Type = ArgTypeBool
+		}
+	default:
+		L.ArgError(2, "default value or options table expected")
+		return 0
+	}
+
+	switch spec.Type {
+	case ArgTypeString, ArgTypeBool, ArgTypeNumber, ArgTypeList:
+	default:
+		L.RaiseError("bk.arg: %s: unknown type %q (expected string, bool, number or list)", name, spec.Type)
+		return 0
+	}
+
+	if defaultVal != lua.LNil {
+		spec.Default = argString(defaultVal)
+		spec.HasDefault = true
+		if _, err := parseArg(L, spec.Type, spec.Default); err != nil {
+			L.RaiseError("bk.arg: %s: invalid default: %v", name, err)
+			return 0
+		}
+	}
+
+	data := getVMData(L)
+	if err := data.declareArg(spec); err != nil {
+		L.RaiseError("bk.arg: %v", err)
+		return 0
+	}
+
+	raw, ok := data.args[name]
+	if !ok {
+		if !spec.HasDefault {
+			L.Push(lua.LNil)
+			return 1
+		}
+		raw = spec.Default
+	}
+
+	value, err := parseArg(L, spec.Type, raw)
+	if err != nil {
+		L.RaiseError("bk.arg: %s: %v", name, err)
+		return 0
+	}
+	L.Push(value)
+	return 1
+}
+
+// declareArg records spec. Declaring the same argument again is allowed as
+// long as the type matches.
+func (d *vmData) declareArg(spec ArgSpec) error {
+	for _, existing := range d.declaredArgs {
+		if existing.Name != spec.Name {
+			continue
+		}
+		if existing.Type != spec.Type {
+			return fmt.Errorf("%s: declared as %s and %s", spec.Name, existing.Type, spec.Type)
+		}
+		return nil
+	}
+	d.declaredArgs = append(d.declaredArgs, spec)
+	return nil
+}
+
+// argString renders a Lua default in the form the argument would be passed
+// on the command line.
+func argString(v lua.LValue) string {
+	table, ok := v.(*lua.LTable)
+	if !ok {
+		return v.String()
+	}
+	var items []string
+	for i := 1; i <= table.Len(); i++ {
+		items = append(items, table.RawGetInt(i).String())
+	}
+	return strings.Join(items, ",")
+}
+
+func parseArg(L *lua.LState, typ, raw string) (lua.LValue, error) {
+	switch typ {
+	case ArgTypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", raw)
+		}
+		return lua.LBool(b), nil
+	case ArgTypeNumber:
+		n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", raw)
+		}
+		return lua.LNumber(n), nil
+	case ArgTypeList:
+		table := L.NewTable()
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				table.Append(lua.LString(item))
+			}
+		}
+		return table, nil
+	default:
+		return lua.LString(raw), nil
+	}
+}
@@ -0,0 +1,124 @@
+package luavm
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func evalArgs(t *testing.T, script string, args map[string]string) *EvalResult {
+	t.Helper()
+	result, err := Evaluate(strings.NewReader(script), "build.lua", &VMConfig{Args: args})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	return result
+}
+
+func TestArgTypes(t *testing.T) {
+	script := `
+local version = bk.arg("VERSION", "1.0")
+local debug = bk.arg("DEBUG", { type = "bool", default = false })
+local jobs = bk.arg("JOBS", 4)
+local tags = bk.arg("TAGS", { type = "list", default = { "a", "b" } })
+local missing = bk.arg("MISSING")
+
+assert(type(debug) == "boolean")
+assert(type(jobs) == "number")
+assert(missing == nil)
+
+bk.export(bk.scratch():env({
+    VERSION = version,
+    DEBUG = tostring(debug),
+    JOBS = tostring(jobs),
+    TAGS = table.concat(tags, "+"),
+}))
+`
+	tests := []struct {
+		name string
+		args map[string]string
+		want []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"VERSION=1.0", "DEBUG=false", "JOBS=4", "TAGS=a+b"},
+		},
+		{
+			name: "overrides",
+			args: map[string]string{"VERSION": "2.0", "DEBUG": "true", "JOBS": "8", "TAGS": "x, y,,z"},
+			want: []string{"VERSION=2.0", "DEBUG=true", "JOBS=8", "TAGS=x+y+z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evalArgs(t, script, tt.args)
+			env := result.ImageConfig.Config.Env
+			for _, want := range tt.want {
+				found := false
+				for _, e := range env {
+					if e == want {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected %s in %v", want, env)
+				}
+			}
+		})
+	}
+}
+
+func TestArgDeclarations(t *testing.T) {
+	script := `
+bk.arg("VERSION", { default = "1.0", description = "Version to build" })
+bk.arg("VERSION")
+bk.arg("PUSH", { type = "bool" })
+bk.export(bk.scratch())
+`
+	result := evalArgs(t, script, nil)
+
+	if len(result.Args) != 2 {
+		t.Fatalf("expected 2 declared args, got %+v", result.Args)
+	}
+	version := result.Args[0]
+	if version.Name != "VERSION" || version.Type != ArgTypeString || version.Default != "1.0" || !version.HasDefault || version.Description != "Version to build" {
+		t.Errorf("unexpected spec %+v", version)
+	}
+	push := result.Args[1]
+	if push.Name != "PUSH" || push.Type != ArgTypeBool || push.HasDefault {
+		t.Errorf("unexpected spec %+v", push)
+	}
+}
+
+func TestArgErrors(t *testing.T) {
+	tests := []struct {
+		script  string
+		args    map[string]string
+		wantErr string
+	}{
+		{`bk.arg("")`, nil, "name must not be empty"},
+		{`bk.arg("A", { type = "int" })`, nil, `unknown type "int"`},
+		{`bk.arg("A", { type = "number", default = "abc" })`, nil, "invalid default"},
+		{`bk.arg("A", { type = "bool" })`, map[string]string{"A": "maybe"}, `invalid bool "maybe"`},
+		{`bk.arg("A", "x"); bk.arg("A", 1)`, nil, "declared as string and number"},
+	}
+	for _, tt := range tests {
+		_, err := Evaluate(strings.NewReader(tt.script), "build.lua", &VMConfig{Args: tt.args})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.script, tt.wantErr, err)
+		}
+	}
+}
+
+func TestArgsDoNotTouchEnvironment(t *testing.T) {
+	script := `
+assert(os.getenv("LUAKIT_TEST_ARG") == nil)
+assert(bk.arg("LUAKIT_TEST_ARG") == "set")
+bk.export(bk.scratch())
+`
+	evalArgs(t, script, map[string]string{"LUAKIT_TEST_ARG": "set"})
+	if _, ok := os.LookupEnv("LUAKIT_TEST_ARG"); ok {
+		t.Error("expected build args to stay out of the process environment")
+	}
+}
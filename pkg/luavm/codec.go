@@ -0,0 +1,265 @@
+package luavm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	lua "github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v3"
+)
+
+// codec decodes a data format into Go values and encodes them back.
+// Encoders must write map keys in sorted order so the output, and any file
+// built from it, is the same on every run.
+type codec struct {
+	decode func(data []byte) (any, error)
+	encode func(v any, opts *lua.LTable) ([]byte, error)
+}
+
+// builtinModules are the Go-backed modules require finds before searching
+// the build context and stdlib.
+var builtinModules = map[string]codec{
+	"luakit.json": {decode: decodeJSON, encode: encodeJSON},
+	"luakit.yaml": {decode: decodeYAML, encode: encodeYAML},
+	"luakit.toml": {decode: decodeTOML, encode: encodeTOML},
+}
+
+// loadBuiltinModule returns the chunk require runs for a builtin module,
+// or nil if name is not one.
+func loadBuiltinModule(L *lua.LState, name string) *lua.LFunction {
+	c, ok := builtinModules[name]
+	if !ok {
+		return nil
+	}
+	return L.NewFunction(func(L *lua.LState) int {
+		mod := L.NewTable()
+		L.SetField(mod, "decode", L.NewFunction(func(L *lua.LState) int {
+			v, err := c.decode([]byte(L.CheckString(1)))
+			if err != nil {
+				L.RaiseError("%s.decode: %v", name, err)
+				return 0
+			}
+			L.Push(toLuaValue(L, v))
+			return 1
+		}))
+		L.SetField(mod, "encode", L.NewFunction(func(L *lua.LState) int {
+			v, err := fromLuaValue(L.CheckAny(1), nil)
+			if err != nil {
+				L.RaiseError("%s.encode: %v", name, err)
+				return 0
+			}
+			data, err := c.encode(v, L.OptTable(2, nil))
+			if err != nil {
+				L.RaiseError("%s.encode: %v", name, err)
+				return 0
+			}
+			L.Push(lua.LString(data))
+			return 1
+		}))
+		L.Push(mod)
+		return 1
+	})
+}
+
+func decodeJSON(data []byte) (any, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// encodeJSON writes compact JSON, or indented JSON when opts sets indent.
+func encodeJSON(v any, opts *lua.LTable) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if opts != nil {
+		if indent, ok := opts.RawGetString("indent").(lua.LString); ok {
+			enc.SetIndent("", string(indent))
+		}
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func decodeYAML(data []byte) (any, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func encodeYAML(v any, _ *lua.LTable) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeTOML(data []byte) (any, error) {
+	var v map[string]any
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func encodeTOML(v any, _ *lua.LTable) ([]byte, error) {
+	if _, ok := v.(map[string]any); !ok {
+		return nil, fmt.Errorf("a TOML document must be a table with string keys")
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// toLuaValue converts a decoded value to Lua. Objects become tables with
+// string keys, arrays become sequences, null becomes nil and dates become
+// RFC 3339 strings.
+func toLuaValue(L *lua.LState, v any) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case time.Time:
+		return lua.LString(v.Format(time.RFC3339Nano))
+	case []any:
+		tbl := L.CreateTable(len(v), 0)
+		for i, e := range v {
+			tbl.RawSetInt(i+1, toLuaValue(L, e))
+		}
+		return tbl
+	case []map[string]any:
+		tbl := L.CreateTable(len(v), 0)
+		for i, e := range v {
+			tbl.RawSetInt(i+1, toLuaValue(L, e))
+		}
+		return tbl
+	case map[string]any:
+		// pairs visits keys in insertion order, so insert them sorted.
+		tbl := L.CreateTable(0, len(v))
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			tbl.RawSetString(k, toLuaValue(L, v[k]))
+		}
+		return tbl
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = e
+		}
+		return toLuaValue(L, m)
+	}
+	return lua.LString(fmt.Sprint(v))
+}
+
+// fromLuaValue converts a Lua value for encoding. A table whose keys are
+// exactly 1..n is an array; any other table is an object, with number keys
+// written as strings. An empty table is an empty object. seen holds the
+// tables being converted, to reject cycles.
+func fromLuaValue(lv lua.LValue, seen map[*lua.LTable]bool) (any, error) {
+	switch lv := lv.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(lv), nil
+	case lua.LString:
+		return string(lv), nil
+	case lua.LNumber:
+		f := float64(lv)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("cannot encode %v", lv)
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f), nil
+		}
+		return f, nil
+	case *lua.LTable:
+		if seen[lv] {
+			return nil, fmt.Errorf("cannot encode a table that contains itself")
+		}
+		if seen == nil {
+			seen = make(map[*lua.LTable]bool)
+		}
+		seen[lv] = true
+		defer delete(seen, lv)
+
+		n, count, isArray := lv.Len(), 0, true
+		var keyErr error
+		lv.ForEach(func(k, _ lua.LValue) {
+			count++
+			switch k := k.(type) {
+			case lua.LNumber:
+				if i := float64(k); i != math.Trunc(i) || i < 1 || i > float64(n) {
+					isArray = false
+				}
+			case lua.LString:
+				isArray = false
+			default:
+				keyErr = fmt.Errorf("cannot encode a table key of type %s", k.Type())
+			}
+		})
+		if keyErr != nil {
+			return nil, keyErr
+		}
+
+		if count > 0 && isArray && count == n {
+			arr := make([]any, n)
+			for i := range arr {
+				v, err := fromLuaValue(lv.RawGetInt(i+1), seen)
+				if err != nil {
+					return nil, err
+				}
+				arr[i] = v
+			}
+			return arr, nil
+		}
+
+		obj := make(map[string]any, count)
+		var err error
+		lv.ForEach(func(k, v lua.LValue) {
+			if err != nil {
+				return
+			}
+			key := k.String()
+			if num, ok := k.(lua.LNumber); ok {
+				key = strconv.FormatFloat(float64(num), 'f', -1, 64)
+			}
+			obj[key], err = fromLuaValue(v, seen)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return obj, nil
+	}
+	return nil, fmt.Errorf("cannot encode a %s", lv.Type())
+}
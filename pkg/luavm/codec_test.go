@@ -0,0 +1,120 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// evalOut evaluates script, which sets the global out, and returns out as a
+// string.
+func evalOut(t *testing.T, script string, config *VMConfig) string {
+	t.Helper()
+	L := NewVM(config)
+	defer L.Close()
+	if err := L.DoString(script); err != nil {
+		t.Fatalf("script failed: %v", err)
+	}
+	return L.GetGlobal("out").String()
+}
+
+func TestDecodeModules(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		input  string
+	}{
+		{"json", "luakit.json", `{"name": "app", "port": 8080, "debug": true, "tags": ["a", "b"], "db": {"host": "pg"}, "none": null}`},
+		{"yaml", "luakit.yaml", "name: app\nport: 8080\ndebug: true\ntags: [a, b]\ndb:\n  host: pg\nnone: ~\n"},
+		{"toml", "luakit.toml", "name = \"app\"\nport = 8080\ndebug = true\ntags = [\"a\", \"b\"]\n[db]\nhost = \"pg\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := `
+local codec = require("` + tt.module + `")
+local v = codec.decode([==[` + tt.input + `]==])
+local keys = {}
+for k in pairs(v) do table.insert(keys, k) end
+out = table.concat({
+    v.name, tostring(v.port), tostring(v.debug), table.concat(v.tags, "+"),
+    v.db.host, tostring(v.none), table.concat(keys, ","),
+}, " ")
+`
+			got := evalOut(t, script, nil)
+			want := "app 8080 true a+b pg nil db,debug,name,port,tags"
+			if got != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeModules(t *testing.T) {
+	value := `{ name = "app", port = 8080, ratio = 0.5, tags = { "a", "b" }, db = { host = "pg" }, empty = {} }`
+	tests := []struct {
+		module string
+		opts   string
+		want   string
+	}{
+		{"luakit.json", "", `{"db":{"host":"pg"},"empty":{},"name":"app","port":8080,"ratio":0.5,"tags":["a","b"]}`},
+		{"luakit.json", `, { indent = "  " }`, "{\n  \"db\": {\n    \"host\": \"pg\"\n  },\n  \"empty\": {},\n  \"name\": \"app\",\n  \"port\": 8080,\n  \"ratio\": 0.5,\n  \"tags\": [\n    \"a\",\n    \"b\"\n  ]\n}"},
+		{"luakit.yaml", "", "db:\n  host: pg\nempty: {}\nname: app\nport: 8080\nratio: 0.5\ntags:\n  - a\n  - b\n"},
+		{"luakit.toml", "", "name = \"app\"\nport = 8080\nratio = 0.5\ntags = [\"a\", \"b\"]\n\n[db]\n  host = \"pg\"\n\n[empty]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.module+tt.opts, func(t *testing.T) {
+			script := `out = require("` + tt.module + `").encode(` + value + tt.opts + `)`
+			for range 3 {
+				if got := evalOut(t, script, nil); got != tt.want {
+					t.Fatalf("expected:\n%s\ngot:\n%s", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	script := `
+local json = require("luakit.json")
+local yaml = require("luakit.yaml")
+local v = { list = { 1, 2.5, "three", true }, nested = { [1] = "x", [3] = "y" } }
+out = json.encode(yaml.decode(yaml.encode(json.decode(json.encode(v)))))
+`
+	want := `{"list":[1,2.5,"three",true],"nested":{"1":"x","3":"y"}}`
+	if got := evalOut(t, script, nil); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	tests := []struct {
+		script  string
+		wantErr string
+	}{
+		{`require("luakit.json").decode("{")`, "luakit.json.decode:"},
+		{`require("luakit.yaml").decode("a: [")`, "luakit.yaml.decode:"},
+		{`require("luakit.toml").decode("a = ")`, "luakit.toml.decode:"},
+		{`require("luakit.json").encode({ f = print })`, "luakit.json.encode: cannot encode a function"},
+		{`local t = {}; t.self = t; require("luakit.json").encode(t)`, "contains itself"},
+		{`require("luakit.json").encode({ [true] = 1 })`, "cannot encode a table key of type boolean"},
+		{`require("luakit.toml").encode({ 1, 2 })`, "luakit.toml.encode: a TOML document must be a table"},
+		{`require("luakit.json").encode(0/0)`, "cannot encode NaN"},
+	}
+	for _, tt := range tests {
+		_, err := Evaluate(strings.NewReader(tt.script), "build.lua", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.script, tt.wantErr, err)
+		}
+	}
+}
+
+func TestBuiltinModulesShadowContext(t *testing.T) {
+	config := &VMConfig{ContextFS: fstest.MapFS{
+		"luakit/json.lua": {Data: []byte(`return { decode = function() return "context" end }`)},
+	}}
+	if got := evalOut(t, `out = require("luakit.json").decode("1")`, config); got != "1" {
+		t.Errorf("expected the builtin module, got %q", got)
+	}
+}
@@ -0,0 +1,47 @@
+package luavm
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Script is a build script for EvaluateAll.
+type Script struct {
+	Filename string
+	Source   []byte
+	Config   *VMConfig
+}
+
+// ScriptResult is the outcome of evaluating one Script.
+type ScriptResult struct {
+	Result *EvalResult
+	Err    error
+}
+
+// EvaluateAll evaluates scripts concurrently, each in its own VM, on at most
+// workers goroutines, or GOMAXPROCS if workers is less than one. Results are
+// in the order of scripts and one failing script does not stop the others.
+//
+// Scripts may share a VMConfig, except for ContextStates: states are
+// completed in place when serialized, so each evaluation needs its own.
+func EvaluateAll(ctx context.Context, scripts []Script, workers int) []ScriptResult {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	results := make([]ScriptResult, len(scripts))
+	var g errgroup.Group
+	g.SetLimit(workers)
+	for i, script := range scripts {
+		g.Go(func() error {
+			result, err := EvaluateContext(ctx, bytes.NewReader(script.Source), script.Filename, script.Config)
+			results[i] = ScriptResult{Result: result, Err: err}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results
+}
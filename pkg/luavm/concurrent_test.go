@@ -0,0 +1,160 @@
+package luavm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	pb "github.com/moby/buildkit/solver/pb"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+)
+
+// TestEvaluateAllKeepsSourcesApart runs scripts that require modules of the
+// same name with different contents. Run with -race.
+func TestEvaluateAllKeepsSourcesApart(t *testing.T) {
+	const n = 32
+	scripts := make([]Script, n)
+	for i := range scripts {
+		module := fmt.Sprintf("return { tag = %q }\n", fmt.Sprint("v", i))
+		scripts[i] = Script{
+			Filename: "build.lua",
+			Source: []byte(fmt.Sprintf(`local lib = require("lib")
+local s = bk.image("alpine:3.20"):run("echo %d " .. lib.tag)
+bk.export(s)
+`, i)),
+			Config: &VMConfig{ContextFS: fstest.MapFS{"lib.lua": {Data: []byte(module)}}},
+		}
+	}
+
+	results := EvaluateAll(context.Background(), scripts, 8)
+	if len(results) != n {
+		t.Fatalf("expected %d results, got %d", n, len(results))
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("script %d failed: %v", i, r.Err)
+		}
+		if got := string(r.Result.SourceFiles["build.lua"]); got != string(scripts[i].Source) {
+			t.Errorf("script %d: source map holds another script:\n%s", i, got)
+		}
+		if got := string(r.Result.SourceFiles["lib.lua"]); !strings.Contains(got, fmt.Sprint("v", i)) {
+			t.Errorf("script %d: source map holds another module: %s", i, got)
+		}
+		args := r.Result.State.Op().Op().GetExec().GetMeta().GetArgs()
+		if want := fmt.Sprintf("echo %d v%d", i, i); !strings.Contains(strings.Join(args, " "), want) {
+			t.Errorf("script %d: expected %q in %v", i, want, args)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dag.Serialize(r.Result.State, &dag.SerializeOptions{SourceFiles: r.Result.SourceFiles}); err != nil {
+				t.Errorf("script %d: serialize failed: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestEvaluateAllKeepsMetadataApart runs scripts that build the same ops
+// with different metadata, so their digests, and the shared dag digest
+// caches, are the same. Each definition must hold only its own metadata.
+// Run with -race.
+func TestEvaluateAllKeepsMetadataApart(t *testing.T) {
+	const n = 32
+	scripts := make([]Script, n)
+	for i := range scripts {
+		scripts[i] = Script{
+			Filename: fmt.Sprintf("build%d.lua", i),
+			Source: []byte(fmt.Sprintf(`local s = bk.image("alpine:3.20"):run("make")
+bk.export(s:with_metadata({ description = "build %d" }))
+`, i)),
+		}
+	}
+
+	results := EvaluateAll(context.Background(), scripts, 8)
+
+	defs := make([]*pb.Definition, n)
+	var wg sync.WaitGroup
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("script %d failed: %v", i, r.Err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			def, err := dag.Serialize(r.Result.State, &dag.SerializeOptions{SourceFiles: r.Result.SourceFiles})
+			if err != nil {
+				t.Errorf("script %d: serialize failed: %v", i, err)
+				return
+			}
+			defs[i] = def
+		}()
+	}
+	wg.Wait()
+
+	for i, def := range defs {
+		if def == nil {
+			continue
+		}
+		if i > 0 && !slices.EqualFunc(def.Def, defs[0].Def, bytes.Equal) {
+			t.Errorf("script %d: expected the same ops as script 0", i)
+		}
+		var descriptions []string
+		for _, meta := range def.Metadata {
+			if d, ok := meta.Description["llb.custom"]; ok {
+				descriptions = append(descriptions, d)
+			}
+		}
+		if want := []string{fmt.Sprintf("build %d", i)}; !slices.Equal(descriptions, want) {
+			t.Errorf("script %d: expected descriptions %v, got %v", i, want, descriptions)
+		}
+		for _, info := range def.Source.GetInfos() {
+			if want := fmt.Sprintf("build%d.lua", i); info.Filename != want {
+				t.Errorf("script %d: expected source map of %s, got %s", i, want, info.Filename)
+			}
+		}
+	}
+}
+
+func TestEvaluateAllReportsEachError(t *testing.T) {
+	scripts := []Script{
+		{Filename: "ok.lua", Source: []byte(`bk.export(bk.scratch())`)},
+		{Filename: "bad.lua", Source: []byte(`error("boom")`)},
+		{Filename: "ok2.lua", Source: []byte(`bk.export(bk.image("alpine"))`)},
+	}
+
+	results := EvaluateAll(context.Background(), scripts, 0)
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("expected the other scripts to succeed, got %v and %v", results[0].Err, results[2].Err)
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "bad.lua:1: boom") {
+		t.Errorf("expected the error of bad.lua, got %v", results[1].Err)
+	}
+	if _, ok := results[2].Result.SourceFiles["ok.lua"]; ok {
+		t.Error("expected each result to hold only its own sources")
+	}
+}
+
+func TestDeprecatedSourceRegistry(t *testing.T) {
+	if _, err := Evaluate(strings.NewReader(`bk.export(bk.scratch())`), "last.lua", nil); err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if _, ok := GetAllSourceFiles()["last.lua"]; !ok {
+		t.Error("expected GetAllSourceFiles to hold the last evaluated script")
+	}
+
+	ResetSourceFiles()
+	RegisterSourceFile("extra.lua", []byte("-- extra"))
+	if files := GetAllSourceFiles(); len(files) != 1 || string(files["extra.lua"]) != "-- extra" {
+		t.Errorf("expected only the registered file, got %v", files)
+	}
+}
@@ -0,0 +1,158 @@
+package luavm
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/ops"
+)
+
+// Prefixes of the named context sources bk.context understands, as sent by
+// `docker buildx build --build-context`.
+const (
+	contextDockerImage = "docker-image://"
+	contextOCILayout   = "oci-layout://"
+	contextLocal       = "local:"
+	contextInput       = "input:"
+)
+
+// bkContext returns the named build context, bk.context(name) or
+// bk.context(name, { default = state }). A context given as a state, such
+// as a gateway input, wins over one given as a source; without either the
+// default is used.
+func bkContext(L *lua.LState) int {
+	name := L.CheckString(1)
+	if name == "" || isWhitespaceOnly(name) {
+		L.RaiseError("bk.context: name must not be empty")
+		return 0
+	}
+
+	var fallback *dag.State
+	switch opt := L.Get(2); opt.Type() {
+	case lua.LTNil:
+	case lua.LTTable:
+		if def := L.GetField(opt.(*lua.LTable), "default"); def.Type() != lua.LTNil {
+			ud, ok := def.(*lua.LUserData)
+			if !ok {
+				L.RaiseError("bk.context: %s: default must be a State", name)
+				return 0
+			}
+			if fallback, ok = stateValue(ud.Value); !ok {
+				L.RaiseError("bk.context: %s: default must be a State", name)
+				return 0
+			}
+		}
+	default:
+		L.ArgError(2, "options table expected")
+		return 0
+	}
+
+	data := getVMData(L)
+	if state, ok := data.contextStates[name]; ok {
+		L.Push(newState(L, state))
+		return 1
+	}
+
+	spec, ok := data.contexts[name]
+	if !ok {
+		if fallback == nil {
+			L.RaiseError("bk.context: no build context named %q and no default", name)
+			return 0
+		}
+		L.Push(newState(L, fallback))
+		return 1
+	}
+
+	file, line := getCallSite(L)
+	state, err := contextState(data, spec, file, line)
+	if err != nil {
+		L.RaiseError("bk.context: %s: %v", name, err)
+		return 0
+	}
+	L.Push(newState(L, state))
+	return 1
+}
+
+// contextState creates the source for a named context given as spec.
+func contextState(data *vmData, spec string, file string, line int) (*dag.State, error) {
+	switch {
+	case strings.HasPrefix(spec, contextDockerImage):
+		ref := strings.TrimPrefix(spec, contextDockerImage)
+		if err := ops.ValidateImageRef(ref); err != nil {
+			return nil, err
+		}
+		return ops.Image(ref, file, line, nil, nil), nil
+
+	case strings.HasPrefix(spec, contextOCILayout):
+		store, dgst, ok := cutLast(strings.TrimPrefix(spec, contextOCILayout), "@")
+		if !ok {
+			return nil, fmt.Errorf("oci-layout reference %q has no digest", spec)
+		}
+		if _, err := digest.Parse(dgst); err != nil {
+			return nil, fmt.Errorf("oci-layout reference %q: %w", spec, err)
+		}
+		named, err := reference.ParseNormalizedNamed(store + "@" + dgst)
+		if err != nil {
+			return nil, fmt.Errorf("oci-layout reference %q: %w", spec, err)
+		}
+		return ops.OCILayout(named.String(), store, file, line), nil
+
+	case strings.HasPrefix(spec, contextLocal):
+		name := strings.TrimPrefix(spec, contextLocal)
+		if err := ops.ValidateLocalName(name); err != nil {
+			return nil, err
+		}
+		return ops.Local(name, file, line, nil), nil
+
+	case strings.HasPrefix(spec, contextInput):
+		name := strings.TrimPrefix(spec, contextInput)
+		state, ok := data.contextStates[name]
+		if !ok {
+			return nil, fmt.Errorf("no input named %q", name)
+		}
+		return state, nil
+
+	case isGitContext(spec):
+		url, fragment, _ := strings.Cut(spec, "#")
+		ref, subdir, _ := strings.Cut(fragment, ":")
+		if err := ops.ValidateGitURL(url); err != nil {
+			return nil, err
+		}
+		return ops.Git(url, file, line, &ops.GitOptions{Ref: ref, Subdir: subdir}), nil
+
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		if err := ops.ValidateHTTPURL(spec); err != nil {
+			return nil, err
+		}
+		return ops.HTTP(spec, file, line, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported context %q (expected docker-image://, oci-layout://, local:, input:, a git or an HTTP URL)", spec)
+}
+
+// isGitContext reports whether spec names a git repository: an SSH or
+// git:// remote, or an HTTP URL of a .git repository or with a #ref.
+func isGitContext(spec string) bool {
+	for _, prefix := range []string{"git@", "git://", "ssh://"} {
+		if strings.HasPrefix(spec, prefix) {
+			return true
+		}
+	}
+	if !strings.HasPrefix(spec, "http://") && !strings.HasPrefix(spec, "https://") {
+		return false
+	}
+	url, _, hasRef := strings.Cut(spec, "#")
+	return hasRef || path.Ext(url) == ".git"
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
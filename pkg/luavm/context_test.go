@@ -0,0 +1,106 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/ops"
+)
+
+const contextScript = `bk.export(bk.context("base", { default = bk.image("alpine:3.20") }))`
+
+func TestContextSources(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		identifier string
+		attrs      map[string]string
+	}{
+		{name: "default", identifier: "docker-image://docker.io/library/alpine:3.20"},
+		{name: "docker image", spec: "docker-image://golang:1.23", identifier: "docker-image://docker.io/library/golang:1.23"},
+		{name: "local", spec: "local:deps", identifier: "local://deps"},
+		{name: "git", spec: "https://github.com/moby/buildkit.git#v0.27.1:frontend", identifier: "git://https://github.com/moby/buildkit.git#v0.27.1:frontend"},
+		{name: "git ssh", spec: "git@github.com:moby/buildkit.git", identifier: "git://git@github.com:moby/buildkit.git"},
+		{name: "http", spec: "https://example.com/rootfs.tar", identifier: "https://example.com/rootfs.tar"},
+		{
+			name:       "oci layout",
+			spec:       "oci-layout://app@sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4",
+			identifier: "oci-layout://docker.io/library/app@sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4",
+			attrs:      map[string]string{"oci.store": "app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &VMConfig{}
+			if tt.spec != "" {
+				config.Contexts = map[string]string{"base": tt.spec}
+			}
+			result, err := Evaluate(strings.NewReader(contextScript), "build.lua", config)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+
+			source := result.State.Op().Op().GetSource()
+			if source == nil {
+				t.Fatal("expected a source op")
+			}
+			if source.Identifier != tt.identifier {
+				t.Errorf("expected identifier %q, got %q", tt.identifier, source.Identifier)
+			}
+			for k, v := range tt.attrs {
+				if source.Attrs[k] != v {
+					t.Errorf("expected attr %s=%q, got %v", k, v, source.Attrs)
+				}
+			}
+			if tt.spec != "" && result.State.Op().LuaLine() != 1 {
+				t.Errorf("expected the source to point at bk.context, got line %d", result.State.Op().LuaLine())
+			}
+		})
+	}
+}
+
+func TestContextStates(t *testing.T) {
+	input := ops.Image("node:22", "", 0, nil, nil)
+
+	for _, config := range []*VMConfig{
+		{ContextStates: map[string]*dag.State{"base": input}, Contexts: map[string]string{"base": "local:base"}},
+		{ContextStates: map[string]*dag.State{"web": input}, Contexts: map[string]string{"base": "input:web"}},
+	} {
+		result, err := Evaluate(strings.NewReader(contextScript), "build.lua", config)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		if result.State.Op() != input.Op() {
+			t.Errorf("expected the input state, got %s", result.State.Op().Op().GetSource().GetIdentifier())
+		}
+	}
+}
+
+func TestContextErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		contexts map[string]string
+		wantErr  string
+	}{
+		{name: "no default", script: `bk.export(bk.context("base"))`, wantErr: `no build context named "base" and no default`},
+		{name: "empty name", script: `bk.context("")`, wantErr: "name must not be empty"},
+		{name: "bad default", script: `bk.context("base", { default = "alpine" })`, wantErr: "default must be a State"},
+		{name: "bad options", script: `bk.context("base", "alpine")`, wantErr: "options table expected"},
+		{name: "unknown source", script: contextScript, contexts: map[string]string{"base": "ftp://example.com"}, wantErr: "unsupported context"},
+		{name: "missing input", script: contextScript, contexts: map[string]string{"base": "input:web"}, wantErr: `no input named "web"`},
+		{name: "bad local", script: contextScript, contexts: map[string]string{"base": "local:../src"}, wantErr: "path traversal"},
+		{name: "oci layout without digest", script: contextScript, contexts: map[string]string{"base": "oci-layout://app"}, wantErr: "has no digest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Evaluate(strings.NewReader(tt.script), "build.lua", &VMConfig{Contexts: tt.contexts})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package luavm
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ParseSourceDateEpoch parses a SOURCE_DATE_EPOCH value, a number of seconds
+// since the Unix epoch. An empty value means none is set.
+func ParseSourceDateEpoch(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || sec < 0 {
+		return nil, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: expected seconds since the Unix epoch", v)
+	}
+	tm := time.Unix(sec, 0).UTC()
+	return &tm, nil
+}
+
+// bkSetEpoch sets the SOURCE_DATE_EPOCH of the build, as bk.set_epoch(seconds).
+// A value given with --source-date-epoch or the SOURCE_DATE_EPOCH build
+// argument takes precedence.
+func bkSetEpoch(L *lua.LState) int {
+	var v string
+	switch arg := L.Get(1); arg.Type() {
+	case lua.LTNumber, lua.LTString:
+		v = arg.String()
+	default:
+		L.ArgError(1, "number of seconds expected")
+		return 0
+	}
+
+	epoch, err := ParseSourceDateEpoch(v)
+	if err != nil || epoch == nil {
+		L.RaiseError("bk.set_epoch: invalid epoch %q: expected seconds since the Unix epoch", v)
+		return 0
+	}
+	getVMData(L).sourceDateEpoch = epoch
+	return 0
+}
@@ -0,0 +1,69 @@
+package luavm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetEpoch(t *testing.T) {
+	override := time.Unix(1800000000, 0).UTC()
+	tests := []struct {
+		name   string
+		script string
+		config *VMConfig
+		want   int64
+	}{
+		{name: "script", script: `bk.set_epoch(1700000000)`, want: 1700000000},
+		{name: "string", script: `bk.set_epoch("1700000000")`, want: 1700000000},
+		{name: "config wins", script: `bk.set_epoch(1700000000)`, config: &VMConfig{SourceDateEpoch: &override}, want: 1800000000},
+		{name: "config only", script: ``, config: &VMConfig{SourceDateEpoch: &override}, want: 1800000000},
+		{name: "unset", script: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Evaluate(strings.NewReader(tt.script+"\nbk.export(bk.scratch())"), "build.lua", tt.config)
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+			if tt.want == 0 {
+				if result.SourceDateEpoch != nil {
+					t.Errorf("expected no epoch, got %v", result.SourceDateEpoch)
+				}
+				if _, ok := result.ExportMetadata()["source.date.epoch"]; ok {
+					t.Error("expected no epoch metadata")
+				}
+				return
+			}
+			if result.SourceDateEpoch == nil || result.SourceDateEpoch.Unix() != tt.want {
+				t.Fatalf("expected epoch %d, got %v", tt.want, result.SourceDateEpoch)
+			}
+			if got := string(result.ExportMetadata()["source.date.epoch"]); got != strconv.FormatInt(tt.want, 10) {
+				t.Errorf("unexpected epoch metadata %q", got)
+			}
+		})
+	}
+}
+
+func TestSetEpochErrors(t *testing.T) {
+	for _, script := range []string{`bk.set_epoch(-1)`, `bk.set_epoch("yesterday")`, `bk.set_epoch()`} {
+		if _, err := Evaluate(strings.NewReader(script), "build.lua", nil); err == nil {
+			t.Errorf("%s: expected an error", script)
+		}
+	}
+}
+
+func TestParseSourceDateEpoch(t *testing.T) {
+	epoch, err := ParseSourceDateEpoch("1700000000")
+	if err != nil || epoch == nil || !epoch.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected epoch %v, %v", epoch, err)
+	}
+	if epoch, err := ParseSourceDateEpoch(""); epoch != nil || err != nil {
+		t.Errorf("expected no epoch for an empty value, got %v, %v", epoch, err)
+	}
+	if _, err := ParseSourceDateEpoch("1.5"); err == nil {
+		t.Error("expected an error for a fractional epoch")
+	}
+}
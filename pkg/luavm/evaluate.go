@@ -1,24 +1,34 @@
 package luavm
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 
 	lua "github.com/yuin/gopher-lua"
 )
 
 func Evaluate(r io.Reader, filename string, config *VMConfig) (*EvalResult, error) {
-	ResetSourceFiles()
+	return EvaluateContext(context.Background(), r, filename, config)
+}
+
+// EvaluateContext is Evaluate stopped when ctx is done or config.Timeout
+// passes. Hitting a limit in config returns a LimitError.
+func EvaluateContext(ctx context.Context, r io.Reader, filename string, config *VMConfig) (*EvalResult, error) {
+	if config != nil && config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
 
 	scriptData, err := io.ReadAll(r)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read script: %w", err)
 	}
 
-	RegisterSourceFile(filename, scriptData)
-
 	L := NewVM(config)
 	defer L.Close()
 
@@ -26,6 +36,12 @@ func Evaluate(r io.Reader, filename string, config *VMConfig) (*EvalResult, erro
 	if data == nil {
 		return nil, fmt.Errorf("failed to get vm data")
 	}
+	data.sourceFiles[filename] = scriptData
+	defer func() { setLastSourceFiles(data.sourceFiles) }()
+	if ctx.Done() != nil {
+		data.ctx = ctx
+		L.SetContext(ctx)
+	}
 
 	fn, err := L.Load(strings.NewReader(string(scriptData)), filename)
 	if err != nil {
@@ -34,14 +50,37 @@ func Evaluate(r io.Reader, filename string, config *VMConfig) (*EvalResult, erro
 
 	L.Push(fn)
 
-	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+	if err := data.pcall(L, 0, lua.MultRet); err != nil {
 		return nil, fmt.Errorf("failed to run script: %w", err)
 	}
 
+	var targetName string
+	epoch := data.sourceDateEpoch
+	if config != nil {
+		targetName = config.Target
+		if config.SourceDateEpoch != nil {
+			epoch = config.SourceDateEpoch
+		}
+	}
+	target, err := selectTarget(L, data, targetName)
+	if err != nil {
+		return nil, err
+	}
+
 	return &EvalResult{
-		State:       data.exportedState,
-		ImageConfig: data.exportedImageConfig,
-		SourceFiles: GetAllSourceFiles(),
+		State:              data.exportedState,
+		ImageConfig:        data.exportedImageConfig,
+		InheritImageConfig: data.inheritImageConfig,
+		Annotations:        data.annotations,
+		SourceDateEpoch:    epoch,
+		SourceFiles:        data.sourceFiles,
+		Platforms:          data.exportedPlatforms,
+		Target:             target,
+		Targets:            data.targetNames(),
+		Args:               data.declaredArgs,
+		EnvReads:           slices.Sorted(slices.Values(data.envReads)),
+		FileReads:          slices.Sorted(slices.Values(data.fileReads)),
+		FileLookups:        slices.Sorted(slices.Values(data.fileLookups)),
 	}, nil
 }
 
@@ -0,0 +1,87 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecResultMount(t *testing.T) {
+	resetExportedState()
+
+	L := NewVM(nil)
+	testVM = L
+	defer L.Close()
+	defer func() { testVM = nil }()
+
+	script := `
+		local base = bk.image("golang:1.22")
+		local build = base:run("go build -o /out/app .", {
+			mounts = { bk.bind(bk.scratch(), "/out", { readonly = false }) },
+		})
+		assert(tostring(build):find("^luakit.exec@"))
+		assert(tostring(build:root()):find("^luakit.state@"))
+
+		-- The result is still usable as its root filesystem.
+		local tested = build:run("go test ./...")
+
+		local out = bk.scratch():copy(build:mount("/out"), "/app", "/app")
+		bk.export(bk.merge(out, tested:root()))
+	`
+
+	if err := L.DoString(script); err != nil {
+		t.Fatalf("Failed to execute Lua script: %v", err)
+	}
+
+	merge := GetExportedState()
+	copyState := merge.Op().Inputs()[0].Node()
+	var fromOut bool
+	for _, edge := range copyState.Inputs() {
+		if edge.Node().Op().GetExec() != nil {
+			fromOut = edge.OutputIndex() == 1
+		}
+	}
+	if !fromOut {
+		t.Error("expected the copy to read output 1 of the exec")
+	}
+
+	testExec := merge.Op().Inputs()[1].Node().Op().GetExec()
+	if testExec == nil || testExec.Mounts[0].Output != 0 {
+		t.Errorf("expected the chained run to produce the root output, got %v", testExec)
+	}
+}
+
+func TestExecResultMountErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		mount string
+		want  string
+	}{
+		{name: "readonly bind", mount: `bk.bind(bk.image("alpine"), "/src")`, want: "read-only"},
+		{name: "cache", mount: `bk.cache("/src")`, want: "cache mounts"},
+		{name: "tmpfs", mount: `bk.tmpfs("/src")`, want: "tmpfs mounts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			L := NewVM(nil)
+			defer L.Close()
+
+			err := L.DoString(`
+				local build = bk.image("alpine"):run("true", { mounts = { ` + tt.mount + ` } })
+				build:mount("/src")
+			`)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	L := NewVM(nil)
+	defer L.Close()
+	if err := L.DoString(`bk.image("alpine"):run("true"):mount("/nope")`); err == nil || !strings.Contains(err.Error(), "no mount at /nope") {
+		t.Errorf("expected missing mount error, got %v", err)
+	}
+	if err := L.DoString(`bk.image("alpine"):mount("/")`); err == nil {
+		t.Error("expected mount() to be unavailable on a plain state")
+	}
+}
@@ -0,0 +1,72 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunSandboxOptions(t *testing.T) {
+	result, err := Evaluate(strings.NewReader(`
+local s = bk.image("alpine"):run("make integration", {
+    extra_hosts = { ["db.test"] = "10.0.0.5", ["api.test"] = "fd00::1" },
+    ulimits = { nproc = 512, nofile = { soft = 1024, hard = 65536 } },
+    cgroup_parent = "ci.slice",
+    remove_mount_stubs = true,
+    readonly_rootfs = true,
+})
+bk.export(s)
+`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+
+	exec := result.State.Op().Op().GetExec()
+	if hosts := exec.Meta.ExtraHosts; len(hosts) != 2 || hosts[0].Host != "api.test" || hosts[0].IP != "fd00::1" || hosts[1].Host != "db.test" {
+		t.Errorf("expected extra hosts sorted by name, got %v", hosts)
+	}
+	limits := exec.Meta.Ulimit
+	if len(limits) != 2 {
+		t.Fatalf("expected 2 ulimits, got %v", limits)
+	}
+	if l := limits[0]; l.Name != "nofile" || l.Soft != 1024 || l.Hard != 65536 {
+		t.Errorf("unexpected nofile ulimit %v", l)
+	}
+	if l := limits[1]; l.Name != "nproc" || l.Soft != 512 || l.Hard != 512 {
+		t.Errorf("unexpected nproc ulimit %v", l)
+	}
+	if exec.Meta.CgroupParent != "ci.slice" {
+		t.Errorf("expected cgroup parent ci.slice, got %q", exec.Meta.CgroupParent)
+	}
+	if !exec.Meta.RemoveMountStubsRecursive {
+		t.Error("expected remove_mount_stubs to be set")
+	}
+	if !exec.Mounts[0].Readonly {
+		t.Error("expected a read-only rootfs")
+	}
+}
+
+func TestRunSandboxOptionErrors(t *testing.T) {
+	tests := []struct {
+		opts    string
+		wantErr string
+	}{
+		{opts: `extra_hosts = "db.test=10.0.0.5"`, wantErr: "extra_hosts must be a table"},
+		{opts: `extra_hosts = { ["db.test"] = "10.0.0.256" }`, wantErr: "invalid IP address"},
+		{opts: `extra_hosts = { "10.0.0.5" }`, wantErr: "must map host names to IP addresses"},
+		{opts: `ulimits = { files = 10 }`, wantErr: `unknown ulimit "files"`},
+		{opts: `ulimits = { nofile = { soft = 2048, hard = 1024 } }`, wantErr: "exceeds hard limit"},
+		{opts: `ulimits = { nofile = { hard = 1024 } }`, wantErr: "needs a numeric soft limit"},
+		{opts: `ulimits = { nofile = "1024" }`, wantErr: "must be a number or"},
+		{opts: `cgroup_parent = true`, wantErr: "cgroup_parent must be a string"},
+		{opts: `remove_mount_stubs = "yes"`, wantErr: "remove_mount_stubs must be a boolean"},
+		{opts: `readonly_rootfs = 1`, wantErr: "readonly_rootfs must be a boolean"},
+	}
+
+	for _, tt := range tests {
+		script := `bk.export(bk.image("alpine"):run("true", { ` + tt.opts + ` }))`
+		_, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.opts, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package luavm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// contextFS returns the build context bk.read_file, bk.exists and bk.glob
+// read: ContextFS, else BuildContextDir, else nil.
+func contextFS(config *VMConfig) fs.FS {
+	if config.ContextFS != nil {
+		return config.ContextFS
+	}
+	if config.BuildContextDir != "" {
+		return rootFS(config.BuildContextDir)
+	}
+	return nil
+}
+
+// rootFS is a directory opened through os.Root for each access, so neither
+// ".." nor a symlink can reach outside it.
+type rootFS string
+
+func (dir rootFS) Open(name string) (fs.File, error) {
+	root, err := os.OpenRoot(string(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = root.Close() }()
+	return root.FS().Open(name)
+}
+
+// contextPath cleans a path given to fn and checks that it stays in the
+// build context. Paths are relative to the build context, with "/" as the
+// separator.
+func contextPath(L *lua.LState, fn, p string) string {
+	cleaned := path.Clean(p)
+	if p == "" || path.IsAbs(p) || cleaned == ".." || strings.HasPrefix(cleaned, "../") || !fs.ValidPath(cleaned) {
+		L.RaiseError("%s: path %q is outside the build context", fn, p)
+	}
+	return cleaned
+}
+
+// checkContextFS returns the build context, raising an error in fn when the
+// VM has none.
+func checkContextFS(L *lua.LState, fn string) fs.FS {
+	fsys := getVMData(L).contextFS
+	if fsys == nil {
+		L.RaiseError("%s: no build context", fn)
+	}
+	return fsys
+}
+
+// bkReadFile returns the contents of a file in the build context, as
+// bk.read_file(path). The path is recorded as an input of the build.
+func bkReadFile(L *lua.LState) int {
+	name := contextPath(L, "bk.read_file", L.CheckString(1))
+	fsys := checkContextFS(L, "bk.read_file")
+
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			L.RaiseError("bk.read_file: %s does not exist in the build context", name)
+		}
+		L.RaiseError("bk.read_file: %v", err)
+		return 0
+	}
+
+	d := getVMData(L)
+	d.fileReads = appendUnique(d.fileReads, name)
+	L.Push(lua.LString(data))
+	return 1
+}
+
+// bkExists reports whether a file or directory exists in the build context,
+// as bk.exists(path). The path is recorded as a lookup.
+func bkExists(L *lua.LState) int {
+	name := contextPath(L, "bk.exists", L.CheckString(1))
+	fsys := checkContextFS(L, "bk.exists")
+
+	d := getVMData(L)
+	d.fileLookups = appendUnique(d.fileLookups, name)
+
+	_, err := fs.Stat(fsys, name)
+	L.Push(lua.LBool(err == nil))
+	return 1
+}
+
+// bkGlob returns the sorted paths in the build context matching a
+// path.Match pattern, as bk.glob(pattern). The pattern is recorded as a
+// lookup.
+func bkGlob(L *lua.LState) int {
+	pattern := contextPath(L, "bk.glob", L.CheckString(1))
+	fsys := checkContextFS(L, "bk.glob")
+
+	matches, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		L.RaiseError("bk.glob: invalid pattern %q: %v", pattern, err)
+		return 0
+	}
+	slices.Sort(matches)
+
+	d := getVMData(L)
+	d.fileLookups = appendUnique(d.fileLookups, pattern)
+
+	tbl := L.CreateTable(len(matches), 0)
+	for _, m := range matches {
+		tbl.Append(lua.LString(m))
+	}
+	L.Push(tbl)
+	return 1
+}
+
+// appendUnique appends s to list unless it is already there.
+func appendUnique(list []string, s string) []string {
+	if slices.Contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
@@ -0,0 +1,118 @@
+package luavm
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const filesScript = `
+local mod = bk.read_file("go.mod")
+local version = mod:match("go (%d+%.%d+)")
+local services = bk.glob("services/*.json")
+bk.export(bk.scratch():env({
+    GO = version,
+    SERVICES = table.concat(services, ","),
+    HAS_MOD = tostring(bk.exists("./go.mod")),
+    HAS_DIR = tostring(bk.exists("services")),
+    HAS_LOCK = tostring(bk.exists("go.sum")),
+}))
+`
+
+func TestContextFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "go.mod"), "module example.com/app\n\ngo 1.22\n")
+	writeFile(t, filepath.Join(dir, "services", "b.json"), "{}")
+	writeFile(t, filepath.Join(dir, "services", "a.json"), "{}")
+	writeFile(t, filepath.Join(dir, "services", "notes.txt"), "")
+
+	fsys := fstest.MapFS{
+		"go.mod":          {Data: []byte("module example.com/app\n\ngo 1.22\n")},
+		"services/b.json": {Data: []byte("{}")},
+		"services/a.json": {Data: []byte("{}")},
+	}
+
+	for name, config := range map[string]*VMConfig{
+		"dir":    {BuildContextDir: dir},
+		"fs":     {ContextFS: fsys},
+		"fs+dir": {ContextFS: fsys, BuildContextDir: t.TempDir()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			env, result := evalEnv(t, filesScript, config)
+			want := map[string]string{
+				"GO":       "1.22",
+				"SERVICES": "services/a.json,services/b.json",
+				"HAS_MOD":  "true",
+				"HAS_DIR":  "true",
+				"HAS_LOCK": "false",
+			}
+			for k, v := range want {
+				if env[k] != v {
+					t.Errorf("%s: expected %q, got %q", k, v, env[k])
+				}
+			}
+			if !slices.Equal(result.FileReads, []string{"go.mod"}) {
+				t.Errorf("expected go.mod to be recorded, got %v", result.FileReads)
+			}
+			if want := []string{"go.mod", "go.sum", "services", "services/*.json"}; !slices.Equal(result.FileLookups, want) {
+				t.Errorf("expected lookups %v, got %v", want, result.FileLookups)
+			}
+		})
+	}
+}
+
+func TestContextFilesStayInContext(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "ctx")
+	writeFile(t, filepath.Join(parent, "secret.txt"), "secret")
+	writeFile(t, filepath.Join(dir, "build.lua"), "")
+	if err := os.Symlink(filepath.Join(parent, "secret.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	config := &VMConfig{BuildContextDir: dir}
+
+	tests := []struct {
+		script  string
+		wantErr string
+	}{
+		{`bk.read_file("../secret.txt")`, `bk.read_file: path "../secret.txt" is outside the build context`},
+		{`bk.read_file("a/../../secret.txt")`, "outside the build context"},
+		{`bk.read_file("/etc/passwd")`, "outside the build context"},
+		{`bk.glob("../*")`, "outside the build context"},
+		{`bk.exists("..")`, "outside the build context"},
+		{`bk.read_file("link.txt")`, "bk.read_file:"},
+		{`bk.read_file("missing.txt")`, "bk.read_file: missing.txt does not exist in the build context"},
+		{`bk.glob("[")`, "bk.glob: invalid pattern"},
+	}
+	for _, tt := range tests {
+		_, err := Evaluate(strings.NewReader(tt.script), "build.lua", config)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.script, tt.wantErr, err)
+		}
+	}
+
+	env, _ := evalEnv(t, `bk.export(bk.scratch():env({ LINK = tostring(bk.exists("link.txt")) }))`, config)
+	if env["LINK"] != "false" {
+		t.Errorf("expected a symlink leaving the context not to exist, got %s", env["LINK"])
+	}
+}
+
+func TestContextFilesWithoutContext(t *testing.T) {
+	_, err := Evaluate(strings.NewReader(`bk.read_file("go.mod")`), "build.lua", nil)
+	if err == nil || !strings.Contains(err.Error(), "bk.read_file: no build context") {
+		t.Errorf("expected a missing context error, got %v", err)
+	}
+}
+
+func writeFile(t *testing.T, name, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -56,8 +56,8 @@ func TestGitSerialization(t *testing.T) {
 		t.Fatal("Expected non-nil Attrs")
 	}
 
-	if sourceOp.Attrs["keepgitdir"] != "true" {
-		t.Errorf("Expected keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["keepgitdir"])
+	if sourceOp.Attrs["git.keepgitdir"] != "true" {
+		t.Errorf("Expected git.keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["git.keepgitdir"])
 	}
 
 	if op.Platform != nil {
@@ -106,8 +106,8 @@ func TestGitWithoutRefSerialization(t *testing.T) {
 		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, sourceOp.Identifier)
 	}
 
-	if _, hasKeepGitDir := sourceOp.Attrs["keepgitdir"]; hasKeepGitDir {
-		t.Error("Expected keepgitdir attribute to not be present")
+	if _, hasKeepGitDir := sourceOp.Attrs["git.keepgitdir"]; hasKeepGitDir {
+		t.Error("Expected git.keepgitdir attribute to not be present")
 	}
 }
 
@@ -1,6 +1,7 @@
 package luavm
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/kasuboski/luakit/pkg/dag"
@@ -124,8 +125,8 @@ func TestBkGitWithKeepGitDir(t *testing.T) {
 		t.Fatal("Expected SourceOp")
 	}
 
-	if sourceOp.Attrs["keepgitdir"] != "true" {
-		t.Errorf("Expected keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["keepgitdir"])
+	if sourceOp.Attrs["git.keepgitdir"] != "true" {
+		t.Errorf("Expected git.keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["git.keepgitdir"])
 	}
 }
 
@@ -164,8 +165,8 @@ func TestBkGitWithBothOptions(t *testing.T) {
 		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, sourceOp.Identifier)
 	}
 
-	if sourceOp.Attrs["keepgitdir"] != "true" {
-		t.Errorf("Expected keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["keepgitdir"])
+	if sourceOp.Attrs["git.keepgitdir"] != "true" {
+		t.Errorf("Expected git.keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["git.keepgitdir"])
 	}
 }
 
@@ -271,8 +272,8 @@ func TestBkGitWithoutOptions(t *testing.T) {
 		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, sourceOp.Identifier)
 	}
 
-	if _, hasKeepGitDir := sourceOp.Attrs["keepgitdir"]; hasKeepGitDir {
-		t.Error("Expected keepgitdir attribute to not be present")
+	if _, hasKeepGitDir := sourceOp.Attrs["git.keepgitdir"]; hasKeepGitDir {
+		t.Error("Expected git.keepgitdir attribute to not be present")
 	}
 }
 
@@ -307,3 +308,73 @@ func TestBkGitReturnsState(t *testing.T) {
 		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, sourceOp.Identifier)
 	}
 }
+
+func TestBkGitWithSourceOptions(t *testing.T) {
+	result, err := Evaluate(strings.NewReader(`
+local repo = bk.git("git@github.com:acme/monorepo.git", {
+    ref = "main",
+    subdir = "services/api",
+    checksum = "0123456789abcdef0123456789abcdef01234567",
+    submodules = false,
+    auth_token_secret = "gh_token",
+    auth_header_secret = "gh_header",
+    ssh = "deploy",
+    known_ssh_hosts = { "github.com ssh-ed25519 AAAA" },
+})
+bk.export(repo)
+`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+
+	sourceOp := result.State.Op().Op().GetSource()
+	if want := "git://git@github.com:acme/monorepo.git#main:services/api"; sourceOp.Identifier != want {
+		t.Errorf("Expected identifier '%s', got '%s'", want, sourceOp.Identifier)
+	}
+	want := map[string]string{
+		"git.checksum":         "0123456789abcdef0123456789abcdef01234567",
+		"git.skipsubmodules":   "true",
+		"git.authtokensecret":  "gh_token",
+		"git.authheadersecret": "gh_header",
+		"git.mountsshsock":     "deploy",
+		"git.knownsshhosts":    "github.com ssh-ed25519 AAAA\n",
+	}
+	for k, v := range want {
+		if sourceOp.Attrs[k] != v {
+			t.Errorf("Expected %s '%s', got '%s'", k, v, sourceOp.Attrs[k])
+		}
+	}
+}
+
+func TestBkGitWithoutSSHSock(t *testing.T) {
+	result, err := Evaluate(strings.NewReader(`bk.export(bk.git("git@github.com:acme/monorepo.git", { mount_ssh_sock = false }))`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+	if attrs := result.State.Op().Op().GetSource().Attrs; len(attrs) != 0 {
+		t.Errorf("Expected no attrs, got %v", attrs)
+	}
+}
+
+func TestBkGitOptionErrors(t *testing.T) {
+	tests := []struct {
+		opts    string
+		wantErr string
+	}{
+		{opts: `{ subdir = 1 }`, wantErr: "subdir must be a string"},
+		{opts: `{ auth_token_secret = "" }`, wantErr: "auth_token_secret must not be empty"},
+		{opts: `{ checksum = "v1.0.0" }`, wantErr: "checksum must be a commit SHA"},
+		{opts: `{ submodules = "no" }`, wantErr: "submodules must be a boolean"},
+		{opts: `{ mount_ssh_sock = "default" }`, wantErr: "mount_ssh_sock must be a boolean"},
+		{opts: `{ ssh = "deploy", mount_ssh_sock = false }`, wantErr: "ssh cannot be combined"},
+		{opts: `{ known_ssh_hosts = { 1 } }`, wantErr: "known_ssh_hosts entries must be strings"},
+		{opts: `{ known_ssh_hosts = true }`, wantErr: "known_ssh_hosts must be a string or a list"},
+	}
+
+	for _, tt := range tests {
+		_, err := Evaluate(strings.NewReader(`bk.export(bk.git("git@github.com:acme/monorepo.git", `+tt.opts+`))`), "build.lua", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.opts, tt.wantErr, err)
+		}
+	}
+}
@@ -18,7 +18,6 @@ func BenchmarkEvalHotPath100LineScript(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		if err := L.DoString(script); err != nil {
@@ -46,7 +45,6 @@ bk.export(result)
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		if err := L.DoString(script); err != nil {
@@ -69,7 +67,6 @@ func BenchmarkImageCallOnly(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		if err := L.DoString(`local base = bk.image("alpine:3.19")`); err != nil {
@@ -86,7 +83,6 @@ func BenchmarkRunCallOnly(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		if err := L.DoString(`local base = bk.image("alpine:3.19"); local result = base:run("echo test")`); err != nil {
@@ -0,0 +1,192 @@
+package luavm
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStateConfigFlowsIntoRuns(t *testing.T) {
+	script := `
+local base = bk.image("alpine:3.19")
+	:env("GOFLAGS", "-mod=vendor")
+	:env({ CGO_ENABLED = "0" })
+	:workdir("/src")
+	:workdir("app")
+	:user("builder")
+
+local built = base:run("go build ./...")
+local copied = built:mkdir("/out")
+bk.export(copied:run("ls /out", { env = { CGO_ENABLED = "1" }, user = "root" }))
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	last := result.State.Op().Op().GetExec()
+	if last == nil {
+		t.Fatal("expected exported state to be an exec op")
+	}
+	if last.Meta.Cwd != "/src/app" {
+		t.Errorf("expected cwd /src/app to survive the mkdir, got %q", last.Meta.Cwd)
+	}
+	if last.Meta.User != "root" {
+		t.Errorf("expected run option to override user, got %q", last.Meta.User)
+	}
+	wantEnv := []string{"GOFLAGS=-mod=vendor", "CGO_ENABLED=1"}
+	if !slices.Equal(last.Meta.Env, wantEnv) {
+		t.Errorf("expected env %v, got %v", wantEnv, last.Meta.Env)
+	}
+
+	first := result.State.Op().Inputs()[0].Node().Inputs()[0].Node().Op().GetExec()
+	if first == nil {
+		t.Fatal("expected first run to be an exec op")
+	}
+	if first.Meta.User != "builder" {
+		t.Errorf("expected user builder, got %q", first.Meta.User)
+	}
+	if !slices.Equal(first.Meta.Env, []string{"GOFLAGS=-mod=vendor", "CGO_ENABLED=0"}) {
+		t.Errorf("unexpected env %v", first.Meta.Env)
+	}
+}
+
+func TestEnvTablesAreOrdered(t *testing.T) {
+	script := `
+local app = bk.image("alpine:3.19")
+	:env({ ZETA = "z", ALPHA = "a", MIDDLE = "m", BETA = "b", OMEGA = "o" })
+	:label({ ["org.z"] = "z", ["org.a"] = "a", ["org.m"] = "m" })
+bk.export(app:run("env", { env = { RUN_Z = "z", RUN_A = "a", RUN_M = "m" } }))
+`
+	var digest string
+	for i := range 20 {
+		result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+		if err != nil {
+			t.Fatalf("Evaluate failed: %v", err)
+		}
+		d := result.State.Op().DigestString()
+		if i == 0 {
+			digest = d
+			wantEnv := []string{"ALPHA=a", "BETA=b", "MIDDLE=m", "OMEGA=o", "ZETA=z", "RUN_A=a", "RUN_M=m", "RUN_Z=z"}
+			if env := result.State.Op().Op().GetExec().Meta.Env; !slices.Equal(env, wantEnv) {
+				t.Errorf("expected env %v, got %v", wantEnv, env)
+			}
+			if env := result.ImageConfig.Config.Env; !slices.Equal(env, wantEnv[:5]) {
+				t.Errorf("expected image env %v, got %v", wantEnv[:5], env)
+			}
+		} else if d != digest {
+			t.Fatalf("evaluation %d: digest %s differs from %s", i, d, digest)
+		}
+	}
+}
+
+func TestStateConfigIsExported(t *testing.T) {
+	script := `
+local app = bk.image("alpine:3.19")
+	:env("PORT", "8080")
+	:workdir("/app")
+	:user("app")
+	:entrypoint({ "/app/server" })
+	:cmd("serve")
+	:label("org.opencontainers.image.title", "app")
+
+bk.export(app, { env = { PORT = "9090", MODE = "prod" }, labels = { team = "infra" } })
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	config := result.ImageConfig
+	if config == nil {
+		t.Fatal("expected image config")
+	}
+	if !slices.Equal(config.Config.Env, []string{"PORT=9090", "MODE=prod"}) {
+		t.Errorf("expected export env to override state env, got %v", config.Config.Env)
+	}
+	if config.Config.WorkingDir != "/app" || config.Config.User != "app" {
+		t.Errorf("unexpected workdir %q or user %q", config.Config.WorkingDir, config.Config.User)
+	}
+	if !slices.Equal(config.Config.Entrypoint, []string{"/app/server"}) {
+		t.Errorf("unexpected entrypoint %v", config.Config.Entrypoint)
+	}
+	if !slices.Equal(config.Config.Cmd, []string{"/bin/sh", "-c", "serve"}) {
+		t.Errorf("unexpected cmd %v", config.Config.Cmd)
+	}
+	if config.Config.Labels["org.opencontainers.image.title"] != "app" || config.Config.Labels["team"] != "infra" {
+		t.Errorf("expected state and export labels, got %v", config.Config.Labels)
+	}
+}
+
+func TestStateConfigWithoutExportOptions(t *testing.T) {
+	result, err := Evaluate(strings.NewReader(`bk.export(bk.scratch():user("nobody"))`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.ImageConfig == nil || result.ImageConfig.Config.User != "nobody" {
+		t.Errorf("expected exported user nobody, got %+v", result.ImageConfig)
+	}
+
+	result, err = Evaluate(strings.NewReader(`bk.export(bk.scratch())`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.ImageConfig != nil {
+		t.Errorf("expected no image config without state config or options, got %+v", result.ImageConfig)
+	}
+}
+
+func TestStateConfigIsImmutable(t *testing.T) {
+	script := `
+local base = bk.image("alpine:3.19"):env("A", "1")
+local other = base:env("A", "2")
+bk.export(base:run("env"))
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	env := result.State.Op().Op().GetExec().Meta.Env
+	if !slices.Equal(env, []string{"A=1"}) {
+		t.Errorf("expected setting env on a derived state to leave the base alone, got %v", env)
+	}
+}
+
+func TestStateConfigMultiPlatform(t *testing.T) {
+	platforms, err := ParsePlatforms("linux/amd64,linux/arm64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := `
+bk.export(function(p)
+	return bk.scratch():env("PLATFORM", tostring(p))
+end)
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", &VMConfig{Platforms: platforms})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	for _, ps := range result.Platforms {
+		want := "PLATFORM=" + formatPlatform(ps.Platform)
+		if !slices.Equal(ps.ImageConfig.Config.Env, []string{want}) {
+			t.Errorf("expected %s, got %v", want, ps.ImageConfig.Config.Env)
+		}
+	}
+}
+
+func TestStateConfigErrors(t *testing.T) {
+	tests := []struct {
+		script  string
+		wantErr string
+	}{
+		{`bk.scratch():env(1)`, "key string or table expected"},
+		{`bk.scratch():workdir("")`, "path must not be empty"},
+		{`bk.scratch():entrypoint(42)`, "command must be string or table"},
+	}
+	for _, tt := range tests {
+		_, err := Evaluate(strings.NewReader(tt.script), "build.lua", nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.script, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package luavm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+)
+
+// Errors wrapped by a LimitError when an evaluation is stopped by one of
+// the limits in VMConfig.
+var (
+	ErrTimeout           = errors.New("evaluation timed out")
+	ErrCallStackOverflow = errors.New("call stack overflow")
+	ErrRegistryOverflow  = errors.New("registry overflow")
+	ErrTooManyNodes      = errors.New("too many DAG nodes")
+)
+
+// LimitError reports the Lua line that was running when an evaluation hit
+// a limit or its context was canceled. Err is one of the errors above or
+// context.Canceled.
+type LimitError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *LimitError) Error() string {
+	if e.File == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// luaErrorLocation matches the "file:line: " gopher-lua puts in front of
+// runtime errors.
+var luaErrorLocation = regexp.MustCompile(`^(.+?):(\d+): (.*)$`)
+
+// pcall calls the function below nargs arguments on the stack, turning a
+// limit hit by the script into a LimitError.
+func (d *vmData) pcall(L *lua.LState, nargs, nret int) (err error) {
+	defer func() {
+		// gopher-lua can panic while raising a registry overflow, after
+		// PCall's own recovery has run.
+		if r := recover(); r != nil {
+			file, line := innermostLine(L)
+			err = &LimitError{File: file, Line: line, Err: d.registryErr()}
+		}
+	}()
+
+	err = L.PCall(nargs, nret, nil)
+	if err == nil {
+		return nil
+	}
+	if limitErr := d.limitError(err); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// limitError returns the LimitError err stands for, or nil if the script
+// failed for another reason.
+func (d *vmData) limitError(err error) *LimitError {
+	var apiErr *lua.ApiError
+	if !errors.As(err, &apiErr) || apiErr.Object == nil {
+		return nil
+	}
+	msg := apiErr.Object.String()
+	var file string
+	var line int
+	if m := luaErrorLocation.FindStringSubmatch(msg); m != nil {
+		file, msg = m[1], m[3]
+		line, _ = strconv.Atoi(m[2])
+	}
+
+	var limit error
+	switch {
+	case d.ctx != nil && d.ctx.Err() != nil:
+		limit = d.ctx.Err()
+		if errors.Is(limit, context.DeadlineExceeded) {
+			limit = ErrTimeout
+			if d.timeout > 0 {
+				limit = fmt.Errorf("%w after %s", ErrTimeout, d.timeout)
+			}
+		}
+	case d.nodeLimitHit && strings.HasPrefix(msg, ErrTooManyNodes.Error()):
+		limit = fmt.Errorf("%w (limit %d)", ErrTooManyNodes, d.maxNodes)
+	case msg == "stack overflow":
+		limit = ErrCallStackOverflow
+		if d.callStackSize > 0 {
+			limit = fmt.Errorf("%w (limit %d)", ErrCallStackOverflow, d.callStackSize)
+		}
+	case msg == "registry overflow":
+		limit = d.registryErr()
+	default:
+		return nil
+	}
+	return &LimitError{File: file, Line: line, Err: limit}
+}
+
+func (d *vmData) registryErr() error {
+	if d.registrySize > 0 {
+		return fmt.Errorf("%w (limit %d)", ErrRegistryOverflow, d.registrySize)
+	}
+	return ErrRegistryOverflow
+}
+
+// innermostLine returns the innermost Lua line on the call stack.
+func innermostLine(L *lua.LState) (string, int) {
+	for level := 0; ; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			return "", 0
+		}
+		if _, err := L.GetInfo("Sl", dbg, lua.LNil); err == nil && dbg.CurrentLine > 0 {
+			return dbg.Source, dbg.CurrentLine
+		}
+	}
+}
+
+// countNode records that the script created node, raising an error once it
+// has created more than VMConfig.MaxNodes.
+func (d *vmData) countNode(L *lua.LState, node *dag.OpNode) {
+	if d.maxNodes <= 0 || node == nil {
+		return
+	}
+	if _, ok := d.nodes[node]; ok {
+		return
+	}
+	if len(d.nodes) >= d.maxNodes {
+		d.nodeLimitHit = true
+		L.RaiseError("%v", ErrTooManyNodes)
+		return
+	}
+	d.nodes[node] = struct{}{}
+}
@@ -0,0 +1,110 @@
+package luavm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		config  *VMConfig
+		want    error
+		wantMsg string
+	}{
+		{
+			name:    "timeout",
+			script:  "local n = 0\nwhile true do n = n + 1 end",
+			config:  &VMConfig{Timeout: 50 * time.Millisecond},
+			want:    ErrTimeout,
+			wantMsg: "build.lua:2: evaluation timed out after 50ms",
+		},
+		{
+			name:    "timeout in target",
+			script:  "bk.target(\"dev\", function()\n  while true do end\nend)",
+			config:  &VMConfig{Timeout: 50 * time.Millisecond},
+			want:    ErrTimeout,
+			wantMsg: "build.lua:2: evaluation timed out",
+		},
+		{
+			name:    "call stack",
+			script:  "local function f() return 1 + f() end\nf()",
+			config:  &VMConfig{CallStackSize: 64},
+			want:    ErrCallStackOverflow,
+			wantMsg: "build.lua:1: call stack overflow (limit 64)",
+		},
+		{
+			name:    "registry",
+			script:  "local function f(...) return f(1, ...) end\nf()",
+			config:  &VMConfig{RegistrySize: 1024},
+			want:    ErrRegistryOverflow,
+			wantMsg: "registry overflow (limit 1024)",
+		},
+		{
+			name:    "nodes",
+			script:  "local s = bk.scratch()\nfor i = 1, 100 do\n  s = s:mkdir(\"/d\" .. i)\nend\nbk.export(s)",
+			config:  &VMConfig{MaxNodes: 10},
+			want:    ErrTooManyNodes,
+			wantMsg: "build.lua:3: too many DAG nodes (limit 10)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Evaluate(strings.NewReader(tt.script), "build.lua", tt.config)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || limitErr.File != "build.lua" || limitErr.Line == 0 {
+				t.Errorf("expected a located LimitError, got %#v", limitErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("expected error containing %q, got %q", tt.wantMsg, err)
+			}
+		})
+	}
+}
+
+func TestEvaluateContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err := EvaluateContext(ctx, strings.NewReader("while true do end"), "build.lua", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Error("a canceled evaluation did not time out")
+	}
+}
+
+func TestEvaluateWithinLimits(t *testing.T) {
+	script := `
+local s = bk.image("alpine")
+for i = 1, 5 do
+  s = s:run("echo " .. i)
+end
+bk.export(s)
+`
+	config := &VMConfig{Timeout: time.Minute, CallStackSize: 64, RegistrySize: 1024, MaxNodes: 6}
+	result, err := Evaluate(strings.NewReader(script), "build.lua", config)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.State == nil {
+		t.Error("expected an exported state")
+	}
+
+	config.MaxNodes = 5
+	if _, err := Evaluate(strings.NewReader(script), "build.lua", config); !errors.Is(err, ErrTooManyNodes) {
+		t.Errorf("expected the sixth node to hit the limit, got %v", err)
+	}
+}
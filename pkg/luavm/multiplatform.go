@@ -0,0 +1,223 @@
+package luavm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/platforms"
+	pb "github.com/moby/buildkit/solver/pb"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+	"github.com/kasuboski/luakit/pkg/resolver"
+)
+
+// PlatformState is the exported State for a single target platform of a
+// multi-platform export.
+type PlatformState struct {
+	Platform    *pb.Platform
+	State       *dag.State
+	ImageConfig *dockerspec.DockerOCIImage
+}
+
+// exportPlatformFunc calls fn once per requested platform, passing a
+// luakit.platform value, and collects the returned States. Without requested
+// platforms the default build platform is used.
+func exportPlatformFunc(L *lua.LState, fn *lua.LFunction, platforms []*pb.Platform) []*PlatformState {
+	if len(platforms) == 0 {
+		def := resolver.DefaultPlatform()
+		platforms = []*pb.Platform{{
+			OS:           def.OS,
+			Architecture: def.Architecture,
+			Variant:      def.Variant,
+		}}
+	}
+
+	result := make([]*PlatformState, 0, len(platforms))
+	for _, platform := range platforms {
+		ud := L.NewUserData()
+		ud.Value = platform
+		L.SetMetatable(ud, L.GetTypeMetatable(luaPlatformTypeName))
+
+		L.Push(fn)
+		L.Push(ud)
+		L.Call(1, 1)
+		ret := L.Get(-1)
+		L.Pop(1)
+
+		state := stateFromValue(ret)
+		if state == nil {
+			L.RaiseError("bk.export: function must return a state for platform %s", formatPlatform(platform))
+			return nil
+		}
+
+		result = append(result, newPlatformState(state, platform))
+	}
+
+	return result
+}
+
+// exportPlatformTable collects States from a table keyed by platform string,
+// e.g. { ["linux/amd64"] = a, ["linux/arm64"] = b }. Entries are sorted by
+// platform so the export order is deterministic. When platforms were
+// requested, only those entries are exported.
+func exportPlatformTable(L *lua.LState, table *lua.LTable, requested []*pb.Platform) []*PlatformState {
+	var keys []string
+	states := make(map[string]*dag.State)
+
+	table.ForEach(func(key, value lua.LValue) {
+		if key.Type() != lua.LTString {
+			L.RaiseError("bk.export: platform table keys must be platform strings")
+			return
+		}
+		state := stateFromValue(value)
+		if state == nil {
+			L.RaiseError("bk.export: value for platform %q must be a state", key.String())
+			return
+		}
+		keys = append(keys, key.String())
+		states[key.String()] = state
+	})
+
+	if len(keys) == 0 {
+		L.RaiseError("bk.export: platform table must not be empty")
+		return nil
+	}
+
+	sort.Strings(keys)
+
+	byPlatform := make(map[string]*PlatformState, len(keys))
+	result := make([]*PlatformState, 0, len(keys))
+	for _, key := range keys {
+		parsed, err := ParsePlatforms(key)
+		if err != nil || len(parsed) != 1 {
+			L.RaiseError("bk.export: invalid platform %q, expected os/arch[/variant]", key)
+			return nil
+		}
+		ps := &PlatformState{Platform: parsed[0], State: states[key]}
+		byPlatform[formatPlatform(parsed[0])] = ps
+		result = append(result, ps)
+	}
+
+	if len(requested) > 0 {
+		result = result[:0]
+		for _, p := range requested {
+			ps, ok := byPlatform[formatPlatform(p)]
+			if !ok {
+				L.RaiseError("bk.export: requested platform %s is not exported (available: %s)", formatPlatform(p), strings.Join(keys, ", "))
+				return nil
+			}
+			result = append(result, ps)
+		}
+	}
+
+	for i, ps := range result {
+		result[i] = newPlatformState(ps.State, ps.Platform)
+	}
+
+	return result
+}
+
+// exportPlatformOption exports state for the platform given with
+// bk.export(state, { platform = ... }). It cannot be combined with a platform
+// table or function, and must be the requested platform, if any.
+func exportPlatformOption(L *lua.LState, state *dag.State, opts *lua.LTable, requested []*pb.Platform) []*PlatformState {
+	if state == nil {
+		L.RaiseError("bk.export: platform option cannot be used with a platform table or function")
+		return nil
+	}
+	platform := parsePlatform(L, opts)
+	if platform == nil || platform.OS == "" || platform.Architecture == "" {
+		L.RaiseError("bk.export: invalid platform option, expected os/arch[/variant]")
+		return nil
+	}
+	for _, p := range requested {
+		if formatPlatform(p) != formatPlatform(platform) {
+			L.RaiseError("bk.export: requested platform %s is not exported (available: %s)", formatPlatform(p), formatPlatform(platform))
+			return nil
+		}
+	}
+	return []*PlatformState{newPlatformState(state, platform)}
+}
+
+// exportRequestedPlatforms builds state once per requested platform, as a
+// Dockerfile stage is built for each --platform.
+func exportRequestedPlatforms(state *dag.State, requested []*pb.Platform) []*PlatformState {
+	result := make([]*PlatformState, 0, len(requested))
+	for _, platform := range requested {
+		result = append(result, newPlatformState(state, platform))
+	}
+	return result
+}
+
+// newPlatformState builds state for platform on a copy of its graph, so
+// platforms sharing a base each get their own.
+func newPlatformState(state *dag.State, platform *pb.Platform) *PlatformState {
+	return &PlatformState{
+		Platform: platform,
+		State:    state.ApplyPlatform(platform).WithPlatform(platform),
+	}
+}
+
+func stateFromValue(v lua.LValue) *dag.State {
+	ud, ok := v.(*lua.LUserData)
+	if !ok {
+		return nil
+	}
+	state, _ := stateValue(ud.Value)
+	return state
+}
+
+// platformImageConfig returns a copy of config with OS, architecture and
+// variant taken from the platform. A nil config yields a default one.
+func platformImageConfig(config *dockerspec.DockerOCIImage, platform *pb.Platform) *dockerspec.DockerOCIImage {
+	clone := newImageConfig()
+	if config != nil {
+		dt, err := json.Marshal(config)
+		if err == nil {
+			clone = &dockerspec.DockerOCIImage{}
+			if err := json.Unmarshal(dt, clone); err != nil {
+				clone = newImageConfig()
+			}
+		}
+	}
+
+	clone.OS = platform.OS
+	clone.Architecture = platform.Architecture
+	clone.Variant = platform.Variant
+	return clone
+}
+
+// ParsePlatforms parses a comma-separated platform list such as
+// "linux/amd64,linux/arm64/v8" into normalized platforms.
+func ParsePlatforms(v string) ([]*pb.Platform, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+
+	var result []*pb.Platform
+	for _, s := range strings.Split(v, ",") {
+		p, err := platforms.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid platform %q: %w", s, err)
+		}
+		p = platforms.Normalize(p)
+		result = append(result, &pb.Platform{
+			OS:           p.OS,
+			Architecture: p.Architecture,
+			Variant:      p.Variant,
+		})
+	}
+	return result, nil
+}
+
+func formatPlatform(p *pb.Platform) string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
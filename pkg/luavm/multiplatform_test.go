@@ -0,0 +1,258 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/moby/buildkit/solver/pb"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+)
+
+func TestExportPlatformFunction(t *testing.T) {
+	script := `
+local seen = {}
+bk.export(function(platform)
+	table.insert(seen, tostring(platform))
+	return bk.image("alpine:3.19", { platform = platform }):run("uname -m")
+end, { entrypoint = { "/bin/sh" } })
+`
+	config := &VMConfig{Platforms: []*pb.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	}}
+
+	result, err := Evaluate(strings.NewReader(script), "build.lua", config)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	if len(result.Platforms) != 2 {
+		t.Fatalf("expected 2 platform states, got %d", len(result.Platforms))
+	}
+
+	for i, want := range config.Platforms {
+		ps := result.Platforms[i]
+		if ps.Platform.Architecture != want.Architecture {
+			t.Errorf("platform %d: expected arch %s, got %s", i, want.Architecture, ps.Platform.Architecture)
+		}
+		if ps.ImageConfig.Architecture != want.Architecture || ps.ImageConfig.Variant != want.Variant {
+			t.Errorf("platform %d: image config has %s/%s", i, ps.ImageConfig.Architecture, ps.ImageConfig.Variant)
+		}
+		if len(ps.ImageConfig.Config.Entrypoint) != 1 {
+			t.Errorf("platform %d: expected export options to be applied", i)
+		}
+		if p := ps.State.Op().Op().Platform; p == nil || p.Architecture != want.Architecture {
+			t.Errorf("platform %d: expected exec op platform %s, got %v", i, want.Architecture, p)
+		}
+	}
+
+	if result.State != result.Platforms[0].State {
+		t.Error("expected State to refer to the first platform")
+	}
+}
+
+func TestExportPlatformFunctionDefaultPlatform(t *testing.T) {
+	script := `bk.export(function(platform) return bk.scratch() end)`
+
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(result.Platforms) != 1 {
+		t.Fatalf("expected 1 platform state, got %d", len(result.Platforms))
+	}
+	if result.Platforms[0].Platform.OS != "linux" {
+		t.Errorf("expected default linux platform, got %v", result.Platforms[0].Platform)
+	}
+}
+
+func TestExportPlatformTable(t *testing.T) {
+	script := `
+bk.export({
+	["linux/arm64"] = bk.image("alpine:3.19"),
+	["linux/amd64"] = bk.image("alpine:3.19"),
+})
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(result.Platforms) != 2 {
+		t.Fatalf("expected 2 platform states, got %d", len(result.Platforms))
+	}
+	if result.Platforms[0].Platform.Architecture != "amd64" || result.Platforms[1].Platform.Architecture != "arm64" {
+		t.Errorf("expected platforms sorted amd64, arm64; got %v, %v", result.Platforms[0].Platform, result.Platforms[1].Platform)
+	}
+
+	result, err = Evaluate(strings.NewReader(script), "build.lua", &VMConfig{
+		Platforms: []*pb.Platform{{OS: "linux", Architecture: "arm64"}},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(result.Platforms) != 1 || result.Platforms[0].Platform.Architecture != "arm64" {
+		t.Errorf("expected only the requested arm64 platform, got %v", result.Platforms)
+	}
+}
+
+func TestExportPlatformTableSharedBase(t *testing.T) {
+	script := `
+local app = bk.image("alpine:3.19"):run("make")
+bk.export({ ["linux/amd64"] = app, ["linux/arm64"] = app })
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+
+	for _, ps := range result.Platforms {
+		def, err := dag.Serialize(ps.State, nil)
+		if err != nil {
+			t.Fatalf("serialize %s: %v", formatPlatform(ps.Platform), err)
+		}
+		for _, dt := range def.Def {
+			var op pb.Op
+			if err := op.UnmarshalVT(dt); err != nil {
+				t.Fatal(err)
+			}
+			if op.Op != nil && (op.Platform == nil || op.Platform.Architecture != ps.Platform.Architecture) {
+				t.Errorf("%s: expected every op on its platform, got %v", formatPlatform(ps.Platform), op.Platform)
+			}
+		}
+	}
+}
+
+func TestExportPlatformOption(t *testing.T) {
+	script := `bk.export(bk.image("alpine:3.19"):run("make"), { platform = "linux/arm64", entrypoint = { "/app" } })`
+
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(result.Platforms) != 1 || result.Platforms[0].Platform.Architecture != "arm64" {
+		t.Fatalf("expected one arm64 platform, got %v", result.Platforms)
+	}
+	if p := result.State.Op().Op().Platform; p == nil || p.Architecture != "arm64" {
+		t.Errorf("expected the exec op on arm64, got %v", p)
+	}
+	if result.ImageConfig.Architecture != "arm64" || len(result.ImageConfig.Config.Entrypoint) != 1 {
+		t.Errorf("expected an arm64 image config with the export options, got %+v", result.ImageConfig)
+	}
+}
+
+func TestExportRequestedPlatforms(t *testing.T) {
+	script := `bk.export(bk.image("alpine:3.19"):run("make"), { entrypoint = { "/app" } })`
+	config := &VMConfig{Platforms: []*pb.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+	}}
+
+	result, err := Evaluate(strings.NewReader(script), "build.lua", config)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if len(result.Platforms) != 2 {
+		t.Fatalf("expected one state per requested platform, got %d", len(result.Platforms))
+	}
+	for i, want := range []string{"amd64", "arm64"} {
+		ps := result.Platforms[i]
+		if ps.Platform.Architecture != want {
+			t.Errorf("platform %d: expected %s, got %s", i, want, ps.Platform.Architecture)
+		}
+		if p := ps.State.Op().Op().Platform; p == nil || p.Architecture != want {
+			t.Errorf("platform %d: expected the exec op on %s, got %v", i, want, p)
+		}
+		if ps.ImageConfig.Architecture != want || len(ps.ImageConfig.Config.Entrypoint) != 1 {
+			t.Errorf("platform %d: expected a %s image config with the export options, got %+v", i, want, ps.ImageConfig)
+		}
+	}
+	if result.Platforms[0].State.Op() == result.Platforms[1].State.Op() {
+		t.Error("expected each platform to get its own graph")
+	}
+}
+
+func TestExportPlatformErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		script    string
+		platforms []*pb.Platform
+		wantErr   string
+	}{
+		{
+			name:    "empty table",
+			script:  `bk.export({})`,
+			wantErr: "platform table must not be empty",
+		},
+		{
+			name:    "non-state value",
+			script:  `bk.export({ ["linux/amd64"] = "nope" })`,
+			wantErr: `value for platform "linux/amd64" must be a state`,
+		},
+		{
+			name:    "invalid platform key",
+			script:  `bk.export({ ["not a platform!"] = bk.scratch() })`,
+			wantErr: "invalid platform",
+		},
+		{
+			name:      "requested platform missing",
+			script:    `bk.export({ ["linux/amd64"] = bk.scratch() })`,
+			platforms: []*pb.Platform{{OS: "linux", Architecture: "s390x"}},
+			wantErr:   "requested platform linux/s390x is not exported",
+		},
+		{
+			name:    "platform option with a table",
+			script:  `bk.export({ ["linux/amd64"] = bk.scratch() }, { platform = "linux/arm64" })`,
+			wantErr: "platform option cannot be used with a platform table or function",
+		},
+		{
+			name:    "invalid platform option",
+			script:  `bk.export(bk.scratch(), { platform = "arm64" })`,
+			wantErr: "invalid platform option",
+		},
+		{
+			name:      "platform option not requested",
+			script:    `bk.export(bk.scratch(), { platform = "linux/arm64" })`,
+			platforms: []*pb.Platform{{OS: "linux", Architecture: "amd64"}},
+			wantErr:   "requested platform linux/amd64 is not exported (available: linux/arm64)",
+		},
+		{
+			name:    "function returns nothing",
+			script:  `bk.export(function(p) end)`,
+			wantErr: "function must return a state",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Evaluate(strings.NewReader(tt.script), "build.lua", &VMConfig{Platforms: tt.platforms})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := ParsePlatforms("linux/amd64, linux/arm/v7")
+	if err != nil {
+		t.Fatalf("ParsePlatforms failed: %v", err)
+	}
+	if len(platforms) != 2 {
+		t.Fatalf("expected 2 platforms, got %d", len(platforms))
+	}
+	if platforms[1].Architecture != "arm" || platforms[1].Variant != "v7" {
+		t.Errorf("unexpected platform: %v", platforms[1])
+	}
+
+	if platforms, err := ParsePlatforms(""); err != nil || platforms != nil {
+		t.Errorf("expected no platforms for empty string, got %v, %v", platforms, err)
+	}
+
+	if _, err := ParsePlatforms("linux/amd64,???"); err == nil {
+		t.Error("expected error for invalid platform")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/fstest"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -320,3 +321,36 @@ result = count2
 		t.Errorf("Expected module to be cached (result should be 2), got %s", result.String())
 	}
 }
+
+func TestRequireFromContextFS(t *testing.T) {
+	defer resetExportedState()
+
+	contextFS := fstest.MapFS{
+		"lib/go.lua":        {Data: []byte(`return { name = "go" }`)},
+		"lib/node/init.lua": {Data: []byte(`return { name = "node" }`)},
+	}
+	stdlibFS := fstest.MapFS{
+		"prelude.lua": {Data: []byte(`return { name = "prelude" }`)},
+	}
+
+	L := NewVM(&VMConfig{
+		ContextFS: contextFS,
+		StdlibFS:  stdlibFS,
+	})
+	defer L.Close()
+
+	script := `
+result = require("lib.go").name .. "," .. require("lib/node").name .. "," .. require("prelude").name
+escaped = pcall(require, "../secret")
+`
+	if err := L.DoString(script); err != nil {
+		t.Fatalf("Failed to execute script: %v", err)
+	}
+
+	if result := L.GetGlobal("result").String(); result != "go,node,prelude" {
+		t.Errorf("Expected 'go,node,prelude', got '%s'", result)
+	}
+	if L.GetGlobal("escaped") != lua.LFalse {
+		t.Error("Expected require outside the context to fail")
+	}
+}
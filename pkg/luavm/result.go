@@ -1,6 +1,11 @@
 package luavm
 
 import (
+	"strconv"
+	"time"
+
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	commonexptypes "github.com/moby/buildkit/exporter/exptypes"
 	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
 
 	"github.com/kasuboski/luakit/pkg/dag"
@@ -9,5 +14,78 @@ import (
 type EvalResult struct {
 	State       *dag.State
 	ImageConfig *dockerspec.DockerOCIImage
-	SourceFiles map[string][]byte
+	// InheritImageConfig is false when bk.export was called with
+	// inherit = false. Otherwise ImageConfig is layered over the config of
+	// the base image at serialization.
+	InheritImageConfig bool
+	// Annotations are the OCI annotations set with bk.export.
+	Annotations Annotations
+	// SourceDateEpoch is the epoch set with bk.set_epoch or VMConfig, if
+	// any. File timestamps, SOURCE_DATE_EPOCH in runs and the image creation
+	// time are set from it at serialization.
+	SourceDateEpoch *time.Time
+	SourceFiles     map[string][]byte
+	// Platforms holds one entry per platform when the script exported a
+	// platform table or function. State and ImageConfig then refer to the
+	// first entry.
+	Platforms []*PlatformState
+	// Target is the name of the bk.target that was built, if any.
+	Target string
+	// Targets lists every bk.target the script defined, in definition order.
+	Targets []string
+	// Args lists the build arguments declared with bk.arg, in declaration
+	// order.
+	Args []ArgSpec
+	// EnvReads lists the environment variables the script read with
+	// os.getenv, sorted.
+	EnvReads []string
+	// FileReads lists the build context files the script read with
+	// bk.read_file, sorted. They are inputs of the build like the script.
+	FileReads []string
+	// FileLookups lists the paths given to bk.exists and the patterns given
+	// to bk.glob, sorted. Adding or removing a matching file can change the
+	// build.
+	FileLookups []string
+}
+
+// States returns the exported State followed by the State of every exported
+// platform.
+func (r *EvalResult) States() []*dag.State {
+	states := []*dag.State{r.State}
+	for _, ps := range r.Platforms {
+		states = append(states, ps.State)
+	}
+	return states
+}
+
+// Annotations holds the OCI annotations set with bk.export.
+type Annotations struct {
+	// Manifest annotations are added to the image manifest of every platform.
+	Manifest map[string]string
+	// Index annotations are added to the image index of a multi-platform
+	// image.
+	Index map[string]string
+}
+
+// ExportMetadata returns the result metadata read by the BuildKit image
+// exporters: the annotations and the source date epoch.
+func (r *EvalResult) ExportMetadata() map[string][]byte {
+	meta := r.Annotations.Metadata()
+	if r.SourceDateEpoch != nil {
+		meta[commonexptypes.ExporterEpochKey] = []byte(strconv.FormatInt(r.SourceDateEpoch.Unix(), 10))
+	}
+	return meta
+}
+
+// Metadata returns the annotations as the result metadata read by the
+// BuildKit image exporters.
+func (a Annotations) Metadata() map[string][]byte {
+	meta := make(map[string][]byte, len(a.Manifest)+len(a.Index))
+	for k, v := range a.Manifest {
+		meta[exptypes.AnnotationManifestKey(nil, k)] = []byte(v)
+	}
+	for k, v := range a.Index {
+		meta[exptypes.AnnotationIndexKey(k)] = []byte(v)
+	}
+	return meta
 }
@@ -0,0 +1,67 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunSecretEnv(t *testing.T) {
+	result, err := Evaluate(strings.NewReader(`
+local s = bk.image("alpine"):run("npm publish", {
+    env = { CI = "true" },
+    secret_env = {
+        NPM_TOKEN = "npm_token_id",
+        AWS_PROFILE = { id = "aws", optional = true },
+    },
+})
+bk.export(s)
+`), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+
+	exec := result.State.Op().Op().GetExec()
+	if exec == nil {
+		t.Fatal("expected an exec op")
+	}
+	if len(exec.Secretenv) != 2 {
+		t.Fatalf("expected 2 secret env entries, got %d", len(exec.Secretenv))
+	}
+	if se := exec.Secretenv[0]; se.Name != "AWS_PROFILE" || se.ID != "aws" || !se.Optional {
+		t.Errorf("unexpected secret env %+v", se)
+	}
+	if se := exec.Secretenv[1]; se.Name != "NPM_TOKEN" || se.ID != "npm_token_id" || se.Optional {
+		t.Errorf("unexpected secret env %+v", se)
+	}
+	for _, kv := range exec.Meta.Env {
+		if strings.HasPrefix(kv, "NPM_TOKEN=") || strings.HasPrefix(kv, "AWS_PROFILE=") {
+			t.Errorf("secret env must not be set in Meta.Env, got %q", kv)
+		}
+	}
+}
+
+func TestRunSecretEnvErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    string
+		wantErr string
+	}{
+		{name: "not a table", opts: `secret_env = "npm"`, wantErr: "secret_env must be a table"},
+		{name: "empty id", opts: `secret_env = { NPM_TOKEN = "" }`, wantErr: "secret id must not be empty"},
+		{name: "missing id", opts: `secret_env = { NPM_TOKEN = { optional = true } }`, wantErr: "secret id must not be empty"},
+		{name: "bad value", opts: `secret_env = { NPM_TOKEN = 1 }`, wantErr: "must be a secret id or a table"},
+		{name: "list entry", opts: `secret_env = { "npm" }`, wantErr: "invalid variable name"},
+		{name: "bad name", opts: `secret_env = { ["A=B"] = "npm" }`, wantErr: "invalid variable name"},
+		{name: "also in env", opts: `env = { NPM_TOKEN = "x" }, secret_env = { NPM_TOKEN = "npm" }`, wantErr: "set by both env and secret_env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := `bk.export(bk.image("alpine"):run("true", { ` + tt.opts + ` }))`
+			_, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package luavm
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 
 	pb "github.com/moby/buildkit/solver/pb"
 	lua "github.com/yuin/gopher-lua"
@@ -12,32 +14,119 @@ import (
 
 const (
 	luaStateTypeName = "luakit.state"
+	luaExecTypeName  = "luakit.exec"
 )
 
+// execResult is what state:run returns. It behaves as its root filesystem
+// wherever a state is expected and adds root() and mount() to pick an output.
+type execResult struct {
+	root *dag.State
+}
+
 func registerStateType(L *lua.LState) {
 	mt := L.NewTypeMetatable(luaStateTypeName)
 	L.SetGlobal(luaStateTypeName, mt)
 
 	L.SetField(mt, "__index", L.NewFunction(stateIndex))
 	L.SetField(mt, "__tostring", L.NewFunction(stateToString))
+
+	execMt := L.NewTypeMetatable(luaExecTypeName)
+	L.SetGlobal(luaExecTypeName, execMt)
+
+	L.SetField(execMt, "__index", L.NewFunction(execIndex))
+	L.SetField(execMt, "__tostring", L.NewFunction(execToString))
 }
 
 func newState(L *lua.LState, state *dag.State) *lua.LUserData {
+	if data := getVMData(L); data != nil {
+		data.countNode(L, state.Op())
+	}
 	ud := L.NewUserData()
 	ud.Value = state
 	L.SetMetatable(ud, L.GetTypeMetatable(luaStateTypeName))
 	return ud
 }
 
+func newExecResult(L *lua.LState, root *dag.State) *lua.LUserData {
+	if data := getVMData(L); data != nil {
+		data.countNode(L, root.Op())
+	}
+	ud := L.NewUserData()
+	ud.Value = &execResult{root: root}
+	L.SetMetatable(ud, L.GetTypeMetatable(luaExecTypeName))
+	return ud
+}
+
 func checkState(L *lua.LState, n int) *dag.State {
 	ud := L.CheckUserData(n)
-	if v, ok := ud.Value.(*dag.State); ok {
+	if v, ok := stateValue(ud.Value); ok {
 		return v
 	}
 	L.ArgError(n, fmt.Sprintf("expected %s, got %s", luaStateTypeName, ud.Type().String()))
 	return nil
 }
 
+// stateValue returns the state held by a state or exec result userdata.
+func stateValue(v any) (*dag.State, bool) {
+	switch v := v.(type) {
+	case *dag.State:
+		return v, true
+	case *execResult:
+		return v.root, true
+	}
+	return nil, false
+}
+
+func checkExecResult(L *lua.LState, n int) *execResult {
+	ud := L.CheckUserData(n)
+	if v, ok := ud.Value.(*execResult); ok {
+		return v
+	}
+	L.ArgError(n, fmt.Sprintf("expected %s, got %s", luaExecTypeName, ud.Type().String()))
+	return nil
+}
+
+func execIndex(L *lua.LState) int {
+	checkExecResult(L, 1)
+
+	switch L.CheckString(2) {
+	case "root":
+		L.Push(L.NewFunction(execRoot))
+		return 1
+	case "mount":
+		L.Push(L.NewFunction(execMount))
+		return 1
+	default:
+		return stateIndex(L)
+	}
+}
+
+func execToString(L *lua.LState) int {
+	result := checkExecResult(L, 1)
+	L.Push(lua.LString(fmt.Sprintf("luakit.exec@%p", result.root)))
+	return 1
+}
+
+func execRoot(L *lua.LState) int {
+	result := checkExecResult(L, 1)
+	L.Push(newState(L, result.root))
+	return 1
+}
+
+func execMount(L *lua.LState) int {
+	result := checkExecResult(L, 1)
+	dest := L.CheckString(2)
+
+	state, err := ops.MountOutput(result.root, dest)
+	if err != nil {
+		L.RaiseError("mount: %v", err)
+		return 0
+	}
+
+	L.Push(newState(L, state))
+	return 1
+}
+
 func stateIndex(L *lua.LState) int {
 	checkState(L, 1)
 	key := L.CheckString(2)
@@ -64,6 +153,24 @@ func stateIndex(L *lua.LState) int {
 	case "with_metadata":
 		L.Push(L.NewClosure(stateWithMetadata, L.NewFunction(stateWithMetadata)))
 		return 1
+	case "env":
+		L.Push(L.NewClosure(stateEnv, L.NewFunction(stateEnv)))
+		return 1
+	case "workdir":
+		L.Push(L.NewClosure(stateWorkdir, L.NewFunction(stateWorkdir)))
+		return 1
+	case "user":
+		L.Push(L.NewClosure(stateUser, L.NewFunction(stateUser)))
+		return 1
+	case "entrypoint":
+		L.Push(L.NewClosure(stateEntrypoint, L.NewFunction(stateEntrypoint)))
+		return 1
+	case "cmd":
+		L.Push(L.NewClosure(stateCmd, L.NewFunction(stateCmd)))
+		return 1
+	case "label":
+		L.Push(L.NewClosure(stateLabel, L.NewFunction(stateLabel)))
+		return 1
 	default:
 		L.RaiseError("unknown field: %s", key)
 		return 0
@@ -116,7 +223,7 @@ func stateRun(L *lua.LState) int {
 		return 0
 	}
 
-	L.Push(newState(L, result))
+	L.Push(newExecResult(L, result))
 	return 1
 }
 
@@ -203,9 +310,150 @@ func parseExecOptions(L *lua.LState, opts *lua.LTable) *ops.ExecOptions {
 		return nil
 	}
 
+	extraHostsVal := L.GetField(opts, "extra_hosts")
+	if extraHostsVal.Type() == lua.LTTable {
+		extraHostsVal.(*lua.LTable).ForEach(func(key, value lua.LValue) {
+			h := ops.HostIP{Host: key.String(), IP: value.String()}
+			if key.Type() != lua.LTString || value.Type() != lua.LTString {
+				L.RaiseError("run options extra_hosts must map host names to IP addresses")
+			}
+			if err := ops.ValidateExtraHost(h); err != nil {
+				L.RaiseError("run options extra_hosts: %v", err)
+			}
+			execOpts.ExtraHosts = append(execOpts.ExtraHosts, h)
+		})
+		slices.SortFunc(execOpts.ExtraHosts, func(a, b ops.HostIP) int {
+			return strings.Compare(a.Host, b.Host)
+		})
+	} else if extraHostsVal.Type() != lua.LTNil {
+		L.RaiseError("run options extra_hosts must be a table")
+		return nil
+	}
+
+	ulimitsVal := L.GetField(opts, "ulimits")
+	if ulimitsVal.Type() == lua.LTTable {
+		ulimitsVal.(*lua.LTable).ForEach(func(key, value lua.LValue) {
+			execOpts.Ulimits = append(execOpts.Ulimits, parseUlimit(L, key, value))
+		})
+		slices.SortFunc(execOpts.Ulimits, func(a, b ops.Ulimit) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	} else if ulimitsVal.Type() != lua.LTNil {
+		L.RaiseError("run options ulimits must be a table")
+		return nil
+	}
+
+	cgroupParentVal := L.GetField(opts, "cgroup_parent")
+	if cgroupParentVal.Type() == lua.LTString {
+		execOpts.CgroupParent = cgroupParentVal.String()
+	} else if cgroupParentVal.Type() != lua.LTNil {
+		L.RaiseError("run options cgroup_parent must be a string")
+		return nil
+	}
+
+	removeMountStubsVal := L.GetField(opts, "remove_mount_stubs")
+	if removeMountStubsVal.Type() == lua.LTBool {
+		execOpts.RemoveMountStubs = bool(removeMountStubsVal.(lua.LBool))
+	} else if removeMountStubsVal.Type() != lua.LTNil {
+		L.RaiseError("run options remove_mount_stubs must be a boolean")
+		return nil
+	}
+
+	readonlyRootfsVal := L.GetField(opts, "readonly_rootfs")
+	if readonlyRootfsVal.Type() == lua.LTBool {
+		execOpts.ReadonlyRootfs = bool(readonlyRootfsVal.(lua.LBool))
+	} else if readonlyRootfsVal.Type() != lua.LTNil {
+		L.RaiseError("run options readonly_rootfs must be a boolean")
+		return nil
+	}
+
+	secretEnvVal := L.GetField(opts, "secret_env")
+	if secretEnvVal.Type() == lua.LTTable {
+		execOpts.SecretEnv = parseSecretEnvTable(L, secretEnvVal.(*lua.LTable))
+		for _, se := range execOpts.SecretEnv {
+			for _, kv := range execOpts.Env {
+				if k, _, _ := strings.Cut(kv, "="); k == se.Name {
+					L.RaiseError("run options: %s is set by both env and secret_env", se.Name)
+					return nil
+				}
+			}
+		}
+	} else if secretEnvVal.Type() != lua.LTNil {
+		L.RaiseError("run options secret_env must be a table")
+		return nil
+	}
+
 	return execOpts
 }
 
+// parseUlimit reads one entry of ulimits: a number sets both limits, a
+// table sets soft and hard separately. The hard limit defaults to the soft
+// one, and -1 means unlimited.
+func parseUlimit(L *lua.LState, key, value lua.LValue) ops.Ulimit {
+	u := ops.Ulimit{Name: key.String()}
+	switch v := value.(type) {
+	case lua.LNumber:
+		u.Soft, u.Hard = int64(v), int64(v)
+	case *lua.LTable:
+		soft, ok := L.GetField(v, "soft").(lua.LNumber)
+		if !ok {
+			L.RaiseError("run options ulimits: %s needs a numeric soft limit", u.Name)
+		}
+		u.Soft, u.Hard = int64(soft), int64(soft)
+		switch hard := L.GetField(v, "hard").(type) {
+		case lua.LNumber:
+			u.Hard = int64(hard)
+		case *lua.LNilType:
+		default:
+			L.RaiseError("run options ulimits: %s hard limit must be a number", u.Name)
+		}
+	default:
+		L.RaiseError("run options ulimits: %s must be a number or { soft = ..., hard = ... }", u.Name)
+	}
+	if err := ops.ValidateUlimit(u); err != nil {
+		L.RaiseError("run options ulimits: %v", err)
+	}
+	return u
+}
+
+// parseSecretEnvTable reads secret_env, which maps environment variable
+// names to a secret ID or to { id = ..., optional = ... }. The result is
+// sorted by name so the op does not depend on table order.
+func parseSecretEnvTable(L *lua.LState, table *lua.LTable) []ops.SecretEnv {
+	var secrets []ops.SecretEnv
+	table.ForEach(func(key, value lua.LValue) {
+		name, ok := key.(lua.LString)
+		if !ok || !isEnvName(string(name)) {
+			L.RaiseError("run options secret_env: invalid variable name %q", key.String())
+		}
+		se := ops.SecretEnv{Name: string(name)}
+		switch v := value.(type) {
+		case lua.LString:
+			se.ID = string(v)
+		case *lua.LTable:
+			if id := L.GetField(v, "id"); id.Type() == lua.LTString {
+				se.ID = id.String()
+			}
+			se.Optional = lua.LVAsBool(L.GetField(v, "optional"))
+		default:
+			L.RaiseError("run options secret_env: %s must be a secret id or a table", name)
+		}
+		if se.ID == "" {
+			L.RaiseError("run options secret_env: %s: secret id must not be empty", name)
+		}
+		secrets = append(secrets, se)
+	})
+	slices.SortFunc(secrets, func(a, b ops.SecretEnv) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return secrets
+}
+
+// isEnvName reports whether name can be used as an environment variable.
+func isEnvName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "= \t\n\x00")
+}
+
 func parseMountsTable(L *lua.LState, table *lua.LTable) []*ops.Mount {
 	var mounts []*ops.Mount
 	for i := int64(1); ; i++ {
@@ -231,14 +479,32 @@ func checkMountOrNil(L *lua.LState, val lua.LValue) *ops.Mount {
 	return nil
 }
 
+// parseEnvTable reads { KEY = "value" } as KEY=value entries, sorted by
+// key so the op and image config do not depend on table order.
 func parseEnvTable(L *lua.LState, table *lua.LTable) []string {
 	var env []string
+	for _, e := range sortedEntries(table) {
+		env = append(env, e.key+"="+e.value.String())
+	}
+	return env
+}
+
+// tableEntry is a table entry with its key as a string.
+type tableEntry struct {
+	key   string
+	value lua.LValue
+}
+
+// sortedEntries returns the entries of table sorted by key.
+func sortedEntries(table *lua.LTable) []tableEntry {
+	var entries []tableEntry
 	table.ForEach(func(key, value lua.LValue) {
-		keyStr := key.String()
-		valueStr := value.String()
-		env = append(env, keyStr+"="+valueStr)
+		entries = append(entries, tableEntry{key: key.String(), value: value})
 	})
-	return env
+	slices.SortFunc(entries, func(a, b tableEntry) int {
+		return strings.Compare(a.key, b.key)
+	})
+	return entries
 }
 
 func parseValidExitCodes(L *lua.LState, table *lua.LTable) []int32 {
@@ -486,6 +752,101 @@ func stateWithMetadata(L *lua.LState) int {
 	return 1
 }
 
+// stateEnv sets environment variables for later runs and the exported
+// image, either as env(key, value) or env({ KEY = "value" }).
+func stateEnv(L *lua.LState) int {
+	state := checkState(L, 1)
+
+	switch arg := L.Get(2); arg.Type() {
+	case lua.LTString:
+		state = ops.WithEnv(state, arg.String(), L.CheckString(3))
+	case lua.LTTable:
+		for _, kv := range parseEnvTable(L, arg.(*lua.LTable)) {
+			key, value, _ := strings.Cut(kv, "=")
+			state = ops.WithEnv(state, key, value)
+		}
+	default:
+		L.ArgError(2, "env: key string or table expected")
+		return 0
+	}
+
+	return pushConfigState(L, state)
+}
+
+func stateWorkdir(L *lua.LState) int {
+	state := checkState(L, 1)
+	dir := L.CheckString(2)
+	if dir == "" {
+		L.ArgError(2, "workdir: path must not be empty")
+		return 0
+	}
+
+	return pushConfigState(L, ops.WithWorkdir(state, dir))
+}
+
+func stateUser(L *lua.LState) int {
+	state := checkState(L, 1)
+	user := L.CheckString(2)
+	if user == "" {
+		L.ArgError(2, "user: user must not be empty")
+		return 0
+	}
+
+	return pushConfigState(L, ops.WithUser(state, user))
+}
+
+func stateEntrypoint(L *lua.LState) int {
+	state := checkState(L, 1)
+	return pushConfigState(L, ops.WithEntrypoint(state, checkCommand(L, 2, "entrypoint")))
+}
+
+func stateCmd(L *lua.LState) int {
+	state := checkState(L, 1)
+	return pushConfigState(L, ops.WithCmd(state, checkCommand(L, 2, "cmd")))
+}
+
+// pushConfigState pushes state, a config change of the state it was called
+// on, recording the calling line for the image history.
+func pushConfigState(L *lua.LState, state *dag.State) int {
+	file, line := getCallSite(L)
+	L.Push(newState(L, ops.WithConfigStep(state, file, line)))
+	return 1
+}
+
+// checkCommand reads a command given as a table of arguments or as a
+// string run by /bin/sh -c, like state:run.
+func checkCommand(L *lua.LState, n int, name string) []string {
+	switch arg := L.Get(n); arg.Type() {
+	case lua.LTString:
+		return []string{"/bin/sh", "-c", arg.String()}
+	case lua.LTTable:
+		return luaTableToStringSlice(L, arg.(*lua.LTable))
+	default:
+		L.ArgError(n, name+": command must be string or table")
+		return nil
+	}
+}
+
+// stateLabel sets image labels, either as label(key, value) or
+// label({ key = "value" }).
+func stateLabel(L *lua.LState) int {
+	state := checkState(L, 1)
+
+	switch arg := L.Get(2); arg.Type() {
+	case lua.LTString:
+		state = ops.WithLabel(state, arg.String(), L.CheckString(3))
+	case lua.LTTable:
+		for _, e := range sortedEntries(arg.(*lua.LTable)) {
+			state = ops.WithLabel(state, e.key, e.value.String())
+		}
+	default:
+		L.ArgError(2, "label: key string or table expected")
+		return 0
+	}
+
+	return pushConfigState(L, state)
+}
+
 func parseMetadataOptions(L *lua.LState, opts *lua.LTable) *pb.OpMetadata {
 	meta := &pb.OpMetadata{}
 
@@ -496,6 +857,13 @@ func parseMetadataOptions(L *lua.LState, opts *lua.LTable) *pb.OpMetadata {
 		meta.Description["llb.custom"] = descriptionVal.String()
 	}
 
+	if commentVal := L.GetField(opts, "comment"); commentVal.Type() == lua.LTString {
+		if meta.Description == nil {
+			meta.Description = make(map[string]string, 1)
+		}
+		meta.Description[dag.HistoryCommentKey] = commentVal.String()
+	}
+
 	if progressGroupVal := L.GetField(opts, "progress_group"); progressGroupVal.Type() == lua.LTString {
 		meta.ProgressGroup = &pb.ProgressGroup{
 			Id: progressGroupVal.String(),
@@ -0,0 +1,143 @@
+package luavm
+
+import (
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// strictRandomSeed seeds math.random in strict mode.
+const strictRandomSeed = 0
+
+// sandboxEnv replaces os.getenv so the variables a script reads are
+// recorded. In strict mode it only sees config.Env; otherwise it reads the
+// process environment.
+func sandboxEnv(L *lua.LState, data *vmData, config *VMConfig) {
+	osTable, ok := L.GetGlobal("os").(*lua.LTable)
+	if !ok {
+		return
+	}
+
+	L.SetField(osTable, "getenv", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		if !slices.Contains(data.envReads, name) {
+			data.envReads = append(data.envReads, name)
+		}
+
+		var value string
+		if config.Strict {
+			value = config.Env[name]
+		} else {
+			value = os.Getenv(name)
+		}
+		if value == "" {
+			L.Push(lua.LNil)
+		} else {
+			L.Push(lua.LString(value))
+		}
+		return 1
+	}))
+}
+
+// sandboxStrict makes the clock and random numbers deterministic. The time
+// is the source date epoch, or the Unix epoch without one, and dates are in
+// UTC. math.random draws from a generator of the VM seeded with a fixed
+// value.
+func sandboxStrict(L *lua.LState, data *vmData) {
+	if osTable, ok := L.GetGlobal("os").(*lua.LTable); ok {
+		osDate := L.GetField(osTable, "date")
+
+		L.SetField(osTable, "time", L.NewFunction(func(L *lua.LState) int {
+			tbl, ok := L.Get(1).(*lua.LTable)
+			if !ok {
+				if L.Get(1) != lua.LNil {
+					L.TypeError(1, lua.LTTable)
+				}
+				L.Push(lua.LNumber(data.now().Unix()))
+				return 1
+			}
+			field := func(key string, def int) int {
+				if v, ok := L.GetField(tbl, key).(lua.LNumber); ok {
+					return int(v)
+				}
+				if def < 0 {
+					L.RaiseError("field '%s' missing in date table", key)
+				}
+				return def
+			}
+			t := time.Date(field("year", -1), time.Month(field("month", -1)), field("day", -1),
+				field("hour", 12), field("min", 0), field("sec", 0), 0, time.UTC)
+			L.Push(lua.LNumber(t.Unix()))
+			return 1
+		}))
+
+		L.SetField(osTable, "clock", L.NewFunction(func(L *lua.LState) int {
+			L.Push(lua.LNumber(0))
+			return 1
+		}))
+
+		L.SetField(osTable, "date", L.NewFunction(func(L *lua.LState) int {
+			format := "%c"
+			if L.GetTop() >= 1 {
+				format = L.CheckString(1)
+			}
+			t := data.now().Unix()
+			if L.GetTop() >= 2 {
+				t = L.CheckInt64(2)
+			}
+			if !strings.HasPrefix(format, "!") {
+				format = "!" + format
+			}
+			L.Push(osDate)
+			L.Push(lua.LString(format))
+			L.Push(lua.LNumber(t))
+			L.Call(2, 1)
+			return 1
+		}))
+	}
+
+	if mathTable, ok := L.GetGlobal("math").(*lua.LTable); ok {
+		rng := rand.New(rand.NewSource(strictRandomSeed)) // #nosec G404 -- deterministic on purpose
+
+		L.SetField(mathTable, "random", L.NewFunction(func(L *lua.LState) int {
+			switch L.GetTop() {
+			case 0:
+				L.Push(lua.LNumber(rng.Float64()))
+			case 1:
+				n := L.CheckInt(1)
+				if n < 1 {
+					L.ArgError(1, "interval is empty")
+				}
+				L.Push(lua.LNumber(rng.Intn(n) + 1))
+			default:
+				lo, hi := L.CheckInt(1), L.CheckInt(2)
+				if hi < lo {
+					L.ArgError(2, "interval is empty")
+				}
+				L.Push(lua.LNumber(rng.Intn(hi-lo+1) + lo))
+			}
+			return 1
+		}))
+
+		L.SetField(mathTable, "randomseed", L.NewFunction(func(L *lua.LState) int {
+			rng.Seed(L.CheckInt64(1))
+			return 0
+		}))
+	}
+}
+
+// now is the time strict mode reports: the source date epoch given in
+// VMConfig or with bk.set_epoch, else the Unix epoch.
+func (d *vmData) now() time.Time {
+	switch {
+	case d.epochOverride != nil:
+		return *d.epochOverride
+	case d.sourceDateEpoch != nil:
+		return *d.sourceDateEpoch
+	}
+	return time.Unix(0, 0)
+}
@@ -0,0 +1,103 @@
+package luavm
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// evalEnv evaluates script and returns the env of the image it exports.
+func evalEnv(t *testing.T, script string, config *VMConfig) (map[string]string, *EvalResult) {
+	t.Helper()
+	result, err := Evaluate(strings.NewReader(script), "build.lua", config)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	env := make(map[string]string)
+	for _, kv := range result.ImageConfig.Config.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	return env, result
+}
+
+const strictScript = `
+math.randomseed(42)
+local r1 = math.random(1000)
+bk.export(bk.scratch():env({
+    TIME = tostring(os.time()),
+    DATE = os.date("%Y-%m-%d %H:%M"),
+    TABLE_TIME = tostring(os.time({ year = 2024, month = 1, day = 1, hour = 0 })),
+    CLOCK = tostring(os.clock()),
+    R1 = tostring(r1),
+    R2 = tostring(math.random(5, 10)),
+    HOME = os.getenv("HOME") or "unset",
+    CI = os.getenv("CI") or "unset",
+}))
+`
+
+func TestStrictSandboxIsDeterministic(t *testing.T) {
+	t.Setenv("HOME", "/home/builder")
+	t.Setenv("CI", "true")
+
+	config := &VMConfig{Strict: true, Env: map[string]string{"CI": "1"}}
+	first, result := evalEnv(t, strictScript, config)
+	second, _ := evalEnv(t, strictScript, config)
+
+	if !maps.Equal(first, second) {
+		t.Errorf("expected identical results, got %v and %v", first, second)
+	}
+	want := map[string]string{
+		"TIME":       "0",
+		"DATE":       "1970-01-01 00:00",
+		"TABLE_TIME": "1704067200",
+		"CLOCK":      "0",
+		"HOME":       "unset",
+		"CI":         "1",
+	}
+	for k, v := range want {
+		if first[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, first[k])
+		}
+	}
+	if !slices.Equal(result.EnvReads, []string{"CI", "HOME"}) {
+		t.Errorf("expected CI and HOME to be reported, got %v", result.EnvReads)
+	}
+}
+
+func TestStrictSandboxUsesSourceDateEpoch(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	env, _ := evalEnv(t, strictScript, &VMConfig{Strict: true, SourceDateEpoch: &epoch})
+	if env["TIME"] != "1700000000" || env["DATE"] != "2023-11-14 22:13" {
+		t.Errorf("expected the source date epoch, got %s and %s", env["TIME"], env["DATE"])
+	}
+
+	env, _ = evalEnv(t, "bk.set_epoch(1600000000)\n"+strictScript, &VMConfig{Strict: true})
+	if env["TIME"] != "1600000000" {
+		t.Errorf("expected the epoch from bk.set_epoch, got %s", env["TIME"])
+	}
+}
+
+func TestDefaultSandboxReadsEnvironment(t *testing.T) {
+	t.Setenv("HOME", "/home/builder")
+	env, result := evalEnv(t, strictScript, &VMConfig{})
+	if env["HOME"] != "/home/builder" {
+		t.Errorf("expected the process environment, got %q", env["HOME"])
+	}
+	if env["TIME"] == "0" {
+		t.Error("expected the real clock outside strict mode")
+	}
+	if !slices.Equal(result.EnvReads, []string{"CI", "HOME"}) {
+		t.Errorf("expected CI and HOME to be reported, got %v", result.EnvReads)
+	}
+}
+
+func TestStrictRandomErrors(t *testing.T) {
+	for _, script := range []string{`math.random(0)`, `math.random(5, 1)`, `os.time("now")`, `os.time({ year = 2024 })`} {
+		if _, err := Evaluate(strings.NewReader(script), "build.lua", &VMConfig{Strict: true}); err == nil {
+			t.Errorf("%s: expected an error", script)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package luavm
+
+import (
+	"fmt"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// buildTarget is a named build entry point registered with bk.target.
+type buildTarget struct {
+	name string
+	fn   *lua.LFunction
+}
+
+func bkTarget(L *lua.LState) int {
+	nameArg := L.Get(1)
+	if nameArg.Type() != lua.LTString {
+		L.ArgError(1, "string expected")
+		return 0
+	}
+	name := nameArg.String()
+
+	if name == "" || isWhitespaceOnly(name) {
+		L.RaiseError("bk.target: name must not be empty")
+		return 0
+	}
+
+	fnArg := L.Get(2)
+	if fnArg.Type() != lua.LTFunction {
+		L.ArgError(2, "function expected")
+		return 0
+	}
+
+	data := getVMData(L)
+	for _, t := range data.targets {
+		if t.name == name {
+			L.RaiseError("bk.target: target %q already defined", name)
+			return 0
+		}
+	}
+
+	data.targets = append(data.targets, &buildTarget{
+		name: name,
+		fn:   fnArg.(*lua.LFunction),
+	})
+
+	return 0
+}
+
+// targetNames returns the registered target names in definition order.
+func (d *vmData) targetNames() []string {
+	names := make([]string, 0, len(d.targets))
+	for _, t := range d.targets {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// selectTarget picks the target to build after the script body has run and
+// evaluates only that target's function.
+//
+// An explicit target name must match a registered target. Without one, a
+// top-level bk.export wins; otherwise the last registered target is used,
+// mirroring the Dockerfile frontend's "last stage" default.
+func selectTarget(L *lua.LState, data *vmData, name string) (string, error) {
+	if name == "" {
+		if data.exportedState != nil || len(data.targets) == 0 {
+			return "", nil
+		}
+		name = data.targets[len(data.targets)-1].name
+	}
+
+	var target *buildTarget
+	for _, t := range data.targets {
+		if t.name == name {
+			target = t
+			break
+		}
+	}
+	if target == nil {
+		if len(data.targets) == 0 {
+			return "", fmt.Errorf("target %q not found: script defines no targets", name)
+		}
+		return "", fmt.Errorf("target %q not found (available: %s)", name, strings.Join(data.targetNames(), ", "))
+	}
+
+	data.exportedState = nil
+	data.exportedImageConfig = nil
+	data.exportedPlatforms = nil
+
+	L.Push(target.fn)
+	if err := data.pcall(L, 0, 1); err != nil {
+		return "", fmt.Errorf("failed to run target %q: %w", name, err)
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	if data.exportedState == nil {
+		if ud, ok := ret.(*lua.LUserData); ok {
+			if state, ok := stateValue(ud.Value); ok {
+				data.exportedState = state
+			}
+		}
+	}
+
+	return name, nil
+}
@@ -0,0 +1,200 @@
+package luavm
+
+import (
+	"strings"
+	"testing"
+)
+
+const multiTargetScript = `
+local base = bk.image("alpine:3.19")
+
+bk.target("dev", function()
+	bk.export(base:run("echo dev"))
+end)
+
+bk.target("test", function()
+	return base:run("echo test")
+end)
+
+bk.target("prod", function()
+	bk.export(base:run("echo prod"), { user = "nobody" })
+end)
+`
+
+func execArgs(t *testing.T, result *EvalResult) []string {
+	t.Helper()
+	if result.State == nil {
+		t.Fatal("expected exported state")
+	}
+	exec := result.State.Op().Op().GetExec()
+	if exec == nil {
+		t.Fatal("expected exported state to be an exec op")
+	}
+	return exec.Meta.Args
+}
+
+func TestTargetSelection(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantCmd string
+	}{
+		{target: "dev", wantCmd: "echo dev"},
+		{target: "test", wantCmd: "echo test"},
+		{target: "prod", wantCmd: "echo prod"},
+		{target: "", wantCmd: "echo prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run("target="+tt.target, func(t *testing.T) {
+			result, err := Evaluate(strings.NewReader(multiTargetScript), "build.lua", &VMConfig{Target: tt.target})
+			if err != nil {
+				t.Fatalf("Evaluate failed: %v", err)
+			}
+
+			args := execArgs(t, result)
+			if args[len(args)-1] != tt.wantCmd {
+				t.Errorf("expected command %q, got %q", tt.wantCmd, args[len(args)-1])
+			}
+			if result.Target == "" {
+				t.Error("expected selected target name to be reported")
+			}
+			if strings.Join(result.Targets, ",") != "dev,test,prod" {
+				t.Errorf("expected targets in definition order, got %v", result.Targets)
+			}
+		})
+	}
+}
+
+func TestTargetOnlyEvaluatesSelected(t *testing.T) {
+	script := `
+bk.target("broken", function()
+	error("broken target should not run")
+end)
+
+bk.target("ok", function()
+	bk.export(bk.image("alpine:3.19"))
+end)
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", &VMConfig{Target: "ok"})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.State == nil {
+		t.Fatal("expected exported state")
+	}
+
+	_, err = Evaluate(strings.NewReader(script), "build.lua", &VMConfig{Target: "broken"})
+	if err == nil {
+		t.Fatal("expected error from broken target")
+	}
+	if !strings.Contains(err.Error(), `failed to run target "broken"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTargetTopLevelExportIsDefault(t *testing.T) {
+	script := `
+local base = bk.image("alpine:3.19")
+bk.target("other", function()
+	bk.export(base:run("echo other"))
+end)
+bk.export(base:run("echo default"))
+`
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	args := execArgs(t, result)
+	if args[len(args)-1] != "echo default" {
+		t.Errorf("expected top-level export, got %q", args[len(args)-1])
+	}
+	if result.Target != "" {
+		t.Errorf("expected no target name for top-level export, got %q", result.Target)
+	}
+
+	result, err = Evaluate(strings.NewReader(script), "build.lua", &VMConfig{Target: "other"})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	args = execArgs(t, result)
+	if args[len(args)-1] != "echo other" {
+		t.Errorf("expected selected target to replace top-level export, got %q", args[len(args)-1])
+	}
+}
+
+func TestTargetErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		target  string
+		wantErr string
+	}{
+		{
+			name:    "unknown target",
+			script:  multiTargetScript,
+			target:  "staging",
+			wantErr: `target "staging" not found (available: dev, test, prod)`,
+		},
+		{
+			name:    "no targets defined",
+			script:  `bk.export(bk.image("alpine:3.19"))`,
+			target:  "prod",
+			wantErr: "script defines no targets",
+		},
+		{
+			name: "duplicate target",
+			script: `
+bk.target("a", function() end)
+bk.target("a", function() end)
+`,
+			wantErr: `target "a" already defined`,
+		},
+		{
+			name:    "empty name",
+			script:  `bk.target("", function() end)`,
+			wantErr: "bk.target: name must not be empty",
+		},
+		{
+			name:    "missing function",
+			script:  `bk.target("a")`,
+			wantErr: "function expected",
+		},
+		{
+			name: "export twice in target",
+			script: `
+bk.target("a", function()
+	bk.export(bk.scratch())
+	bk.export(bk.scratch())
+end)
+`,
+			wantErr: "already called once",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Evaluate(strings.NewReader(tt.script), "build.lua", &VMConfig{Target: tt.target})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTargetWithoutExport(t *testing.T) {
+	script := `bk.target("empty", function() end)`
+
+	result, err := Evaluate(strings.NewReader(script), "build.lua", nil)
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if result.State != nil {
+		t.Error("expected no exported state")
+	}
+	if result.Target != "empty" {
+		t.Errorf("expected target 'empty', got %q", result.Target)
+	}
+}
@@ -1,38 +1,93 @@
 package luavm
 
 import (
+	"io/fs"
 	"maps"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
+	pb "github.com/moby/buildkit/solver/pb"
 	lua "github.com/yuin/gopher-lua"
-)
 
-var (
-	sourceFiles map[string][]byte
-	sourceMu    sync.RWMutex
+	"github.com/kasuboski/luakit/pkg/dag"
 )
 
-func init() {
-	sourceFiles = make(map[string][]byte)
-}
-
 type VMConfig struct {
 	BuildContextDir string
 	StdlibDir       string
+	// ContextFS, if set, is searched by require and read by bk.read_file,
+	// bk.exists and bk.glob instead of BuildContextDir. The gateway uses it
+	// to read the build context.
+	ContextFS fs.FS
+	// StdlibFS, if set, is searched by require instead of StdlibDir.
+	StdlibFS fs.FS
+	// Target selects which bk.target to build. Empty means the top-level
+	// bk.export, or the last defined target if there is none.
+	Target string
+	// Platforms are the target platforms passed to a function given to
+	// bk.export, or that a single exported state is built for. Empty means
+	// the default build platform.
+	Platforms []*pb.Platform
+	// Args are the build argument values read by bk.arg.
+	Args map[string]string
+	// SourceDateEpoch, if set, overrides the epoch set with bk.set_epoch.
+	SourceDateEpoch *time.Time
+	// Contexts are the named contexts returned by bk.context, each given
+	// as a source: "docker-image://REF", "oci-layout://STORE@DIGEST",
+	// "local:NAME", "input:NAME", a git URL or an HTTP URL.
+	Contexts map[string]string
+	// ContextStates are named contexts that are already states, such as
+	// the inputs of a gateway build. They win over Contexts.
+	ContextStates map[string]*dag.State
+
+	// Timeout, if positive, bounds how long the script may run.
+	Timeout time.Duration
+	// CallStackSize and RegistrySize cap the depth of Lua calls and the
+	// number of values on the Lua stack. Zero keeps the gopher-lua defaults.
+	CallStackSize int
+	RegistrySize  int
+	// MaxNodes, if positive, caps the number of DAG nodes the script
+	// creates.
+	MaxNodes int
+
+	// Strict makes the script deterministic: os.time, os.date and os.clock
+	// see a fixed time, math.random a fixed seed, and os.getenv only the
+	// variables in Env.
+	Strict bool
+	// Env is what os.getenv sees in strict mode.
+	Env map[string]string
 }
 
 func NewVM(config *VMConfig) *lua.LState {
-	L := lua.NewState()
-
 	if config == nil {
 		config = &VMConfig{}
 	}
 
+	L := lua.NewState(lua.Options{
+		CallStackSize: config.CallStackSize,
+		RegistrySize:  config.RegistrySize,
+	})
+
 	data := &vmData{}
 	data.L = L
+	data.sourceFiles = make(map[string][]byte)
+	data.platforms = config.Platforms
+	data.args = config.Args
+	data.contexts = config.Contexts
+	data.contextStates = config.ContextStates
+	data.timeout = config.Timeout
+	data.callStackSize = config.CallStackSize
+	data.registrySize = config.RegistrySize
+	data.maxNodes = config.MaxNodes
+	data.epochOverride = config.SourceDateEpoch
+	data.contextFS = contextFS(config)
+	if config.MaxNodes > 0 {
+		data.nodes = make(map[*dag.OpNode]struct{})
+	}
 	L.SetGlobal("__luakit_vm_data", L.NewUserData())
 	L.GetGlobal("__luakit_vm_data").(*lua.LUserData).Value = data
 
@@ -41,46 +96,72 @@ func NewVM(config *VMConfig) *lua.LState {
 	registerPlatformType(L)
 	registerAPI(L)
 	sandbox(L)
-
-	if config.BuildContextDir != "" || config.StdlibDir != "" {
-		setupModuleLoader(L, config)
+	sandboxEnv(L, data, config)
+	if config.Strict {
+		sandboxStrict(L, data)
 	}
 
+	setupModuleLoader(L, config, moduleRoots(config))
+
 	return L
 }
 
-func setupModuleLoader(L *lua.LState, config *VMConfig) {
+// moduleRoot is a file system require searches. dir is prepended to module
+// paths to name the file in errors and source maps.
+type moduleRoot struct {
+	fsys fs.FS
+	dir  string
+}
+
+// moduleRoots returns the roots require searches, build context first.
+func moduleRoots(config *VMConfig) []moduleRoot {
+	var roots []moduleRoot
+	if config.ContextFS != nil {
+		roots = append(roots, moduleRoot{fsys: config.ContextFS})
+	} else if config.BuildContextDir != "" {
+		roots = append(roots, moduleRoot{fsys: os.DirFS(config.BuildContextDir), dir: config.BuildContextDir})
+	}
+	if config.StdlibFS != nil {
+		roots = append(roots, moduleRoot{fsys: config.StdlibFS, dir: "stdlib"})
+	} else if config.StdlibDir != "" {
+		roots = append(roots, moduleRoot{fsys: os.DirFS(config.StdlibDir), dir: config.StdlibDir})
+	}
+	return roots
+}
+
+// modulePaths returns the files that may hold a module, relative to a
+// root. Both require("lib.go") and require("lib/go") find lib/go.lua.
+func modulePaths(moduleName string) []string {
+	if strings.HasSuffix(moduleName, ".lua") {
+		return []string{moduleName}
+	}
+	paths := []string{moduleName + ".lua", path.Join(moduleName, "init.lua")}
+	if slashed := strings.ReplaceAll(moduleName, ".", "/"); slashed != moduleName {
+		paths = append(paths, slashed+".lua", path.Join(slashed, "init.lua"))
+	}
+	return paths
+}
+
+func setupModuleLoader(L *lua.LState, config *VMConfig, roots []moduleRoot) {
 	loader := L.NewFunction(func(L *lua.LState) int {
 		moduleName := L.CheckString(1)
 
-		searchPaths := []string{}
-
-		if strings.HasSuffix(moduleName, ".lua") {
-			if config.BuildContextDir != "" {
-				searchPaths = append(searchPaths, filepath.Join(config.BuildContextDir, moduleName))
-			}
-			if config.StdlibDir != "" {
-				searchPaths = append(searchPaths, filepath.Join(config.StdlibDir, moduleName))
-			}
-		} else {
-			if config.BuildContextDir != "" {
-				searchPaths = append(searchPaths, filepath.Join(config.BuildContextDir, moduleName+".lua"))
-				searchPaths = append(searchPaths, filepath.Join(config.BuildContextDir, moduleName, "init.lua"))
-			}
-			if config.StdlibDir != "" {
-				searchPaths = append(searchPaths, filepath.Join(config.StdlibDir, moduleName+".lua"))
-				searchPaths = append(searchPaths, filepath.Join(config.StdlibDir, moduleName, "init.lua"))
-			}
+		if fn := loadBuiltinModule(L, moduleName); fn != nil {
+			L.Push(fn)
+			return 1
 		}
 
 		var moduleData []byte
 		var moduleFile string
-		for _, path := range searchPaths {
-			data, err := os.ReadFile(path) // #nosec G304 -- Path is from trusted build context or stdlib directory
-			if err == nil {
-				moduleData = data
-				moduleFile = path
-				break
+	search:
+		for _, root := range roots {
+			for _, p := range modulePaths(moduleName) {
+				data, err := fs.ReadFile(root.fsys, p)
+				if err == nil {
+					moduleData = data
+					moduleFile = filepath.Join(root.dir, filepath.FromSlash(p))
+					break search
+				}
 			}
 		}
 
@@ -88,7 +169,7 @@ func setupModuleLoader(L *lua.LState, config *VMConfig) {
 			return 0
 		}
 
-		RegisterSourceFile(moduleFile, moduleData)
+		getVMData(L).sourceFiles[moduleFile] = moduleData
 
 		fn, err := L.Load(strings.NewReader(string(moduleData)), moduleFile)
 		if err != nil {
@@ -96,16 +177,8 @@ func setupModuleLoader(L *lua.LState, config *VMConfig) {
 			return 0
 		}
 
+		// require runs the chunk and caches its result in package.loaded.
 		L.Push(fn)
-
-		if err := L.PCall(0, 1, nil); err != nil {
-			L.RaiseError("error running module '%s': %v", moduleName, err)
-			return 0
-		}
-
-		moduleCache := L.GetField(L.GetGlobal("package"), "loaded")
-		L.SetField(moduleCache, moduleName, L.Get(-1))
-
 		return 1
 	})
 
@@ -166,22 +239,53 @@ func sandbox(L *lua.LState) {
 	L.SetGlobal("debug", lua.LNil)
 }
 
+// lastSourceFiles backs the deprecated source registry: it holds the sources
+// of the most recently finished evaluation.
+var (
+	lastSourceFiles map[string][]byte
+	lastSourceMu    sync.RWMutex
+)
+
+// setLastSourceFiles records the sources of a finished evaluation for
+// GetAllSourceFiles.
+func setLastSourceFiles(files map[string][]byte) {
+	lastSourceMu.Lock()
+	defer lastSourceMu.Unlock()
+	lastSourceFiles = maps.Clone(files)
+}
+
+// RegisterSourceFile adds a file to the sources returned by
+// GetAllSourceFiles.
+//
+// Deprecated: Evaluate records the script and its modules per evaluation in
+// EvalResult.SourceFiles.
 func RegisterSourceFile(filename string, data []byte) {
-	sourceMu.Lock()
-	defer sourceMu.Unlock()
-	sourceFiles[filename] = data
+	lastSourceMu.Lock()
+	defer lastSourceMu.Unlock()
+	if lastSourceFiles == nil {
+		lastSourceFiles = make(map[string][]byte)
+	}
+	lastSourceFiles[filename] = data
 }
 
+// GetAllSourceFiles returns the sources of the most recently finished
+// evaluation.
+//
+// Deprecated: Concurrent evaluations replace each other's sources here. Use
+// EvalResult.SourceFiles.
 func GetAllSourceFiles() map[string][]byte {
-	sourceMu.RLock()
-	defer sourceMu.RUnlock()
-	result := make(map[string][]byte, len(sourceFiles))
-	maps.Copy(result, sourceFiles)
+	lastSourceMu.RLock()
+	defer lastSourceMu.RUnlock()
+	result := make(map[string][]byte, len(lastSourceFiles))
+	maps.Copy(result, lastSourceFiles)
 	return result
 }
 
+// ResetSourceFiles empties the sources returned by GetAllSourceFiles.
+//
+// Deprecated: Evaluate no longer needs a reset between evaluations.
 func ResetSourceFiles() {
-	sourceMu.Lock()
-	defer sourceMu.Unlock()
-	sourceFiles = make(map[string][]byte)
+	lastSourceMu.Lock()
+	defer lastSourceMu.Unlock()
+	lastSourceFiles = make(map[string][]byte)
 }
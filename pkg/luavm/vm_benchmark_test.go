@@ -33,7 +33,6 @@ bk.export(result)
 `
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		config := &VMConfig{}
@@ -56,7 +55,6 @@ func BenchmarkEval100LineScript(b *testing.B) {
 
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		config := &VMConfig{}
@@ -82,7 +80,6 @@ bk.export(result)
 `
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		config := &VMConfig{}
@@ -110,7 +107,6 @@ bk.export(final)
 `
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		config := &VMConfig{}
@@ -134,7 +130,6 @@ bk.export(merged)
 `
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		config := &VMConfig{}
@@ -155,7 +150,6 @@ func BenchmarkCreateState(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		if err := L.DoString(`local base = bk.image("alpine:3.19")`); err != nil {
@@ -172,7 +166,6 @@ func BenchmarkRunOperation(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		if err := L.DoString(`local base = bk.image("alpine:3.19"); local result = base:run("echo test")`); err != nil {
@@ -189,7 +182,6 @@ func BenchmarkCopyOperation(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		script := `local base = bk.image("alpine:3.19"); local src = bk.local_("context"); local result = base:copy(src, ".", "/app")`
@@ -211,7 +203,6 @@ bk.export(s4)
 
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
-		ResetSourceFiles()
 		resetExportedState()
 
 		config := &VMConfig{}
@@ -0,0 +1,139 @@
+package ops
+
+import (
+	"maps"
+	"path"
+	"slices"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/kasuboski/luakit/pkg/dag"
+)
+
+// WithEnv returns a state whose image config sets the environment variable.
+// Later runs on the state see it, like a Dockerfile ENV.
+func WithEnv(state *dag.State, key, value string) *dag.State {
+	return withConfig(state, func(c *ocispec.ImageConfig) {
+		c.Env = dag.SetEnv(c.Env, key+"="+value)
+	})
+}
+
+// WithWorkdir returns a state whose image config sets the working directory.
+// A relative dir is resolved against the current one, like a Dockerfile
+// WORKDIR. The directory is not created.
+func WithWorkdir(state *dag.State, dir string) *dag.State {
+	return withConfig(state, func(c *ocispec.ImageConfig) {
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", c.WorkingDir, dir)
+		}
+		c.WorkingDir = path.Clean(dir)
+	})
+}
+
+// WithUser returns a state whose image config sets the user later runs
+// execute as.
+func WithUser(state *dag.State, user string) *dag.State {
+	return withConfig(state, func(c *ocispec.ImageConfig) {
+		c.User = user
+	})
+}
+
+// WithEntrypoint returns a state whose image config sets the entrypoint.
+func WithEntrypoint(state *dag.State, entrypoint []string) *dag.State {
+	return withConfig(state, func(c *ocispec.ImageConfig) {
+		c.Entrypoint = slices.Clone(entrypoint)
+	})
+}
+
+// WithCmd returns a state whose image config sets the default command.
+func WithCmd(state *dag.State, cmd []string) *dag.State {
+	return withConfig(state, func(c *ocispec.ImageConfig) {
+		c.Cmd = slices.Clone(cmd)
+	})
+}
+
+// WithLabel returns a state whose image config sets the label.
+func WithLabel(state *dag.State, key, value string) *dag.State {
+	return withConfig(state, func(c *ocispec.ImageConfig) {
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		c.Labels[key] = value
+	})
+}
+
+// WithConfigStep returns a state whose image config records a config change
+// made at the given Lua call site, which becomes an empty-layer entry in the
+// image history.
+func WithConfigStep(state *dag.State, luaFile string, luaLine int) *dag.State {
+	cfg := &dag.ImageConfig{Config: &ocispec.Image{}}
+	if c := state.ImageConfig(); c != nil {
+		*cfg = *c
+	}
+	cfg.Steps = append(slices.Clip(cfg.Steps), dag.ConfigStep{
+		After:   state.Op(),
+		LuaFile: luaFile,
+		LuaLine: luaLine,
+	})
+	return state.WithImageConfig(cfg)
+}
+
+// withConfig returns a state for the same op with a modified copy of the
+// image config. Configs are shared between states and never changed in place.
+func withConfig(state *dag.State, modify func(*ocispec.ImageConfig)) *dag.State {
+	img := &ocispec.Image{}
+	var steps []dag.ConfigStep
+	if cfg := state.ImageConfig(); cfg != nil {
+		if cfg.Config != nil {
+			img.Config = cloneImageConfig(cfg.Config.Config)
+		}
+		steps = cfg.Steps
+	}
+	modify(&img.Config)
+	return state.WithImageConfig(&dag.ImageConfig{Config: img, Steps: steps})
+}
+
+func cloneImageConfig(c ocispec.ImageConfig) ocispec.ImageConfig {
+	c.Env = slices.Clone(c.Env)
+	c.Entrypoint = slices.Clone(c.Entrypoint)
+	c.Cmd = slices.Clone(c.Cmd)
+	c.Labels = maps.Clone(c.Labels)
+	c.ExposedPorts = maps.Clone(c.ExposedPorts)
+	c.Volumes = maps.Clone(c.Volumes)
+	return c
+}
+
+// inheritConfig carries the image config of state over to result, which was
+// built on top of it.
+func inheritConfig(result, state *dag.State) *dag.State {
+	if result == nil || state.ImageConfig() == nil {
+		return result
+	}
+	return result.WithImageConfig(state.ImageConfig())
+}
+
+// applyStateConfig fills exec options from the image config set on the
+// state. Options given to the run itself take precedence.
+func applyStateConfig(state *dag.State, opts *ExecOptions) *ExecOptions {
+	cfg := state.ImageConfig()
+	if cfg == nil || cfg.Config == nil {
+		return opts
+	}
+	c := cfg.Config.Config
+
+	if opts == nil {
+		opts = &ExecOptions{}
+	}
+	env := slices.Clone(c.Env)
+	for _, e := range opts.Env {
+		env = dag.SetEnv(env, e)
+	}
+	opts.Env = env
+	if opts.Cwd == "" {
+		opts.Cwd = c.WorkingDir
+	}
+	if opts.User == "" {
+		opts.User = c.User
+	}
+	return opts
+}
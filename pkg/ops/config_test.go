@@ -0,0 +1,34 @@
+package ops
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestWithWorkdirResolvesRelativePaths(t *testing.T) {
+	state := WithWorkdir(Scratch(), "/src")
+	state = WithWorkdir(state, "app/../cmd")
+
+	if got := state.ImageConfig().Config.Config.WorkingDir; got != "/src/cmd" {
+		t.Errorf("expected /src/cmd, got %q", got)
+	}
+	if got := WithWorkdir(Scratch(), "rel").ImageConfig().Config.Config.WorkingDir; got != "/rel" {
+		t.Errorf("expected /rel, got %q", got)
+	}
+}
+
+func TestConfigIsInheritedByFileOps(t *testing.T) {
+	base := WithUser(WithEnv(Scratch(), "A", "1"), "nobody")
+
+	copied := Copy(base, Image("alpine", "", 0, nil, nil), "/etc", "/etc", nil, "", 0)
+	made := Mkdir(copied, "/data", nil, "", 0)
+	run := Run(made, []string{"true"}, nil, "", 0)
+
+	exec := run.Op().Op().GetExec()
+	if exec.Meta.User != "nobody" || !slices.Equal(exec.Meta.Env, []string{"A=1"}) {
+		t.Errorf("expected config from before the file ops, got user %q env %v", exec.Meta.User, exec.Meta.Env)
+	}
+	if run.ImageConfig() != base.ImageConfig() {
+		t.Error("expected run result to share the config of its input")
+	}
+}
@@ -1,6 +1,9 @@
 package ops
 
 import (
+	"fmt"
+	"net"
+	"path"
 	"strings"
 
 	pb "github.com/moby/buildkit/solver/pb"
@@ -17,6 +20,70 @@ type ExecOptions struct {
 	Security       *string
 	Hostname       string
 	ValidExitCodes []int32
+	// SecretEnv exposes secrets as environment variables. Their values are
+	// only known to the BuildKit daemon.
+	SecretEnv []SecretEnv
+	// ExtraHosts are added to /etc/hosts in the container.
+	ExtraHosts       []HostIP
+	Ulimits          []Ulimit
+	CgroupParent     string
+	RemoveMountStubs bool
+	ReadonlyRootfs   bool
+}
+
+// HostIP maps a hostname to an IP address.
+type HostIP struct {
+	Host string
+	IP   string
+}
+
+// Ulimit is a resource limit such as nofile, with soft and hard values.
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// ulimitNames are the resource limits BuildKit can set.
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true,
+	"memlock": true, "msgqueue": true, "nice": true, "nofile": true,
+	"nproc": true, "rss": true, "rtprio": true, "rttime": true,
+	"sigpending": true, "stack": true,
+}
+
+// ValidateExtraHost checks that h names a host and maps it to a valid IP.
+func ValidateExtraHost(h HostIP) error {
+	if h.Host == "" || strings.ContainsAny(h.Host, " \t\n") {
+		return fmt.Errorf("invalid host name %q", h.Host)
+	}
+	if net.ParseIP(h.IP) == nil {
+		return fmt.Errorf("invalid IP address %q for host %s", h.IP, h.Host)
+	}
+	return nil
+}
+
+// ValidateUlimit checks the name of u and that its soft limit does not
+// exceed the hard limit. -1 means unlimited.
+func ValidateUlimit(u Ulimit) error {
+	if !ulimitNames[u.Name] {
+		return fmt.Errorf("unknown ulimit %q", u.Name)
+	}
+	if u.Soft < -1 || u.Hard < -1 {
+		return fmt.Errorf("ulimit %s must not be negative", u.Name)
+	}
+	if u.Hard != -1 && (u.Soft == -1 || u.Soft > u.Hard) {
+		return fmt.Errorf("ulimit %s: soft limit %d exceeds hard limit %d", u.Name, u.Soft, u.Hard)
+	}
+	return nil
+}
+
+// SecretEnv exposes the secret ID to a run as the environment variable Name.
+// The run fails when the secret is missing, unless Optional is set.
+type SecretEnv struct {
+	Name     string
+	ID       string
+	Optional bool
 }
 
 func NewExecOp(cmd []string, opts *ExecOptions) *pb.ExecOp {
@@ -59,6 +126,21 @@ func NewExecOp(cmd []string, opts *ExecOptions) *pb.ExecOp {
 		if len(opts.ValidExitCodes) > 0 {
 			meta.ValidExitCodes = opts.ValidExitCodes
 		}
+		for _, h := range opts.ExtraHosts {
+			meta.ExtraHosts = append(meta.ExtraHosts, &pb.HostIP{Host: h.Host, IP: h.IP})
+		}
+		for _, u := range opts.Ulimits {
+			meta.Ulimit = append(meta.Ulimit, &pb.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+		}
+		meta.CgroupParent = opts.CgroupParent
+		meta.RemoveMountStubsRecursive = opts.RemoveMountStubs
+		for _, se := range opts.SecretEnv {
+			op.Secretenv = append(op.Secretenv, &pb.SecretEnv{
+				ID:       se.ID,
+				Name:     se.Name,
+				Optional: se.Optional,
+			})
+		}
 	}
 
 	return op
@@ -123,6 +205,64 @@ func assignMountIndices(mounts []*Mount, startIndex int) {
 	}
 }
 
+// assignMountOutputs numbers the outputs of an exec: the rootfs is output 0
+// and each writable bind mount gets the next index. Read-only, cache, tmpfs,
+// secret and SSH mounts produce no output.
+func assignMountOutputs(mounts []*pb.Mount) {
+	next := int64(1)
+	for _, m := range mounts {
+		switch {
+		case m.Dest == "/":
+			m.Output = 0
+		case m.MountType == pb.MountType_BIND && !m.Readonly:
+			m.Output = next
+			next++
+		default:
+			m.Output = int64(pb.SkipOutput)
+		}
+	}
+}
+
+// MountOutput returns the state of the mount at dest after the exec that
+// produced state has run. Only the rootfs and writable bind mounts have
+// outputs.
+func MountOutput(state *dag.State, dest string) (*dag.State, error) {
+	exec := state.Op().Op().GetExec()
+	if exec == nil {
+		return nil, fmt.Errorf("state is not the result of run")
+	}
+
+	dest = path.Clean(dest)
+	for _, m := range exec.Mounts {
+		if path.Clean(m.Dest) != dest {
+			continue
+		}
+		if m.Output == int64(pb.SkipOutput) {
+			return nil, fmt.Errorf("mount at %s has no output: %s", dest, mountKind(m))
+		}
+		if m.Output == 0 {
+			return inheritConfig(dag.NewState(state.Op()), state), nil
+		}
+		return dag.NewStateWithOutput(state.Op(), int(m.Output)), nil
+	}
+	return nil, fmt.Errorf("no mount at %s", dest)
+}
+
+func mountKind(m *pb.Mount) string {
+	switch m.MountType {
+	case pb.MountType_CACHE:
+		return "cache mounts are not part of the result"
+	case pb.MountType_TMPFS:
+		return "tmpfs mounts are discarded after the run"
+	case pb.MountType_SECRET:
+		return "secret mounts are not part of the result"
+	case pb.MountType_SSH:
+		return "ssh mounts are not part of the result"
+	default:
+		return "the bind mount is read-only; pass readonly = false to keep its changes"
+	}
+}
+
 func NewExecState(state *dag.State, op *pb.ExecOp, luaFile string, luaLine int) *dag.State {
 	return NewExecStateWithOpts(state, op, nil, luaFile, luaLine)
 }
@@ -144,7 +284,7 @@ func NewExecStateWithOpts(state *dag.State, op *pb.ExecOp, opts *ExecOptions, lu
 			Output:    0,
 			Dest:      "/",
 			MountType: pb.MountType_BIND,
-			Readonly:  false,
+			Readonly:  opts != nil && opts.ReadonlyRootfs,
 		}
 		// Prepend to ensure it's at input 0
 		op.Mounts = append([]*pb.Mount{rootfsMount}, op.Mounts...)
@@ -160,6 +300,7 @@ func NewExecStateWithOpts(state *dag.State, op *pb.ExecOp, opts *ExecOptions, lu
 
 	// Assign input indices to bind mounts (starting from 1, after rootfs)
 	assignMountIndices(bindMounts, 1)
+	assignMountOutputs(op.Mounts)
 
 	// Build inputs array: rootfs at index 0, bind mounts at subsequent indices
 	inputs := []*pb.Input{
@@ -190,7 +331,7 @@ func NewExecStateWithOpts(state *dag.State, op *pb.ExecOp, opts *ExecOptions, lu
 		node.AddInput(dag.NewEdge(bindState.Op(), bindState.OutputIndex()))
 	}
 
-	return dag.NewState(node)
+	return inheritConfig(dag.NewState(node), state)
 }
 
 func Run(state *dag.State, cmd []string, opts *ExecOptions, luaFile string, luaLine int) *dag.State {
@@ -198,6 +339,8 @@ func Run(state *dag.State, cmd []string, opts *ExecOptions, luaFile string, luaL
 		return nil
 	}
 
+	opts = applyStateConfig(state, opts)
+
 	// If input state's OpNode has image config, inherit environment variables and working directory
 	imageConfig := state.Op().ImageConfig()
 	if imageConfig != nil && imageConfig.Config != nil {
@@ -425,3 +425,107 @@ func TestNewExecStateWithOpts_AddsRootfsMount(t *testing.T) {
 		require.Equal(t, pb.MountType_CACHE, cachePbMount.GetMountType(), "second mount should be CACHE type")
 	})
 }
+
+func TestMountOutput(t *testing.T) {
+	base := Image("alpine:3.19", "test.lua", 1, nil, nil)
+	out := Scratch()
+	src := Image("golang:1.22", "test.lua", 2, nil, nil)
+
+	state := Run(base, []string{"make"}, &ExecOptions{
+		Mounts: []*Mount{
+			BindMount(src, "/src", nil),
+			BindMount(out, "/out", &BindOptions{Readonly: false}),
+			CacheMount("/root/.cache", nil),
+			TmpfsMount("/tmp", nil),
+			SecretMount("/run/secrets/token", &SecretOptions{ID: "token"}),
+		},
+	}, "test.lua", 3)
+	require.NotNil(t, state)
+
+	mounts := state.Op().Op().GetExec().Mounts
+	outputs := make(map[string]int64)
+	for _, m := range mounts {
+		outputs[m.Dest] = m.Output
+	}
+	require.Equal(t, map[string]int64{
+		"/":                  0,
+		"/src":               int64(pb.SkipOutput),
+		"/out":               1,
+		"/root/.cache":       int64(pb.SkipOutput),
+		"/tmp":               int64(pb.SkipOutput),
+		"/run/secrets/token": int64(pb.SkipOutput),
+	}, outputs)
+
+	outState, err := MountOutput(state, "/out/")
+	require.NoError(t, err)
+	require.Equal(t, state.Op(), outState.Op())
+	require.Equal(t, 1, outState.OutputIndex())
+
+	root, err := MountOutput(state, "/")
+	require.NoError(t, err)
+	require.Equal(t, 0, root.OutputIndex())
+
+	for dest, want := range map[string]string{
+		"/src":               "read-only",
+		"/root/.cache":       "cache",
+		"/tmp":               "tmpfs",
+		"/run/secrets/token": "secret",
+		"/missing":           "no mount at /missing",
+	} {
+		_, err := MountOutput(state, dest)
+		require.ErrorContains(t, err, want, dest)
+	}
+
+	_, err = MountOutput(base, "/")
+	require.ErrorContains(t, err, "not the result of run")
+}
+
+func TestNewExecOpSecretEnv(t *testing.T) {
+	opts := &ExecOptions{
+		Env: []string{"CI=true"},
+		SecretEnv: []SecretEnv{
+			{Name: "NPM_TOKEN", ID: "npm"},
+			{Name: "AWS_PROFILE", ID: "aws", Optional: true},
+		},
+	}
+
+	op := NewExecOp([]string{"npm", "publish"}, opts)
+	require.Equal(t, []*pb.SecretEnv{
+		{ID: "npm", Name: "NPM_TOKEN"},
+		{ID: "aws", Name: "AWS_PROFILE", Optional: true},
+	}, op.Secretenv)
+	require.Equal(t, []string{"CI=true"}, op.Meta.Env, "secrets must not be added to Meta.Env")
+}
+
+func TestNewExecOpSandboxOptions(t *testing.T) {
+	opts := &ExecOptions{
+		ExtraHosts:       []HostIP{{Host: "db.test", IP: "10.0.0.5"}},
+		Ulimits:          []Ulimit{{Name: "nofile", Soft: 1024, Hard: 65536}},
+		CgroupParent:     "ci.slice",
+		RemoveMountStubs: true,
+		ReadonlyRootfs:   true,
+	}
+	base := Image("alpine:3.19", "test.lua", 1, nil, nil)
+	state := Run(base, []string{"true"}, opts, "test.lua", 2)
+
+	exec := state.Op().Op().GetExec()
+	require.Equal(t, []*pb.HostIP{{Host: "db.test", IP: "10.0.0.5"}}, exec.Meta.ExtraHosts)
+	require.Equal(t, []*pb.Ulimit{{Name: "nofile", Soft: 1024, Hard: 65536}}, exec.Meta.Ulimit)
+	require.Equal(t, "ci.slice", exec.Meta.CgroupParent)
+	require.True(t, exec.Meta.RemoveMountStubsRecursive)
+	require.True(t, exec.Mounts[0].Readonly, "the rootfs is mounted read-only")
+	require.Equal(t, int64(0), exec.Mounts[0].Output, "the unchanged rootfs is still the result")
+}
+
+func TestValidateExtraHostAndUlimit(t *testing.T) {
+	require.NoError(t, ValidateExtraHost(HostIP{Host: "db.test", IP: "10.0.0.5"}))
+	require.NoError(t, ValidateExtraHost(HostIP{Host: "db.test", IP: "::1"}))
+	require.ErrorContains(t, ValidateExtraHost(HostIP{Host: "db.test", IP: "10.0.0"}), "invalid IP address")
+	require.ErrorContains(t, ValidateExtraHost(HostIP{Host: "", IP: "10.0.0.5"}), "invalid host name")
+
+	require.NoError(t, ValidateUlimit(Ulimit{Name: "nofile", Soft: 1024, Hard: 65536}))
+	require.NoError(t, ValidateUlimit(Ulimit{Name: "memlock", Soft: -1, Hard: -1}))
+	require.ErrorContains(t, ValidateUlimit(Ulimit{Name: "files", Soft: 1, Hard: 1}), "unknown ulimit")
+	require.ErrorContains(t, ValidateUlimit(Ulimit{Name: "nofile", Soft: 2048, Hard: 1024}), "exceeds hard limit")
+	require.ErrorContains(t, ValidateUlimit(Ulimit{Name: "nofile", Soft: -2, Hard: 1024}), "must not be negative")
+}
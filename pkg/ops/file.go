@@ -64,7 +64,7 @@ func NewFileState(state *dag.State, op *pb.FileOp, luaFile string, luaLine int)
 	node := dag.NewOpNode(pbOp, luaFile, luaLine)
 	node.AddInput(dag.NewEdge(state.Op(), state.OutputIndex()))
 
-	return dag.NewState(node)
+	return inheritConfig(dag.NewState(node), state)
 }
 
 func NewCopyFileState(state *dag.State, fromState *dag.State, src, dest string, opts *CopyOptions, luaFile string, luaLine int) *dag.State {
@@ -126,7 +126,7 @@ func NewCopyFileState(state *dag.State, fromState *dag.State, src, dest string,
 	node.AddInput(dag.NewEdge(fromState.Op(), fromState.OutputIndex()))
 	node.AddInput(dag.NewEdge(state.Op(), state.OutputIndex()))
 
-	return dag.NewState(node)
+	return inheritConfig(dag.NewState(node), state)
 }
 
 func Copy(state *dag.State, fromState *dag.State, src, dest string, opts *CopyOptions, luaFile string, luaLine int) *dag.State {
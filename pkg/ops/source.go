@@ -1,6 +1,7 @@
 package ops
 
 import (
+	"cmp"
 	"fmt"
 	"log"
 	"net/url"
@@ -21,7 +22,20 @@ type LocalOptions struct {
 
 type GitOptions struct {
 	Ref        string
+	Subdir     string
 	KeepGitDir bool
+	// Checksum is the commit Ref is expected to resolve to.
+	Checksum       string
+	SkipSubmodules bool
+	// AuthTokenSecret and AuthHeaderSecret are IDs of BuildKit secrets used
+	// to authenticate HTTP remotes.
+	AuthTokenSecret  string
+	AuthHeaderSecret string
+	// SSH is the ID of the SSH agent socket mounted to fetch SSH remotes,
+	// "default" if empty. NoMountSSHSock fetches them without an agent.
+	SSH            string
+	NoMountSSHSock bool
+	KnownSSHHosts  []string
 }
 
 type HTTPOptions struct {
@@ -31,6 +45,10 @@ type HTTPOptions struct {
 	Headers  map[string]string
 	Username string
 	Password string // #nosec G117 -- Password field for HTTP basic auth, not a hard-coded secret
+	// AuthSecret is the ID of a BuildKit secret holding the value of the
+	// Authorization header, such as "Bearer <token>". Unlike Username and
+	// Password it keeps the credential out of the definition and cache keys.
+	AuthSecret string
 }
 
 type ImageOptions struct {
@@ -42,6 +60,7 @@ const (
 	dockerImagePrefix = "docker-image://"
 	localPrefix       = "local://"
 	gitPrefix         = "git://"
+	ociLayoutPrefix   = "oci-layout://"
 	scratchIdentifier = "scratch"
 )
 
@@ -86,6 +105,7 @@ func Image(ref string, luaFile string, luaLine int, platform *pb.Platform, opts
 	state := NewSourceState(op, luaFile, luaLine)
 
 	if platform != nil {
+		state.Op().SetPlatform(platform)
 		state = state.WithPlatform(platform)
 	}
 
@@ -148,18 +168,45 @@ func Git(url string, luaFile string, luaLine int, opts *GitOptions) *dag.State {
 		return nil
 	}
 
-	ref := ""
-	keepGitDir := false
-	if opts != nil {
-		ref = opts.Ref
-		keepGitDir = opts.KeepGitDir
+	if opts == nil {
+		opts = &GitOptions{}
 	}
 
-	identifier := GitIdentifier(url, ref)
+	identifier := GitIdentifier(url, opts.Ref)
+	if opts.Subdir != "" {
+		if opts.Ref == "" {
+			identifier += "#"
+		}
+		identifier += ":" + opts.Subdir
+	}
 	attrs := make(map[string]string)
 
-	if keepGitDir {
-		attrs["keepgitdir"] = "true"
+	if opts.KeepGitDir {
+		attrs[pb.AttrKeepGitDir] = "true"
+	}
+	if opts.Checksum != "" {
+		attrs[pb.AttrGitChecksum] = opts.Checksum
+	}
+	if opts.SkipSubmodules {
+		attrs[pb.AttrGitSkipSubmodules] = "true"
+	}
+	if opts.AuthTokenSecret != "" {
+		attrs[pb.AttrAuthTokenSecret] = opts.AuthTokenSecret
+	}
+	if opts.AuthHeaderSecret != "" {
+		attrs[pb.AttrAuthHeaderSecret] = opts.AuthHeaderSecret
+	}
+	if isSSHGitURL(url) {
+		if !opts.NoMountSSHSock {
+			attrs[pb.AttrMountSSHSock] = cmp.Or(opts.SSH, "default")
+		}
+		if len(opts.KnownSSHHosts) > 0 {
+			var hosts strings.Builder
+			for _, h := range opts.KnownSSHHosts {
+				hosts.WriteString(strings.TrimSuffix(h, "\n") + "\n")
+			}
+			attrs[pb.AttrKnownSSHHosts] = hosts.String()
+		}
 	}
 
 	op := NewSourceOp(identifier, attrs)
@@ -199,12 +246,27 @@ func HTTP(url string, luaFile string, luaLine int, opts *HTTPOptions) *dag.State
 			}
 			attrs["http.basicauth"] = opts.Username + ":" + opts.Password
 		}
+		if opts.AuthSecret != "" {
+			attrs[pb.AttrHTTPAuthHeaderSecret] = opts.AuthSecret
+		}
 	}
 
 	op := NewSourceOp(identifier, attrs)
 	return NewSourceState(op, luaFile, luaLine)
 }
 
+// OCILayout loads ref, a name pinned by digest, from the OCI layout store
+// the client attached to the session as storeID.
+func OCILayout(ref string, storeID string, luaFile string, luaLine int) *dag.State {
+	if ref == "" || storeID == "" {
+		return nil
+	}
+
+	attrs := map[string]string{pb.AttrOCILayoutStoreID: storeID}
+	op := NewSourceOp(ociLayoutPrefix+ref, attrs)
+	return NewSourceState(op, luaFile, luaLine)
+}
+
 func hasPrefix(s, prefix string) bool {
 	if len(s) < len(prefix) {
 		return false
@@ -352,10 +414,8 @@ func ValidateGitURL(rawURL string) error {
 	var u *url.URL
 	var err error
 
-	if strings.HasPrefix(rawURL, "git@") {
-		sshURL := strings.Replace(rawURL, ":", "/", 1)
-		sshURL = "ssh://" + sshURL
-		u, err = url.Parse(sshURL)
+	if m := scpGitURL.FindStringSubmatch(rawURL); m != nil {
+		u, err = url.Parse("ssh://" + m[1] + "/" + strings.TrimPrefix(m[2], "/"))
 		if err != nil {
 			return fmt.Errorf("invalid git SSH URL: %w", err)
 		}
@@ -386,6 +446,16 @@ func ValidateGitURL(rawURL string) error {
 	return nil
 }
 
+// scpGitURL matches scp-style git remotes such as git@github.com:org/repo,
+// capturing the user and host, and the path.
+var scpGitURL = regexp.MustCompile(`^([A-Za-z0-9._~-]+@[A-Za-z0-9.-]+):(.+)$`)
+
+// isSSHGitURL reports whether a git remote is fetched over SSH.
+func isSSHGitURL(rawURL string) bool {
+	return scpGitURL.MatchString(rawURL) ||
+		strings.HasPrefix(rawURL, "ssh://") || strings.HasPrefix(rawURL, "git+ssh://")
+}
+
 func ValidateHTTPURL(rawURL string) error {
 	if rawURL == "" {
 		return fmt.Errorf("http URL must not be empty")
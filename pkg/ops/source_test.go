@@ -1,6 +1,7 @@
 package ops
 
 import (
+	"maps"
 	"strings"
 	"testing"
 
@@ -418,8 +419,8 @@ func TestGitWithKeepGitDir(t *testing.T) {
 		t.Fatal("Expected SourceOp")
 	}
 
-	if sourceOp.Attrs["keepgitdir"] != "true" {
-		t.Errorf("Expected keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["keepgitdir"])
+	if sourceOp.Attrs["git.keepgitdir"] != "true" {
+		t.Errorf("Expected git.keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["git.keepgitdir"])
 	}
 }
 
@@ -444,8 +445,92 @@ func TestGitWithBothOptions(t *testing.T) {
 		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, sourceOp.Identifier)
 	}
 
-	if sourceOp.Attrs["keepgitdir"] != "true" {
-		t.Errorf("Expected keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["keepgitdir"])
+	if sourceOp.Attrs["git.keepgitdir"] != "true" {
+		t.Errorf("Expected git.keepgitdir attribute 'true', got '%s'", sourceOp.Attrs["git.keepgitdir"])
+	}
+}
+
+func TestGitWithAuthAndChecksum(t *testing.T) {
+	opts := &GitOptions{
+		Ref:              "v1.2.0",
+		Subdir:           "services/api",
+		Checksum:         "0123456789abcdef0123456789abcdef01234567",
+		SkipSubmodules:   true,
+		AuthTokenSecret:  "gh_token",
+		AuthHeaderSecret: "gh_header",
+	}
+	state := Git("https://github.com/acme/monorepo.git", "test.lua", 15, opts)
+	if state == nil {
+		t.Fatal("Expected non-nil state")
+	}
+
+	sourceOp := state.Op().Op().GetSource()
+	expectedIdentifier := "git://https://github.com/acme/monorepo.git#v1.2.0:services/api"
+	if sourceOp.Identifier != expectedIdentifier {
+		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, sourceOp.Identifier)
+	}
+	expectedAttrs := map[string]string{
+		"git.checksum":         "0123456789abcdef0123456789abcdef01234567",
+		"git.skipsubmodules":   "true",
+		"git.authtokensecret":  "gh_token",
+		"git.authheadersecret": "gh_header",
+	}
+	if !maps.Equal(sourceOp.Attrs, expectedAttrs) {
+		t.Errorf("Expected attrs %v, got %v (HTTP remotes do not mount an SSH socket)", expectedAttrs, sourceOp.Attrs)
+	}
+}
+
+func TestGitSubdirWithoutRef(t *testing.T) {
+	state := Git("https://github.com/acme/monorepo.git", "test.lua", 15, &GitOptions{Subdir: "docs"})
+	if state == nil {
+		t.Fatal("Expected non-nil state")
+	}
+
+	expectedIdentifier := "git://https://github.com/acme/monorepo.git#:docs"
+	if id := state.Op().Op().GetSource().Identifier; id != expectedIdentifier {
+		t.Errorf("Expected identifier '%s', got '%s'", expectedIdentifier, id)
+	}
+}
+
+func TestGitSSH(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		opts  *GitOptions
+		attrs map[string]string
+	}{
+		{
+			name:  "default socket",
+			url:   "git@github.com:acme/monorepo.git",
+			attrs: map[string]string{"git.mountsshsock": "default"},
+		},
+		{
+			name: "named socket and known hosts",
+			url:  "ssh://git@git.example.com/acme/monorepo.git",
+			opts: &GitOptions{SSH: "deploy", KnownSSHHosts: []string{"git.example.com ssh-ed25519 AAAA\n", "git.example.com ssh-rsa BBBB"}},
+			attrs: map[string]string{
+				"git.mountsshsock":  "deploy",
+				"git.knownsshhosts": "git.example.com ssh-ed25519 AAAA\ngit.example.com ssh-rsa BBBB\n",
+			},
+		},
+		{
+			name:  "no socket",
+			url:   "deploy@git.example.com:acme/monorepo.git",
+			opts:  &GitOptions{NoMountSSHSock: true},
+			attrs: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := Git(tt.url, "test.lua", 15, tt.opts)
+			if state == nil {
+				t.Fatal("Expected non-nil state")
+			}
+			if attrs := state.Op().Op().GetSource().Attrs; !maps.Equal(attrs, tt.attrs) {
+				t.Errorf("Expected attrs %v, got %v", tt.attrs, attrs)
+			}
+		})
 	}
 }
 
@@ -469,6 +554,8 @@ func TestValidateGitURL(t *testing.T) {
 		{"git+ssh://git@github.com/moby/buildkit.git", false},
 		{"git@github.com:moby/buildkit.git", false},
 		{"git@gitlab.com:group/project.git", false},
+		{"deploy@git.example.com:/srv/repo.git", false},
+		{"git@github.com", true},
 		{"https://gitlab.com/group/project.git", false},
 		{"https://bitbucket.org/user/repo.git", false},
 		{"", true},
@@ -586,6 +673,23 @@ func TestHTTPWithHeaders(t *testing.T) {
 	}
 }
 
+func TestHTTPWithAuthSecret(t *testing.T) {
+	opts := &HTTPOptions{AuthSecret: "registry_token"}
+	state := HTTP("https://example.com/file.tar.gz", "test.lua", 20, opts)
+
+	if state == nil {
+		t.Fatal("Expected non-nil state")
+	}
+
+	sourceOp := state.Op().Op().GetSource()
+	if sourceOp.Attrs["http.authheadersecret"] != "registry_token" {
+		t.Errorf("Expected http.authheadersecret 'registry_token', got '%s'", sourceOp.Attrs["http.authheadersecret"])
+	}
+	if len(sourceOp.Attrs) != 1 {
+		t.Errorf("Expected only the secret ID in attrs, got %v", sourceOp.Attrs)
+	}
+}
+
 func TestHTTPWithBasicAuth(t *testing.T) {
 	opts := &HTTPOptions{
 		Username: "user",
@@ -683,3 +787,23 @@ func TestValidateHTTPURL(t *testing.T) {
 		}
 	}
 }
+
+func TestOCILayout(t *testing.T) {
+	ref := "docker.io/library/app@sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"
+	state := OCILayout(ref, "store1", "test.lua", 3)
+	if state == nil {
+		t.Fatal("Expected non-nil state")
+	}
+
+	sourceOp := state.Op().Op().GetSource()
+	if sourceOp.Identifier != "oci-layout://"+ref {
+		t.Errorf("Expected oci-layout identifier, got '%s'", sourceOp.Identifier)
+	}
+	if sourceOp.Attrs["oci.store"] != "store1" {
+		t.Errorf("Expected oci.store 'store1', got %v", sourceOp.Attrs)
+	}
+
+	if OCILayout(ref, "", "test.lua", 3) != nil {
+		t.Error("Expected nil state without a store")
+	}
+}
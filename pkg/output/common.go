@@ -1,6 +1,7 @@
 package output
 
 import (
+	"fmt"
 	"os"
 
 	pb "github.com/moby/buildkit/solver/pb"
@@ -38,3 +39,32 @@ func getOpType(op *pb.Op) string {
 		return "Unknown"
 	}
 }
+
+// extraHosts formats the /etc/hosts entries of an exec as host=ip.
+func extraHosts(meta *pb.Meta) []string {
+	var hosts []string
+	for _, h := range meta.ExtraHosts {
+		hosts = append(hosts, h.Host+"="+h.IP)
+	}
+	return hosts
+}
+
+// ulimits formats the resource limits of an exec as name=soft:hard.
+func ulimits(meta *pb.Meta) []string {
+	var limits []string
+	for _, u := range meta.Ulimit {
+		limits = append(limits, fmt.Sprintf("%s=%d:%d", u.Name, u.Soft, u.Hard))
+	}
+	return limits
+}
+
+// readonlyRootfs reports whether an exec mounts its root filesystem
+// read-only.
+func readonlyRootfs(exec *pb.ExecOp) bool {
+	for _, m := range exec.Mounts {
+		if m.Dest == "/" {
+			return m.Readonly
+		}
+	}
+	return false
+}
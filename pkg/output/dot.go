@@ -78,6 +78,23 @@ func (w *DOTWriter) writeNode(state *dag.State, visited map[string]bool, builder
 			}
 			label += fmt.Sprintf("\\ncmd: %s", cmd)
 		}
+		if opType.Exec != nil {
+			if hosts := extraHosts(opType.Exec.Meta); len(hosts) > 0 {
+				label += fmt.Sprintf("\\nhosts: %s", strings.Join(hosts, " "))
+			}
+			if limits := ulimits(opType.Exec.Meta); len(limits) > 0 {
+				label += fmt.Sprintf("\\nulimits: %s", strings.Join(limits, " "))
+			}
+			if opType.Exec.Meta.CgroupParent != "" {
+				label += fmt.Sprintf("\\ncgroup: %s", opType.Exec.Meta.CgroupParent)
+			}
+			if readonlyRootfs(opType.Exec) {
+				label += "\\nreadonly rootfs"
+			}
+			if opType.Exec.Meta.RemoveMountStubsRecursive {
+				label += "\\nremove mount stubs"
+			}
+		}
 	case *pb.Op_Source:
 		if opType.Source != nil && opType.Source.Identifier != "" {
 			identifier := opType.Source.Identifier
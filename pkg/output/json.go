@@ -23,12 +23,18 @@ type DAGNode struct {
 }
 
 type NodeDetails struct {
-	Identifier string            `json:"identifier,omitempty"`
-	Command    []string          `json:"command,omitempty"`
-	Env        []string          `json:"env,omitempty"`
-	Cwd        string            `json:"cwd,omitempty"`
-	User       string            `json:"user,omitempty"`
-	Attrs      map[string]string `json:"attrs,omitempty"`
+	Identifier       string            `json:"identifier,omitempty"`
+	Command          []string          `json:"command,omitempty"`
+	Env              []string          `json:"env,omitempty"`
+	Cwd              string            `json:"cwd,omitempty"`
+	User             string            `json:"user,omitempty"`
+	SecretEnv        map[string]string `json:"secret_env,omitempty"`
+	ExtraHosts       []string          `json:"extra_hosts,omitempty"`
+	Ulimits          []string          `json:"ulimits,omitempty"`
+	CgroupParent     string            `json:"cgroup_parent,omitempty"`
+	RemoveMountStubs bool              `json:"remove_mount_stubs,omitempty"`
+	ReadonlyRootfs   bool              `json:"readonly_rootfs,omitempty"`
+	Attrs            map[string]string `json:"attrs,omitempty"`
 }
 
 func NewJSONWriter(outputPath string) *JSONWriter {
@@ -110,6 +116,19 @@ func (w *JSONWriter) extractDetails(op *pb.Op) *NodeDetails {
 			details.Env = opType.Exec.Meta.Env
 			details.Cwd = opType.Exec.Meta.Cwd
 			details.User = opType.Exec.Meta.User
+			// Only the secret IDs are known here; their values are
+			// provided by the client when the build runs.
+			for _, se := range opType.Exec.Secretenv {
+				if details.SecretEnv == nil {
+					details.SecretEnv = make(map[string]string)
+				}
+				details.SecretEnv[se.Name] = se.ID
+			}
+			details.ExtraHosts = extraHosts(opType.Exec.Meta)
+			details.Ulimits = ulimits(opType.Exec.Meta)
+			details.CgroupParent = opType.Exec.Meta.CgroupParent
+			details.RemoveMountStubs = opType.Exec.Meta.RemoveMountStubsRecursive
+			details.ReadonlyRootfs = readonlyRootfs(opType.Exec)
 		}
 	case *pb.Op_File:
 		if opType.File != nil && len(opType.File.Actions) > 0 {
@@ -262,3 +262,85 @@ func TestJSONWriterWithFilter(t *testing.T) {
 		}
 	})
 }
+
+func TestWritersShowSecretEnvIDsOnly(t *testing.T) {
+	result, err := luavm.Evaluate(strings.NewReader(`
+local s = bk.image("alpine"):run("npm publish", { secret_env = { NPM_TOKEN = "npm_token_id" } })
+bk.export(s)
+`), "test.lua", nil)
+	if err != nil {
+		t.Fatalf("failed to run test script: %v", err)
+	}
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "graph.json")
+	if err := NewJSONWriter(jsonFile).Write(result.State); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if !strings.Contains(string(data), `"secret_env"`) || !strings.Contains(string(data), `"NPM_TOKEN": "npm_token_id"`) {
+		t.Errorf("JSON output should map NPM_TOKEN to its secret id, got:\n%s", data)
+	}
+	if strings.Contains(string(data), "NPM_TOKEN=") {
+		t.Errorf("JSON output must not set NPM_TOKEN in env, got:\n%s", data)
+	}
+
+	dotFile := filepath.Join(dir, "graph.dot")
+	if err := NewDOTWriter(dotFile).Write(result.State); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err = os.ReadFile(dotFile)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	if strings.Contains(string(data), "NPM_TOKEN=") {
+		t.Errorf("DOT output must not set NPM_TOKEN, got:\n%s", data)
+	}
+}
+
+func TestWritersShowSandboxOptions(t *testing.T) {
+	result, err := luavm.Evaluate(strings.NewReader(`
+local s = bk.image("alpine"):run("make test", {
+    extra_hosts = { ["db.test"] = "10.0.0.5" },
+    ulimits = { nofile = { soft = 1024, hard = 65536 } },
+    cgroup_parent = "ci.slice",
+    readonly_rootfs = true,
+})
+bk.export(s)
+`), "test.lua", nil)
+	if err != nil {
+		t.Fatalf("failed to run test script: %v", err)
+	}
+
+	dir := t.TempDir()
+	jsonFile := filepath.Join(dir, "graph.json")
+	if err := NewJSONWriter(jsonFile).Write(result.State); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	for _, want := range []string{`"db.test=10.0.0.5"`, `"nofile=1024:65536"`, `"cgroup_parent": "ci.slice"`, `"readonly_rootfs": true`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON output should contain %s, got:\n%s", want, data)
+		}
+	}
+
+	dotFile := filepath.Join(dir, "graph.dot")
+	if err := NewDOTWriter(dotFile).Write(result.State); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err = os.ReadFile(dotFile)
+	if err != nil {
+		t.Fatalf("failed to read output file: %v", err)
+	}
+	for _, want := range []string{`\nhosts: db.test=10.0.0.5`, `\nulimits: nofile=1024:65536`, `\ncgroup: ci.slice`, `\nreadonly rootfs`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("DOT output should contain %s, got:\n%s", want, data)
+		}
+	}
+}
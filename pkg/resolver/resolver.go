@@ -182,8 +182,10 @@ func (r *Resolver) Resolve(ctx context.Context, refStr string, platform ocispec.
 
 	logrus.Debugf("Resolving image: %s for platform %+v", ref, platform)
 
-	// Check cache first
-	if cached, err := r.cache.Get(ref); cached != nil {
+	// Check cache first. Entries are per platform since an index resolves to
+	// a different manifest for each one.
+	cacheKey := ref + "|" + platforms.Format(platform)
+	if cached, err := r.cache.Get(cacheKey); cached != nil {
 		if cachedConfig, ok := cached.(*ImageConfig); ok {
 			return cachedConfig, err
 		}
@@ -318,7 +320,7 @@ func (r *Resolver) Resolve(ctx context.Context, refStr string, platform ocispec.
 	}
 
 	// Cache the result
-	r.cache.Set(ref, config, nil)
+	r.cache.Set(cacheKey, config, nil)
 
 	return config, nil
 }
@@ -12,6 +12,14 @@
 ---@class GitOptions
 ---@field ref? string
 ---@field keep_git_dir? boolean
+---@field subdir? string
+---@field checksum? string
+---@field submodules? boolean
+---@field auth_token_secret? string
+---@field auth_header_secret? string
+---@field ssh? string
+---@field mount_ssh_sock? boolean
+---@field known_ssh_hosts? string|string[]
 
 ---@class HTTPOptions
 ---@field checksum? string
@@ -20,6 +28,11 @@
 ---@field headers? table<string, string>
 ---@field username? string
 ---@field password? string
+---@field auth_secret? string
+---@field header_secrets? { Authorization?: string }
+
+---@class ContextOptions
+---@field default? State State used when the context is not given
 
 ---@class CacheOptions
 ---@field id? string
@@ -58,6 +71,27 @@
 ---@field os? string
 ---@field arch? string
 ---@field variant? string
+---@field os_version? string
+---@field os_features? string[]
+---@field volumes? string[]
+---@field stop_signal? string
+---@field shell? string[]
+---@field onbuild? string[]
+---@field healthcheck? Healthcheck
+---@field created? string|integer RFC 3339 timestamp or Unix seconds
+---@field annotations? table<string, string> Image manifest annotations
+---@field index_annotations? table<string, string> Image index annotations
+---@field inherit? boolean
+
+---@alias duration string|number A duration such as "30s", or seconds
+
+---@class Healthcheck
+---@field test string|string[] A string runs with the shell; a list runs directly
+---@field interval? duration
+---@field timeout? duration
+---@field start_period? duration
+---@field start_interval? duration
+---@field retries? integer
 
 ---@alias platform_string string
 
@@ -99,6 +133,13 @@ function BK.http(url, opts) end
 ---@return State state
 function BK.https(url, opts) end
 
+---Named build context, given with --build-context or the gateway's
+---context:NAME opts and inputs, or opts.default when it is not given.
+---@param name string Context name
+---@param opts? ContextOptions Optional context options
+---@return State state
+function BK.context(name, opts) end
+
 ---@param dest string Destination path
 ---@param opts? CacheOptions Optional cache options
 ---@return Mount mount
@@ -133,10 +174,37 @@ function BK.merge(...) end
 ---@return State state
 function BK.diff(lower, upper) end
 
----@param state State State to export
+---Export the final state. Pass a table keyed by platform string, or a function
+---called once per requested platform, to produce a multi-platform image.
+---@param state State|table<platform_string, State>|fun(platform: Platform): State State to export
 ---@param opts? ExportConfig Optional export config
 function BK.export(state, opts) end
 
+---Register a named build target. Only the selected target's function is run;
+---it should call bk.export or return the State to build.
+---@param name string Target name, selected with --target
+---@param fn fun(): State? Function that builds the target
+function BK.target(name, fn) end
+
+---Fix the build's timestamps to SOURCE_DATE_EPOCH for reproducible output.
+---@param seconds integer|string Seconds since the Unix epoch
+function BK.set_epoch(seconds) end
+
+---Contents of a file in the build context, relative to the script.
+---@param path string Path in the build context
+---@return string contents
+function BK.read_file(path) end
+
+---Whether a file or directory exists in the build context.
+---@param path string Path in the build context
+---@return boolean exists
+function BK.exists(path) end
+
+---Sorted paths in the build context matching a path.Match pattern.
+---@param pattern string Pattern such as "services/*.json"
+---@return string[] paths
+function BK.glob(pattern) end
+
 ---@param os string OS name
 ---@param arch string Architecture
 ---@param variant? string Variant
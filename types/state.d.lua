@@ -9,6 +9,20 @@
 ---@field mounts? Mount[]
 ---@field hostname? string
 ---@field valid_exit_codes? integer|integer[]|string
+---@field secret_env? table<string, string|SecretEnv>
+---@field extra_hosts? table<string, string>
+---@field ulimits? table<string, integer|Ulimit>
+---@field cgroup_parent? string
+---@field remove_mount_stubs? boolean
+---@field readonly_rootfs? boolean
+
+---@class SecretEnv
+---@field id string
+---@field optional? boolean
+
+---@class Ulimit
+---@field soft integer
+---@field hard? integer
 
 ---@class CopyOptions
 ---@field mode? string|integer
@@ -35,6 +49,7 @@
 ---@class MetadataOptions
 ---@field description? string
 ---@field progress_group? string
+---@field comment? string Comment on the op's image history entry
 
 ---@class ChownOpt
 ---@field user? UserOpt
@@ -49,7 +64,7 @@ local State = {}
 ---Execute a command in the state
 ---@param cmd string|string[] The command to run (string for shell, table for args)
 ---@param opts? ExecOptions Optional execution options
----@return State state
+---@return Exec exec
 function State:run(cmd, opts) end
 
 ---Copy files from another state
@@ -89,3 +104,17 @@ function State:symlink(oldpath, newpath) end
 ---@param opts MetadataOptions Metadata options
 ---@return State state
 function State:with_metadata(opts) end
+
+---The result of State:run. It can be used as the root filesystem after the
+---command ran wherever a State is expected.
+---@class Exec: State
+local Exec = {}
+
+---The root filesystem after the command ran
+---@return State state
+function Exec:root() end
+
+---The contents of a writable bind mount after the command ran
+---@param dest string The mount destination
+---@return State state
+function Exec:mount(dest) end